@@ -1,12 +1,17 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/connection"
 	"github.com/sessionforge/agent/internal/system"
 )
 
@@ -17,9 +22,11 @@ var authCmd = &cobra.Command{
 }
 
 var (
-	loginFlagServer string
-	loginFlagKey    string
-	loginFlagName   string
+	loginFlagServer  string
+	loginFlagKey     string
+	loginFlagName    string
+	loginFlagDevice  bool
+	loginFlagResetID bool
 )
 
 var loginCmd = &cobra.Command{
@@ -27,54 +34,91 @@ var loginCmd = &cobra.Command{
 	Short: "Authenticate and save credentials",
 	Long: `Login saves your API key and server URL to ~/.sessionforge/config.toml.
 
+With --device, no key is pasted: the agent shows a short code to approve in
+the dashboard and saves the credential the server issues for this machine.
+With --profile, the credentials are saved to that named profile instead.
+
+The machine ID is derived from the host (/etc/machine-id, the macOS hardware
+UUID or the Windows MachineGuid), so logging in again after a reinstall keeps
+the same machine. If config.toml was copied from another machine or the disk
+was cloned, --reset-id gives this host its own ID; the stored key is kept
+unless a new one is given.
+
 Example:
+  sessionforge auth login --device
   sessionforge auth login --key sf_live_abc123
-  sessionforge auth login --server https://self-hosted.example.com --key sf_live_abc123`,
+  sessionforge --profile staging auth login --server https://staging.example.com --key sf_live_def456
+  sessionforge auth login --server https://self-hosted.example.com --key sf_live_abc123
+  sessionforge auth login --reset-id`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.Load()
+		cfg, err := config.LoadFrom(flagConfigDir)
 		if err != nil {
 			return fmt.Errorf("load config: %w", err)
 		}
-
-		// Apply flags.
-		if loginFlagServer != "" {
-			cfg.ServerURL = loginFlagServer
+		profile, err := selectedProfile()
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
 		}
-		if loginFlagKey != "" {
-			cfg.APIKey = strings.TrimSpace(loginFlagKey)
+		// A new profile starts empty rather than copying another account.
+		var p config.Profile
+		if cfg.HasProfile(profile) {
+			p, _ = cfg.GetProfile(profile)
 		}
 
-		// Validate.
-		if cfg.APIKey == "" {
-			return fmt.Errorf("--key is required (get yours at %s/dashboard/api-keys)", cfg.ServerURL)
-		}
-		if !strings.HasPrefix(cfg.APIKey, "sf_") {
-			fmt.Fprintf(os.Stderr, "Warning: API key does not start with 'sf_'; are you sure it is correct?\n")
+		// Apply flags.
+		if loginFlagServer != "" {
+			p.ServerURL = loginFlagServer
 		}
 
-		// Generate machine ID if not already set.
-		if cfg.MachineID == "" {
-			cfg.MachineID = system.GenerateMachineID()
+		// Derive the machine ID from the host if not already set, or if a
+		// cloned machine asks for its own.
+		switch {
+		case p.MachineID == "" || loginFlagResetID:
+			p.MachineID = system.GenerateMachineID()
+		case system.IsForeignMachineID(p.MachineID):
+			fmt.Fprintf(os.Stderr, "Warning: machine ID %s was created on another host (cloned or copied config); add --reset-id to give this machine its own ID.\n", p.MachineID)
 		}
 
 		// Set machine name.
 		if loginFlagName != "" {
-			cfg.MachineName = loginFlagName
+			p.MachineName = loginFlagName
 		}
-		if cfg.MachineName == "" {
-			cfg.MachineName = system.GetHostname()
+		if p.MachineName == "" {
+			p.MachineName = system.GetHostname()
 		}
 
-		if err := config.Save(cfg); err != nil {
+		switch {
+		case loginFlagDevice && loginFlagKey != "":
+			return fmt.Errorf("use either --key or --device, not both")
+		case loginFlagDevice:
+			if err := deviceLogin(&p); err != nil {
+				return err
+			}
+		case loginFlagKey != "":
+			p.APIKey = strings.TrimSpace(loginFlagKey)
+			if !strings.HasPrefix(p.APIKey, "sf_") {
+				fmt.Fprintf(os.Stderr, "Warning: API key does not start with 'sf_'; are you sure it is correct?\n")
+			}
+		case loginFlagResetID && (p.APIKey != "" || p.APIKeyCommand != ""):
+			// Keep the stored credential; only the machine ID changes.
+		default:
+			return fmt.Errorf("--key or --device is required (get a key at %s/dashboard/api-keys)", p.ServerURL)
+		}
+
+		if err := cfg.SetProfile(profile, p); err != nil {
+			return err
+		}
+		if err := config.SaveFrom(flagConfigDir, cfg); err != nil {
 			return fmt.Errorf("save config: %w", err)
 		}
 
-		path, _ := config.ConfigPath()
+		path, _ := config.PathIn(flagConfigDir)
 		fmt.Println("Authentication saved.")
 		fmt.Printf("  Config file:  %s\n", path)
-		fmt.Printf("  Machine ID:   %s\n", cfg.MachineID)
-		fmt.Printf("  Machine name: %s\n", cfg.MachineName)
-		fmt.Printf("  Server URL:   %s\n", cfg.ServerURL)
+		fmt.Printf("  Profile:      %s\n", profile)
+		fmt.Printf("  Machine ID:   %s\n", p.MachineID)
+		fmt.Printf("  Machine name: %s\n", p.MachineName)
+		fmt.Printf("  Server URL:   %s\n", p.ServerURL)
 		fmt.Println()
 		fmt.Println("Next steps:")
 		fmt.Println("  sessionforge service install   — install as a system service")
@@ -83,31 +127,226 @@ Example:
 	},
 }
 
+// deviceLogin runs the device-code flow against p.ServerURL and stores the
+// issued credential in p.
+func deviceLogin(p *config.Profile) error {
+	// Proxy and TLS settings apply to the login requests too.
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	cfg.ServerURL = p.ServerURL
+	cfg.MachineID = p.MachineID
+	cfg.MachineName = p.MachineName
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	dc, err := connection.RequestDeviceCode(ctx, cfg, version)
+	if err != nil {
+		return err
+	}
+	fmt.Println("To authorize this machine, open:")
+	if dc.VerificationURIComplete != "" {
+		fmt.Printf("  %s\n", dc.VerificationURIComplete)
+		fmt.Println("and confirm the code:")
+	} else {
+		fmt.Printf("  %s\n", dc.VerificationURI)
+		fmt.Println("and enter the code:")
+	}
+	fmt.Printf("  %s\n\n", dc.UserCode)
+	fmt.Println("Waiting for approval (Ctrl-C to cancel)...")
+
+	cred, err := connection.PollDeviceCredential(ctx, cfg, version, dc)
+	if err != nil {
+		return fmt.Errorf("device login: %w", err)
+	}
+	p.APIKey = cred.APIKey
+	if cred.MachineID != "" {
+		p.MachineID = cred.MachineID
+	}
+	if cred.MachineName != "" {
+		p.MachineName = cred.MachineName
+	}
+	return nil
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Verify the stored credential with the server",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		if !cfg.HasAPIKey() {
+			return fmt.Errorf("not logged in — run: sessionforge auth login --device")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		id, err := connection.WhoAmI(ctx, cfg, version)
+		if connection.IsUnauthorized(err) {
+			return fmt.Errorf("%s rejected the stored credential — run: sessionforge auth login", cfg.ServerURL)
+		}
+		if err != nil {
+			return err
+		}
+
+		profile := cfg.ActiveProfile
+		if profile == "" {
+			profile = config.DefaultProfile
+		}
+		fmt.Printf("%-15s %s\n", "Server:", cfg.ServerURL)
+		fmt.Printf("%-15s %s\n", "Profile:", profile)
+		fmt.Printf("%-15s %s\n", "User:", orNA(id.User))
+		fmt.Printf("%-15s %s\n", "Organization:", orNA(id.Organization))
+		fmt.Printf("%-15s %s\n", "Key:", orNA(id.KeyName))
+		fmt.Printf("%-15s %s\n", "Machine ID:", orNA(id.MachineID))
+		fmt.Printf("%-15s %s\n", "Machine name:", orNA(id.MachineName))
+		if id.MachineID != "" && id.MachineID != cfg.MachineID {
+			fmt.Fprintf(os.Stderr, "Warning: the server knows this credential as machine %s, but machine_id is %s.\n",
+				id.MachineID, cfg.MachineID)
+		}
+		return nil
+	},
+}
+
 var logoutCmd = &cobra.Command{
 	Use:   "logout",
 	Short: "Remove saved credentials",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.Load()
+		cfg, err := config.LoadFrom(flagConfigDir)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		profile, err := selectedProfile()
 		if err != nil {
 			return fmt.Errorf("load config: %w", err)
 		}
+		p, err := cfg.GetProfile(profile)
+		if err != nil {
+			return err
+		}
 
-		cfg.APIKey = ""
-		if err := config.Save(cfg); err != nil {
+		p.APIKey = ""
+		if err := cfg.SetProfile(profile, p); err != nil {
+			return err
+		}
+		if err := config.SaveFrom(flagConfigDir, cfg); err != nil {
 			return fmt.Errorf("save config: %w", err)
 		}
 
-		fmt.Println("Logged out. API key removed from config.")
+		fmt.Printf("Logged out. API key removed from profile %q.\n", profile)
+		return nil
+	},
+}
+
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage named credential profiles",
+	Long: `Profiles keep credentials for several servers or accounts in one
+config.toml. Create one with 'sessionforge --profile <name> auth login',
+select it for every command with 'auth profiles use', or for a single
+command with --profile. The daemon also connects the profiles listed in
+serve_profiles.`,
+}
+
+var profilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles; the active one is marked with *",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadFrom(flagConfigDir)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		active, err := selectedProfile()
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		served := map[string]bool{}
+		for _, name := range cfg.ServedProfiles() {
+			served[name] = true
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "\tPROFILE\tSERVER URL\tMACHINE NAME\tAPI KEY\tSERVED")
+		for _, name := range cfg.ProfileNames() {
+			p, _ := cfg.GetProfile(name)
+			mark, serve := "", "no"
+			if name == active {
+				mark = "*"
+			}
+			if served[name] {
+				serve = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				mark, name, orNA(p.ServerURL), orNA(p.MachineName), maskKey(p.APIKey), serve)
+		}
+		return w.Flush()
+	},
+}
+
+var profilesUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Make a profile the default for every command",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadFrom(flagConfigDir)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		if _, err := cfg.GetProfile(args[0]); err != nil {
+			return err
+		}
+		cfg.ActiveProfile = args[0]
+		if args[0] == config.DefaultProfile {
+			cfg.ActiveProfile = ""
+		}
+		if err := config.SaveFrom(flagConfigDir, cfg); err != nil {
+			return fmt.Errorf("save config: %w", err)
+		}
+		fmt.Printf("Active profile: %s\n", args[0])
+		fmt.Println("Restart the agent service to connect with this profile.")
+		return nil
+	},
+}
+
+var profilesRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Delete a profile and its credentials",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadFrom(flagConfigDir)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		if err := cfg.RemoveProfile(args[0]); err != nil {
+			return err
+		}
+		if err := config.SaveFrom(flagConfigDir, cfg); err != nil {
+			return fmt.Errorf("save config: %w", err)
+		}
+		fmt.Printf("Removed profile %q.\n", args[0])
 		return nil
 	},
 }
 
 func init() {
 	loginCmd.Flags().StringVar(&loginFlagServer, "server", config.DefaultServerURL, "SessionForge server URL")
-	loginCmd.Flags().StringVar(&loginFlagKey, "key", "", "API key (required)")
+	loginCmd.Flags().StringVar(&loginFlagKey, "key", "", "API key")
+	loginCmd.Flags().BoolVar(&loginFlagDevice, "device", false, "Log in by approving a code in the dashboard instead of pasting a key")
+	loginCmd.Flags().BoolVar(&loginFlagResetID, "reset-id", false, "Replace the machine ID with one derived from this host, e.g. on a cloned machine")
 	loginCmd.Flags().StringVar(&loginFlagName, "name", "", "Human-readable name for this machine (default: hostname)")
-	_ = loginCmd.MarkFlagRequired("key")
 
 	authCmd.AddCommand(loginCmd)
 	authCmd.AddCommand(logoutCmd)
+	authCmd.AddCommand(whoamiCmd)
+	authCmd.AddCommand(profilesCmd)
+
+	profilesCmd.AddCommand(profilesListCmd)
+	profilesCmd.AddCommand(profilesUseCmd)
+	profilesCmd.AddCommand(profilesRemoveCmd)
 }
@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// loadConfig returns the effective configuration for commands that only
+// read it: config.toml in --config-dir, then SESSIONFORGE_* environment
+// overrides, then command-line flags, with the selected profile's
+// credentials applied.
+func loadConfig() (*config.Config, error) {
+	cfg, _, err := effectiveConfig(flagConfigDir)
+	return cfg, err
+}
+
+// agentDir returns the directory holding config.toml, honouring
+// --config-dir. The daemon keeps its outbox, state file and control socket
+// there, so CLI commands must resolve it the same way to find them; the
+// Windows service runs with --config-dir pointing at the user's directory.
+func agentDir() (string, error) {
+	path, err := config.PathIn(flagConfigDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(path), nil
+}
+
+// effectiveConfig layers flags over config.LoadEffective and reports where
+// each value came from.
+func effectiveConfig(dir string) (*config.Config, config.Sources, error) {
+	return effectiveConfigFor(dir, flagProfile)
+}
+
+// effectiveConfigFor is effectiveConfig with profile, when non-empty, in
+// place of active_profile. The daemon uses it for each served profile.
+func effectiveConfigFor(dir, profile string) (*config.Config, config.Sources, error) {
+	cfg, sources, err := config.LoadEffective(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if flagLogLevel != "" {
+		cfg.LogLevel = flagLogLevel
+		sources["log_level"] = config.SourceFlag
+	}
+	if profile != "" {
+		cfg.ActiveProfile = profile
+		sources["active_profile"] = config.SourceFlag
+	}
+	if err := config.ApplyProfile(cfg, sources, cfg.ActiveProfile); err != nil {
+		return nil, nil, err
+	}
+	return cfg, sources, nil
+}
+
+// selectedProfile returns the profile that auth commands act on: --profile,
+// else active_profile after environment overrides.
+func selectedProfile() (string, error) {
+	if flagProfile != "" {
+		return flagProfile, nil
+	}
+	cfg, _, err := config.LoadEffective(flagConfigDir)
+	if err != nil {
+		return "", err
+	}
+	if cfg.ActiveProfile == "" {
+		return config.DefaultProfile, nil
+	}
+	return cfg.ActiveProfile, nil
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and edit the agent configuration",
+	Long: `Reads and writes config.toml.
+
+Every key can be overridden with an environment variable named
+SESSIONFORGE_<KEY>, upper-cased with dots as underscores (for example
+SESSIONFORGE_LOG_LEVEL or SESSIONFORGE_RESTART_MODE); lists are
+comma-separated. Precedence, highest first: flags, environment, config
+file, defaults. Credentials from the selected profile (--profile or
+active_profile) replace the top-level ones unless set by a flag or the
+environment. 'set' and 'unset' only change the file.`,
+}
+
+var configFlagSource bool
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the effective value of a key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, sources, err := effectiveConfig(flagConfigDir)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		value, err := config.Get(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		if configFlagSource {
+			fmt.Printf("%s\t(%s)\n", value, describeSource(args[0], sources[args[0]]))
+			return nil
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a key in config.toml",
+	Long: `Set a key in config.toml. Lists are comma-separated.
+
+Example:
+  sessionforge config set log_level debug
+  sessionforge config set server_urls https://eu.example.com,https://us.example.com`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editConfig(args[0], func(cfg *config.Config) error {
+			return config.Set(cfg, args[0], args[1])
+		})
+	},
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Reset a key in config.toml to its default",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editConfig(args[0], func(cfg *config.Config) error {
+			return config.Unset(cfg, args[0])
+		})
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every key with its effective value and source",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, sources, err := effectiveConfig(flagConfigDir)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		for _, key := range config.Keys() {
+			value, _ := config.Get(cfg, key)
+			if key == "api_key" {
+				value = maskKey(value)
+			}
+			fmt.Printf("%-28s = %-30s # %s\n", key, value, describeSource(key, sources[key]))
+		}
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the effective configuration for errors",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		unknown, err := config.UnknownKeys(flagConfigDir)
+		if err != nil {
+			return err
+		}
+		for _, key := range unknown {
+			fmt.Fprintf(os.Stderr, "Warning: unknown key %q is ignored\n", key)
+		}
+		path, _ := config.PathIn(flagConfigDir)
+		fmt.Printf("Config OK: %s (config_version %d)\n", path, cfg.ConfigVersion)
+		return nil
+	},
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of config.toml",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := config.PathIn(flagConfigDir)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	},
+}
+
+// editConfig applies edit to the file-only configuration, validates the
+// result and saves it atomically. Environment and flag overrides are never
+// written back.
+func editConfig(key string, edit func(*config.Config) error) error {
+	cfg, err := config.LoadFrom(flagConfigDir)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if err := edit(cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if err := config.SaveFrom(flagConfigDir, cfg); err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+	if _, ok := os.LookupEnv(config.EnvName(key)); ok {
+		fmt.Fprintf(os.Stderr, "Warning: %s is set and overrides the saved value.\n", config.EnvName(key))
+	}
+	return nil
+}
+
+// describeSource names the layer a value came from, including the variable
+// for environment overrides.
+func describeSource(key string, src config.Source) string {
+	if src == config.SourceEnv {
+		return fmt.Sprintf("%s %s", src, config.EnvName(key))
+	}
+	return string(src)
+}
+
+func init() {
+	configGetCmd.Flags().BoolVar(&configFlagSource, "source", false, "Also print where the value came from")
+
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configPathCmd)
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sessionforge/agent/internal/session"
+)
+
+// controlSocket is the Unix socket in the config directory through which
+// CLI commands reach the running daemon without registering with the cloud
+// as the machine. The directory is 0700 and the socket 0600, so only the
+// agent's own user can connect.
+const controlSocket = "agent.sock"
+
+// controlTimeout bounds one control request on either side.
+const controlTimeout = 5 * time.Second
+
+// controlRequest is one command sent to the daemon.
+type controlRequest struct {
+	Type      string `json:"type"`
+	SessionID string `json:"sessionId"`
+	Signal    string `json:"signal,omitempty"`
+	Target    string `json:"target,omitempty"`
+}
+
+// controlReply is the daemon's answer; Error is empty on success.
+type controlReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// errDaemonNotRunning means no daemon is listening on the control socket.
+var errDaemonNotRunning = errors.New("the agent is not running; start it with `sessionforge` or `sessionforge service start`")
+
+// serveControl answers control requests for the sessions of managers until
+// ctx is cancelled.
+func serveControl(ctx context.Context, dir string, managers []*session.Manager, logger *slog.Logger) error {
+	path := filepath.Join(dir, controlSocket)
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another agent is already listening on %s", path)
+	}
+	// A daemon that did not shut down cleanly leaves its socket behind.
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return fmt.Errorf("chmod %s: %w", path, err)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Warn("control: accept failed", "err", err)
+				}
+				return
+			}
+			go handleControl(conn, managers, logger)
+		}
+	}()
+	return nil
+}
+
+// handleControl serves one request on conn.
+func handleControl(conn net.Conn, managers []*session.Manager, logger *slog.Logger) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req controlRequest
+	var reply controlReply
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		reply.Error = fmt.Sprintf("bad request: %v", err)
+	} else if err := dispatchControl(req, managers); err != nil {
+		reply.Error = err.Error()
+	}
+	logger.Info("control: request", "type", req.Type, "sessionId", req.SessionID, "err", reply.Error)
+	_ = json.NewEncoder(conn).Encode(reply)
+}
+
+// dispatchControl applies req to whichever manager owns the session.
+func dispatchControl(req controlRequest, managers []*session.Manager) error {
+	switch req.Type {
+	case "signal_session":
+	default:
+		return fmt.Errorf("unknown request %q", req.Type)
+	}
+	for _, mgr := range managers {
+		if _, err := mgr.Get(req.SessionID); err == nil {
+			return mgr.Signal(req.SessionID, req.Signal, req.Target)
+		}
+	}
+	return fmt.Errorf("session %s is not running on this machine", req.SessionID)
+}
+
+// sendControl delivers req to the daemon using dir and waits for its reply.
+func sendControl(dir string, req controlRequest) error {
+	conn, err := net.DialTimeout("unix", filepath.Join(dir, controlSocket), controlTimeout)
+	if err != nil {
+		return errDaemonNotRunning
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("send to agent: %w", err)
+	}
+	var reply controlReply
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return fmt.Errorf("read agent reply: %w", err)
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sessionforge/agent/internal/session"
+)
+
+type discardMessenger struct{}
+
+func (discardMessenger) SendJSON(any) error { return nil }
+
+func TestControl_SignalReachesDaemon(t *testing.T) {
+	dir := t.TempDir()
+	req := controlRequest{Type: "signal_session", SessionID: "abc", Signal: "SIGINT"}
+	if err := sendControl(dir, req); !errors.Is(err, errDaemonNotRunning) {
+		t.Fatalf("no daemon: err = %v, want errDaemonNotRunning", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mgr := session.NewManager(ctx, discardMessenger{}, logger)
+	if err := serveControl(ctx, dir, []*session.Manager{mgr}, logger); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, controlSocket)); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("control socket mode = %v, %v; want 0600", fi.Mode().Perm(), err)
+	}
+	if err := serveControl(ctx, dir, nil, logger); err == nil {
+		t.Error("second daemon took over a live control socket")
+	}
+
+	err := sendControl(dir, req)
+	if err == nil || !strings.Contains(err.Error(), "not running on this machine") {
+		t.Fatalf("unknown session: err = %v", err)
+	}
+	req.Type = "stop_everything"
+	if err := sendControl(dir, req); err == nil || !strings.Contains(err.Error(), "unknown request") {
+		t.Fatalf("unknown request: err = %v", err)
+	}
+}
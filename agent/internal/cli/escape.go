@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// escapeAction is a local command recognised in the stdin stream of
+// `sessionforge run` and `sessionforge session attach`.
+type escapeAction int
+
+const (
+	escapeNone escapeAction = iota
+	// escapeDetach leaves the session running and returns to the shell.
+	escapeDetach
+	// escapeStatus prints a one-line summary of the session.
+	escapeStatus
+	// escapeForceStop kills the session (SIGKILL on Unix).
+	escapeForceStop
+	// escapeHelp lists the supported escape sequences.
+	escapeHelp
+)
+
+// escapeParser recognises ssh-style escape sequences and the detach key
+// anywhere in a byte stream, independent of how reads are chunked.
+//
+// Escape sequences are only recognised directly after a newline (or at the
+// very start of input), exactly like ssh:
+//
+//	~.  detach
+//	~s  show session status
+//	~K  force-stop the session
+//	~?  list escape sequences
+//	~~  send a literal escape character
+//
+// Any other character following the escape character is forwarded together
+// with the escape character. The detach key (Ctrl+] by default) detaches
+// wherever it appears.
+type escapeParser struct {
+	escapeChar byte
+	hasEscape  bool
+	detachKey  byte
+	hasDetach  bool
+
+	atLineStart bool // the next byte begins a new line
+	pending     bool // the escape character was seen at line start
+}
+
+// newEscapeParser builds a parser from config-style strings. An empty string
+// selects the default; "none" disables that mechanism.
+func newEscapeParser(escapeChar, detachKey string) (*escapeParser, error) {
+	p := &escapeParser{atLineStart: true}
+
+	if escapeChar == "" {
+		escapeChar = config.DefaultEscapeChar
+	}
+	if !strings.EqualFold(escapeChar, "none") {
+		if len(escapeChar) != 1 || escapeChar[0] < 0x21 || escapeChar[0] > 0x7e {
+			return nil, fmt.Errorf("invalid escape character %q: must be a single printable ASCII character or \"none\"", escapeChar)
+		}
+		p.escapeChar = escapeChar[0]
+		p.hasEscape = true
+	}
+
+	if detachKey == "" {
+		detachKey = config.DefaultDetachKey
+	}
+	if !strings.EqualFold(detachKey, "none") {
+		b, err := parseControlKey(detachKey)
+		if err != nil {
+			return nil, err
+		}
+		p.detachKey = b
+		p.hasDetach = true
+	}
+
+	return p, nil
+}
+
+// parseControlKey converts "ctrl-]", "ctrl+]" or "^]" into the byte the
+// terminal sends for that key (e.g. 0x1d).
+func parseControlKey(s string) (byte, error) {
+	var key string
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(lower, "ctrl-"), strings.HasPrefix(lower, "ctrl+"):
+		key = s[5:]
+	case strings.HasPrefix(s, "^"):
+		key = s[1:]
+	}
+	if len(key) != 1 {
+		return 0, fmt.Errorf("invalid detach key %q: use ctrl-<key> (e.g. ctrl-]) or \"none\"", s)
+	}
+	c := key[0]
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if c < '@' || c > '_' {
+		return 0, fmt.Errorf("invalid detach key %q: %q has no control code", s, key)
+	}
+	return c & 0x1f, nil
+}
+
+// describe returns a short human-readable hint such as
+// "Ctrl+] or ~. to detach" for the session banner.
+func (p *escapeParser) describe() string {
+	var parts []string
+	if p.hasDetach {
+		parts = append(parts, "Ctrl+"+string(rune(p.detachKey|0x40)))
+	}
+	if p.hasEscape {
+		parts = append(parts, string(p.escapeChar)+".")
+	}
+	if len(parts) == 0 {
+		return "detach disabled"
+	}
+	hint := strings.Join(parts, " or ") + " to detach"
+	if p.hasEscape {
+		hint += ", " + string(p.escapeChar) + "? for help"
+	}
+	return hint
+}
+
+// help returns the escape sequence listing printed for "~?". Lines end in
+// \r\n because the terminal is in raw mode.
+func (p *escapeParser) help() string {
+	var b strings.Builder
+	b.WriteString("Supported escape sequences:\r\n")
+	if p.hasEscape {
+		e := string(p.escapeChar)
+		fmt.Fprintf(&b, "  %s.  - detach (session keeps running)\r\n", e)
+		fmt.Fprintf(&b, "  %ss  - show session status\r\n", e)
+		fmt.Fprintf(&b, "  %sK  - force-stop the session\r\n", e)
+		fmt.Fprintf(&b, "  %s?  - this message\r\n", e)
+		fmt.Fprintf(&b, "  %s%s  - send the escape character\r\n", e, e)
+		b.WriteString("(Escapes are only recognized immediately after newline.)\r\n")
+	}
+	if p.hasDetach {
+		fmt.Fprintf(&b, "  Ctrl+%c - detach\r\n", p.detachKey|0x40)
+	}
+	return b.String()
+}
+
+// Feed scans data and returns the bytes to forward to the session, the first
+// action encountered (escapeNone if none) and the number of input bytes
+// consumed. Callers loop until all input is consumed so that bytes before an
+// action are forwarded before the action runs.
+func (p *escapeParser) Feed(data []byte) (fwd []byte, action escapeAction, n int) {
+	for n < len(data) {
+		b := data[n]
+		n++
+
+		if p.hasDetach && b == p.detachKey {
+			p.pending = false
+			return fwd, escapeDetach, n
+		}
+
+		if p.pending {
+			p.pending = false
+			switch b {
+			case '.':
+				return fwd, escapeDetach, n
+			case 's':
+				return fwd, escapeStatus, n
+			case 'K':
+				return fwd, escapeForceStop, n
+			case '?':
+				return fwd, escapeHelp, n
+			case p.escapeChar:
+				fwd = append(fwd, b)
+				p.atLineStart = false
+				continue
+			default:
+				// Not an escape after all: send what the user typed.
+				fwd = append(fwd, p.escapeChar)
+			}
+		} else if p.hasEscape && p.atLineStart && b == p.escapeChar {
+			p.pending = true
+			p.atLineStart = false
+			continue
+		}
+
+		fwd = append(fwd, b)
+		p.atLineStart = b == '\r' || b == '\n'
+	}
+	return fwd, escapeNone, n
+}
+
+// pumpStdin reads r until EOF, forwarding ordinary input via write and
+// dispatching escape actions to onAction. It returns when r fails or when
+// onAction reports that the session should be left.
+func pumpStdin(r io.Reader, p *escapeParser, write func([]byte) error, onAction func(escapeAction) (stop bool)) error {
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		data := buf[:n]
+		for len(data) > 0 {
+			fwd, action, used := p.Feed(data)
+			data = data[used:]
+			if len(fwd) > 0 {
+				_ = write(fwd)
+			}
+			if action != escapeNone && onAction(action) {
+				return nil
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+var (
+	flagEscapeChar string
+	flagDetachKey  string
+)
+
+// addEscapeFlags registers --escape-char and --detach-key on an interactive command.
+func addEscapeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&flagEscapeChar, "escape-char", "e", "",
+		`Escape character for ~. style sequences, or "none" (default from config: "~")`)
+	cmd.Flags().StringVar(&flagDetachKey, "detach-key", "",
+		`Key that detaches immediately, e.g. ctrl-] or ^], or "none" (default from config: "ctrl-]")`)
+}
+
+// escapeParserFor builds the parser for an interactive command. Flags take
+// precedence over escape_char / detach_key in config.toml.
+func escapeParserFor(cfg *config.Config) (*escapeParser, error) {
+	escapeChar := cfg.EscapeChar
+	if flagEscapeChar != "" {
+		escapeChar = flagEscapeChar
+	}
+	detachKey := cfg.DetachKey
+	if flagDetachKey != "" {
+		detachKey = flagDetachKey
+	}
+	return newEscapeParser(escapeChar, detachKey)
+}
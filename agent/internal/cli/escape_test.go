@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+)
+
+// feedAll runs every chunk through p and returns the forwarded bytes and the
+// actions in the order they were produced.
+func feedAll(p *escapeParser, chunks ...string) (string, []escapeAction) {
+	var fwd []byte
+	var actions []escapeAction
+	for _, c := range chunks {
+		data := []byte(c)
+		for len(data) > 0 {
+			out, action, n := p.Feed(data)
+			data = data[n:]
+			fwd = append(fwd, out...)
+			if action != escapeNone {
+				actions = append(actions, action)
+			}
+		}
+	}
+	return string(fwd), actions
+}
+
+func mustParser(t *testing.T, escapeChar, detachKey string) *escapeParser {
+	t.Helper()
+	p, err := newEscapeParser(escapeChar, detachKey)
+	if err != nil {
+		t.Fatalf("newEscapeParser(%q, %q): %v", escapeChar, detachKey, err)
+	}
+	return p
+}
+
+func TestEscapeParser_DetachKeyAnywhere(t *testing.T) {
+	p := mustParser(t, "", "")
+	fwd, action, n := p.Feed([]byte("ls -la\x1dmore"))
+	if string(fwd) != "ls -la" || n != 7 {
+		t.Fatalf("forwarded %q consumed %d, want %q consumed 7", fwd, n, "ls -la")
+	}
+	if action != escapeDetach {
+		t.Fatalf("action = %v, want detach", action)
+	}
+}
+
+func TestEscapeParser_TildeDotAfterNewline(t *testing.T) {
+	p := mustParser(t, "", "")
+	fwd, actions := feedAll(p, "echo hi\r~.")
+	if fwd != "echo hi\r" {
+		t.Fatalf("forwarded %q", fwd)
+	}
+	if len(actions) != 1 || actions[0] != escapeDetach {
+		t.Fatalf("actions = %v, want [detach]", actions)
+	}
+}
+
+func TestEscapeParser_SplitAcrossReads(t *testing.T) {
+	p := mustParser(t, "", "")
+	_, actions := feedAll(p, "\n", "~", ".")
+	if len(actions) != 1 || actions[0] != escapeDetach {
+		t.Fatalf("actions = %v, want [detach]", actions)
+	}
+}
+
+func TestEscapeParser_TildeMidLineIsLiteral(t *testing.T) {
+	p := mustParser(t, "", "")
+	fwd, actions := feedAll(p, "cd ~/.config\r")
+	if fwd != "cd ~/.config\r" || len(actions) != 0 {
+		t.Fatalf("forwarded %q actions %v", fwd, actions)
+	}
+}
+
+func TestEscapeParser_Commands(t *testing.T) {
+	p := mustParser(t, "", "")
+	fwd, actions := feedAll(p, "~s\r~?\r~K")
+	want := []escapeAction{escapeStatus, escapeHelp, escapeForceStop}
+	if len(actions) != len(want) {
+		t.Fatalf("actions = %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("actions = %v, want %v", actions, want)
+		}
+	}
+	if fwd != "\r\r" {
+		t.Fatalf("forwarded %q", fwd)
+	}
+}
+
+func TestEscapeParser_LiteralEscapeChar(t *testing.T) {
+	p := mustParser(t, "", "")
+	fwd, actions := feedAll(p, "~~.", "\r~x")
+	if fwd != "~.\r~x" || len(actions) != 0 {
+		t.Fatalf("forwarded %q actions %v", fwd, actions)
+	}
+}
+
+func TestEscapeParser_CustomAndDisabled(t *testing.T) {
+	p := mustParser(t, "%", "ctrl-b")
+	fwd, actions := feedAll(p, "~.\x1d%.")
+	if fwd != "~.\x1d%." {
+		t.Fatalf("forwarded %q", fwd)
+	}
+	if len(actions) != 0 {
+		// "%." is mid-line here, so nothing fires yet.
+		t.Fatalf("actions = %v, want none", actions)
+	}
+	if _, actions = feedAll(p, "\x02"); len(actions) != 1 || actions[0] != escapeDetach {
+		t.Fatalf("ctrl-b should detach, got %v", actions)
+	}
+
+	off := mustParser(t, "none", "none")
+	fwd, actions = feedAll(off, "~.\x1d")
+	if fwd != "~.\x1d" || len(actions) != 0 {
+		t.Fatalf("disabled parser forwarded %q actions %v", fwd, actions)
+	}
+}
+
+func TestParseControlKey(t *testing.T) {
+	cases := map[string]byte{"ctrl-]": 0x1d, "^]": 0x1d, "Ctrl+A": 0x01, "ctrl-\\": 0x1c}
+	for in, want := range cases {
+		got, err := parseControlKey(in)
+		if err != nil || got != want {
+			t.Errorf("parseControlKey(%q) = %#x, %v; want %#x", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"]", "ctrl-", "ctrl-ab", "ctrl-1"} {
+		if _, err := parseControlKey(bad); err == nil {
+			t.Errorf("parseControlKey(%q) should fail", bad)
+		}
+	}
+}
+
+func TestPumpStdin_StopsOnDetach(t *testing.T) {
+	p := mustParser(t, "", "")
+	var written []byte
+	err := pumpStdin(bytes.NewReader([]byte("ls\n~.ignored")), p,
+		func(data []byte) error { written = append(written, data...); return nil },
+		func(a escapeAction) bool { return a == escapeDetach })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "ls\n" {
+		t.Fatalf("written %q, want %q", written, "ls\n")
+	}
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/connection"
+	"github.com/sessionforge/agent/internal/session"
+)
+
+// logLevel is shared by every logger built with buildLogger so the daemon
+// can change verbosity without rebuilding its logger.
+var logLevel slog.LevelVar
+
+// parseLogLevel maps a config or flag value to a slog level; unknown values
+// fall back to info.
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// liveConfig owns the daemon's configuration after startup and applies the
+// settings that can change without restarting, keeping sessions alive.
+type liveConfig struct {
+	mu  sync.Mutex
+	dir string // config directory; "" for the default
+	// profile is the profile to reload, "" to follow active_profile.
+	profile string
+	// primary is set for the active profile's agent, which alone controls
+	// daemon-wide settings such as the log level.
+	primary bool
+	cfg     *config.Config
+	mgr     *session.Manager
+	client  *connection.Client
+	logger  *slog.Logger
+}
+
+// applyLive pushes the runtime-adjustable settings of cfg into the running
+// components. The command allow-list is applied first because it is the only
+// step that can fail.
+func (lc *liveConfig) applyLive(cfg *config.Config) error {
+	if err := lc.mgr.SetAllowedCommands(cfg.AllowedCommands); err != nil {
+		return err
+	}
+	if lc.primary && flagLogLevel == "" {
+		logLevel.Set(parseLogLevel(cfg.LogLevel))
+	}
+	lc.client.SetHeartbeatInterval(time.Duration(cfg.HeartbeatInterval) * time.Second)
+	lc.mgr.SetExitTailLines(cfg.ExitTailLines)
+	lc.mgr.SetDefaultRestartPolicy(cfg.Restart)
+	lc.mgr.SetDefaultLimits(cfg.SessionLimits)
+	return nil
+}
+
+// ApplyRemoteConfig implements connection.ConfigApplier: it verifies a
+// config_update, applies it live and persists it to config.toml.
+func (lc *liveConfig) ApplyRemoteConfig(version int64, payload []byte, signature string) ([]string, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if err := config.VerifyRemoteConfig(lc.cfg.ConfigSigningKey, version, payload, signature); err != nil {
+		return nil, err
+	}
+	if version <= lc.cfg.RemoteConfigVersion {
+		return nil, fmt.Errorf("version %d is not newer than the applied version %d", version, lc.cfg.RemoteConfigVersion)
+	}
+	rc, err := config.ParseRemoteConfig(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !lc.primary && rc.LogLevel != nil {
+		lc.logger.Warn("config_update: ignoring logLevel; only the active profile's server sets the daemon log level")
+		rc.LogLevel = nil
+	}
+
+	next := *lc.cfg
+	changed := rc.ApplyTo(&next)
+	if err := lc.applyLive(&next); err != nil {
+		return nil, err
+	}
+	rc.ApplyTo(lc.cfg)
+	lc.cfg.RemoteConfigVersion = version
+	if err := lc.persistRemote(rc, version); err != nil {
+		// The settings are live, so report success; they will be lost on
+		// restart until the cloud pushes them again.
+		lc.logger.Error("config_update applied but not saved", "version", version, "err", err)
+	}
+	return changed, nil
+}
+
+// persistRemote writes a config_update into config.toml, under the
+// profile's own table unless it is the default profile. It edits the file
+// as it is on disk rather than lc.cfg, so environment and flag overrides
+// are not written back.
+func (lc *liveConfig) persistRemote(rc *config.RemoteConfig, version int64) error {
+	file, err := config.LoadFrom(lc.dir)
+	if err != nil {
+		return err
+	}
+	if err := file.StoreRemoteConfig(lc.profileName(), rc, version); err != nil {
+		return err
+	}
+	return config.SaveFrom(lc.dir, file)
+}
+
+// profileName returns the profile this agent serves. Caller holds lc.mu.
+func (lc *liveConfig) profileName() string {
+	if lc.profile != "" {
+		return lc.profile
+	}
+	return lc.cfg.ActiveProfile
+}
+
+// RotateCredentials implements connection.CredentialRotator: it saves a key
+// issued by the cloud to config.toml and reconnects with it.
+func (lc *liveConfig) RotateCredentials(apiKey string, grace time.Duration) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	_, sources, err := effectiveConfigFor(lc.dir, lc.profile)
+	if err != nil {
+		return err
+	}
+	if err := checkRotatable(lc.cfg, sources); err != nil {
+		return err
+	}
+	if err := saveRotatedKey(lc.dir, lc.profileName(), apiKey); err != nil {
+		return fmt.Errorf("save rotated key: %w", err)
+	}
+	// Updated before the file watcher fires, so the reload finds nothing
+	// to do.
+	lc.client.RotateCredentials(apiKey, grace)
+	lc.cfg.APIKey = apiKey
+	return nil
+}
@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/connection"
+)
+
+const (
+	// configSettleDelay is how long the daemon waits after the last change
+	// notification before reading config.toml, so an editor's save, which
+	// may rename, create and write the file in turn, causes one reload.
+	configSettleDelay = 200 * time.Millisecond
+	// configPollInterval is how often the daemon checks config.toml for
+	// edits when the OS cannot watch its directory.
+	configPollInterval = 2 * time.Second
+)
+
+// reloadableFields are the config.toml keys applied to a running daemon.
+// Anything else (server, proxy, TLS, log file) is read once at startup and
+// only takes effect after a restart. config_signing_key and
+// remote_config_version are deliberately excluded: they guard config_update
+// against forgery and replay, and a local edit must not reset that while
+// the agent runs.
+var reloadableFields = map[string]bool{
+	"log_level":            true,
+	"claude_path":          true,
+	"claude_config_dir":    true,
+	"claude_installed_via": true,
+	"escape_char":          true,
+	"detach_key":           true,
+	"on_unauthorized":      true,
+	"exit_tail_lines":      true,
+	"heartbeat_interval":   true,
+	"allowed_commands":     true,
+	"restart":              true,
+	"idle_timeout":         true,
+	"max_runtime":          true,
+	"timeout_action":       true,
+	"timeout_warning":      true,
+	"session_profiles":     true,
+	"update_channel":       true,
+	// The served profile's own table is already overlaid onto the keys
+	// above, so edits to the tables of other profiles, such as a
+	// config_update saved by another agent of this daemon, need no restart.
+	"profiles": true,
+}
+
+// credentialFields change the API key. The daemon switches to the new key
+// and reconnects, e.g. after `sessionforge auth rotate` or `auth login`.
+var credentialFields = map[string]bool{
+	"api_key":         true,
+	"api_key_command": true,
+}
+
+// watch reloads the config when the file changes or a reload signal
+// (SIGHUP on Unix) arrives, until ctx is cancelled.
+//
+// The watch is placed on the directory rather than the file, because
+// editors that save via rename replace the file's inode. Where the
+// directory cannot be watched, e.g. with inotify instances exhausted, the
+// daemon checks the file every configPollInterval instead.
+func (lc *liveConfig) watch(ctx context.Context) {
+	path, err := config.PathIn(lc.dir)
+	if err != nil {
+		lc.logger.Warn("config: hot reload disabled", "err", err)
+		return
+	}
+	sigCh := make(chan os.Signal, 1)
+	notifyReload(sigCh)
+	defer signal.Stop(sigCh)
+
+	var events <-chan fsnotify.Event
+	var watchErrs <-chan error
+	var poll <-chan time.Time
+	if watcher, err := watchDir(filepath.Dir(path)); err != nil {
+		lc.logger.Warn("config: cannot watch config directory, polling instead", "err", err, "interval", configPollInterval)
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	} else {
+		defer watcher.Close()
+		events, watchErrs = watcher.Events, watcher.Errors
+	}
+
+	settle := time.NewTimer(configSettleDelay)
+	settle.Stop()
+	defer settle.Stop()
+
+	last := fingerprint(path)
+	changed := func() {
+		if fp := fingerprint(path); fp != last {
+			last = fp
+			lc.reload("file changed")
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigCh:
+			last = fingerprint(path)
+			lc.reload("signal " + sig.String())
+		case ev := <-events:
+			if filepath.Base(ev.Name) == filepath.Base(path) {
+				settle.Reset(configSettleDelay)
+			}
+		case err := <-watchErrs:
+			lc.logger.Warn("config: watching config directory", "err", err)
+		case <-settle.C:
+			changed()
+		case <-poll:
+			changed()
+		}
+	}
+}
+
+// watchDir starts watching dir for changes to the files in it.
+func watchDir(dir string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// fingerprint hashes the config file so a touch without changes does not
+// trigger a reload. A missing file hashes to the zero value.
+func fingerprint(path string) [sha256.Size]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// reload re-reads and validates config.toml and applies the runtime-safe
+// changes. An invalid file is reported and the current settings are kept.
+func (lc *liveConfig) reload(reason string) {
+	next, _, err := effectiveConfigFor(lc.dir, lc.profile)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		lc.logger.Error("config: reload failed, keeping current settings", "reason", reason, "err", err)
+		return
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	var live, creds, restart []string
+	for _, field := range config.ChangedFields(lc.cfg, next) {
+		switch {
+		case reloadableFields[field]:
+			live = append(live, field)
+		case credentialFields[field]:
+			creds = append(creds, field)
+		default:
+			restart = append(restart, field)
+		}
+	}
+	if len(live) == 0 && len(creds) == 0 && len(restart) == 0 {
+		lc.logger.Debug("config: reload found no changes", "reason", reason)
+		return
+	}
+
+	if len(live) > 0 {
+		if err := lc.applyLive(next); err != nil {
+			lc.logger.Error("config: reload failed, keeping current settings", "reason", reason, "err", err)
+			return
+		}
+		lc.mgr.SetClaudePath(next.ClaudePath)
+		lc.mgr.SetClaudeConfigDir(next.ClaudeConfigDir)
+		lc.mgr.SetSessionProfiles(next.SessionProfiles)
+		lc.copyReloadable(next)
+		lc.logger.Info("config: reloaded", "reason", reason, "applied", live)
+	}
+	if len(creds) > 0 {
+		lc.swapCredentials(next, reason)
+	}
+	if len(restart) > 0 {
+		lc.logger.Warn("config: restart the agent to apply these changes", "fields", restart)
+	}
+}
+
+// copyReloadable adopts the reloadable fields of next. Caller holds lc.mu.
+func (lc *liveConfig) copyReloadable(next *config.Config) {
+	lc.cfg.LogLevel = next.LogLevel
+	lc.cfg.ClaudePath = next.ClaudePath
+	lc.cfg.ClaudeConfigDir = next.ClaudeConfigDir
+	lc.cfg.ClaudeInstalledVia = next.ClaudeInstalledVia
+	lc.cfg.EscapeChar = next.EscapeChar
+	lc.cfg.DetachKey = next.DetachKey
+	lc.cfg.OnUnauthorized = next.OnUnauthorized
+	lc.cfg.ExitTailLines = next.ExitTailLines
+	lc.cfg.HeartbeatInterval = next.HeartbeatInterval
+	lc.cfg.AllowedCommands = next.AllowedCommands
+	lc.cfg.Restart = next.Restart
+	lc.cfg.SessionLimits = next.SessionLimits
+	lc.cfg.SessionProfiles = next.SessionProfiles
+	lc.cfg.UpdateChannel = next.UpdateChannel
+	lc.cfg.Profiles = next.Profiles
+}
+
+// swapCredentials moves the client to the API key of next. The old key is
+// kept as a fallback for the default grace period. Caller holds lc.mu.
+func (lc *liveConfig) swapCredentials(next *config.Config, reason string) {
+	config.ForgetAPIKey()
+	key, err := next.ResolveAPIKey()
+	if err != nil {
+		lc.logger.Error("config: new API key unavailable, keeping the current one", "reason", reason, "err", err)
+		return
+	}
+	lc.client.RotateCredentials(key, connection.DefaultRotationGrace)
+	lc.cfg.APIKey = next.APIKey
+	lc.cfg.APIKeyCommand = next.APIKeyCommand
+	lc.logger.Info("config: API key changed, reconnecting", "reason", reason)
+}
+
+// stopOnUnauthorized reports whether on_unauthorized asks for sessions to be
+// stopped when the server rejects the API key.
+func (lc *liveConfig) stopOnUnauthorized() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return strings.EqualFold(lc.cfg.OnUnauthorized, config.UnauthorizedStop)
+}
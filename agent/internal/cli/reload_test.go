@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/connection"
+	"github.com/sessionforge/agent/internal/session"
+)
+
+// testLiveConfig builds a liveConfig over a config file in a temp dir and
+// returns the buffer its logger writes to.
+func testLiveConfig(t *testing.T, initial string) (*liveConfig, *bytes.Buffer) {
+	t.Helper()
+	dir := t.TempDir()
+	writeConfig(t, dir, initial)
+	cfg, err := config.LoadFrom(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := connection.NewClient(cfg, "test", func(connection.CloudMessage) {}, logger)
+	lc := &liveConfig{dir: dir, primary: true, cfg: cfg, mgr: session.NewManager(ctx, client, logger), client: client, logger: logger}
+	if err := lc.applyLive(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return lc, &logs
+}
+
+func writeConfig(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "config.toml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload_AppliesLiveFieldsAndReportsRestartFields(t *testing.T) {
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+	lc, logs := testLiveConfig(t, `
+server_url = "https://a.example.com"
+log_level = "info"
+heartbeat_interval = 10
+`)
+	writeConfig(t, lc.dir, `
+server_url = "https://b.example.com"
+log_level = "debug"
+heartbeat_interval = 30
+idle_timeout = 15
+`)
+	lc.reload("test")
+
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("log level = %s, want debug", logLevel.Level())
+	}
+	if got := lc.client.HeartbeatInterval().Seconds(); got != 30 {
+		t.Errorf("heartbeat interval = %vs, want 30s", got)
+	}
+	if lc.cfg.IdleTimeout != 15 {
+		t.Errorf("idle_timeout = %d, want 15", lc.cfg.IdleTimeout)
+	}
+	if lc.cfg.ServerURL != "https://a.example.com" {
+		t.Errorf("server_url changed to %s without a restart", lc.cfg.ServerURL)
+	}
+	if !strings.Contains(logs.String(), "restart the agent") || !strings.Contains(logs.String(), "server_url") {
+		t.Errorf("restart-only field not reported:\n%s", logs.String())
+	}
+}
+
+func TestWatch_ReloadsWhenEditorReplacesFile(t *testing.T) {
+	lc, _ := testLiveConfig(t, `
+server_url = "https://a.example.com"
+heartbeat_interval = 10
+`)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lc.watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Save the way editors do: write a new file and rename it over the old.
+	tmp := filepath.Join(lc.dir, "config.toml.swp")
+	if err := os.WriteFile(tmp, []byte("server_url = \"https://a.example.com\"\nheartbeat_interval = 30\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(lc.dir, "config.toml")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(configPollInterval - 500*time.Millisecond)
+	for lc.client.HeartbeatInterval() != 30*time.Second {
+		if time.Now().After(deadline) {
+			t.Fatal("replaced config.toml not reloaded")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReload_KeepsConfigUpdateReplayProtection(t *testing.T) {
+	keyA := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	keyB := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	lc, logs := testLiveConfig(t, `
+config_signing_key = "`+keyA+`"
+remote_config_version = 5
+`)
+	writeConfig(t, lc.dir, `config_signing_key = "`+keyB+`"`)
+	lc.reload("test")
+
+	if lc.cfg.ConfigSigningKey != keyA || lc.cfg.RemoteConfigVersion != 5 {
+		t.Errorf("signing key or version changed while running: %q, %d", lc.cfg.ConfigSigningKey, lc.cfg.RemoteConfigVersion)
+	}
+	if !strings.Contains(logs.String(), "restart the agent") || !strings.Contains(logs.String(), "config_signing_key") {
+		t.Errorf("signing key change not reported as restart-only:\n%s", logs.String())
+	}
+}
+
+func TestReload_KeepsSettingsOnInvalidFile(t *testing.T) {
+	lc, logs := testLiveConfig(t, `log_level = "warn"`)
+	writeConfig(t, lc.dir, `log_level = "loud"`)
+	lc.reload("test")
+	if lc.cfg.LogLevel != "warn" {
+		t.Fatalf("log_level = %q after an invalid reload, want warn", lc.cfg.LogLevel)
+	}
+	if !strings.Contains(logs.String(), "reload failed") {
+		t.Fatalf("invalid config not reported:\n%s", logs.String())
+	}
+
+	writeConfig(t, lc.dir, `log_level = "warn"
+allowed_commands = ["python"]`)
+	lc.reload("test")
+	if len(lc.cfg.AllowedCommands) != 0 {
+		t.Fatal("an allowed_commands entry outside the built-in set was adopted")
+	}
+}
+
+func TestReload_SwitchesToNewAPIKey(t *testing.T) {
+	lc, logs := testLiveConfig(t, `api_key = "sf_live_old"`)
+	writeConfig(t, lc.dir, `api_key = "sf_live_new"`)
+	lc.reload("test")
+
+	if key, err := lc.client.APIKey(); err != nil || key != "sf_live_new" {
+		t.Fatalf("client key = %q, %v; want the new key", key, err)
+	}
+	if strings.Contains(logs.String(), "restart the agent") {
+		t.Errorf("api_key change asked for a restart:\n%s", logs.String())
+	}
+}
+
+func TestRotateCredentials_SavesKeyToSelectedProfile(t *testing.T) {
+	lc, _ := testLiveConfig(t, `
+api_key = "sf_live_default"
+
+[profiles.staging]
+server_url = "https://staging.example.com"
+api_key = "sf_live_old"
+`)
+	lc.profile = "staging"
+	lc.cfg.APIKey = "sf_live_old"
+	if err := lc.RotateCredentials("sf_live_new", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := config.LoadFrom(lc.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Profiles["staging"].APIKey != "sf_live_new" || saved.APIKey != "sf_live_default" {
+		t.Errorf("saved keys: staging %q, default %q", saved.Profiles["staging"].APIKey, saved.APIKey)
+	}
+	if key, _ := lc.client.APIKey(); key != "sf_live_new" {
+		t.Errorf("client key = %q, want the new key", key)
+	}
+
+	t.Setenv("SESSIONFORGE_API_KEY", "sf_live_env")
+	if err := lc.RotateCredentials("sf_live_newer", 0); err == nil {
+		t.Error("rotation accepted for a key set in the environment")
+	}
+}
+
+func TestApplyRemoteConfig_ScopedToProfile(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(pub)
+	lc, _ := testLiveConfig(t, `
+config_signing_key = "`+key+`"
+remote_config_version = 5
+heartbeat_interval = 10
+
+[profiles.staging]
+server_url = "https://staging.example.com"
+config_signing_key = "`+key+`"
+`)
+	lc.profile, lc.primary = "staging", false
+	if lc.cfg, _, err = effectiveConfigFor(lc.dir, "staging"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 1 is new for staging even though the default profile is at 5.
+	payload := []byte(`{"heartbeatInterval":30,"allowedCommands":["bash"]}`)
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, config.RemoteConfigMessage(1, payload)))
+	if _, err := lc.ApplyRemoteConfig(1, payload, sig); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.ApplyRemoteConfig(1, payload, sig); err == nil {
+		t.Error("replayed update accepted")
+	}
+
+	saved, err := config.LoadFrom(lc.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.HeartbeatInterval != 10 || saved.RemoteConfigVersion != 5 || len(saved.AllowedCommands) != 0 {
+		t.Errorf("staging update changed the top-level config: %+v", saved)
+	}
+	if st := saved.Profiles["staging"]; st.RemoteConfigVersion != 1 || st.Remote == nil || *st.Remote.HeartbeatInterval != 30 {
+		t.Errorf("staging update not saved to its profile: %+v", st)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload delivers SIGHUP, the conventional "reload your config" signal.
+func notifyReload(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGHUP)
+}
+
+// signalReload sends SIGHUP to the daemon with the given pid.
+func signalReload(pid int) error {
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
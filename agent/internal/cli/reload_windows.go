@@ -0,0 +1,13 @@
+//go:build windows
+
+package cli
+
+import "os"
+
+// notifyReload is a no-op: Windows has no SIGHUP, so the service relies on
+// the file watcher alone.
+func notifyReload(ch chan<- os.Signal) {}
+
+// signalReload is a no-op on Windows; the service notices the config.toml
+// change through its file watcher.
+func signalReload(pid int) error { return nil }
@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"syscall"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/sessionforge/agent/internal/debuglog"
 	"github.com/sessionforge/agent/internal/session"
 	"github.com/sessionforge/agent/internal/system"
+	"github.com/sessionforge/agent/internal/transport"
 )
 
 var (
@@ -39,6 +41,7 @@ func Execute() error {
 
 var flagLogLevel string
 var flagConfigDir string
+var flagProfile string
 
 // SetConfigDir allows the Windows service init path to set the config directory
 // before Cobra parses flags (service mode bypasses Cobra entirely).
@@ -67,6 +70,8 @@ func init() {
 		"Override log level (debug, info, warn, error)")
 	rootCmd.PersistentFlags().StringVar(&flagConfigDir, "config-dir", "",
 		"Override config directory (default: ~/.sessionforge)")
+	rootCmd.PersistentFlags().StringVar(&flagProfile, "profile", "",
+		"Use the named credentials profile (default: active_profile)")
 
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(authCmd)
@@ -76,6 +81,7 @@ func init() {
 	rootCmd.AddCommand(updateCmd)
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(configCmd)
 }
 
 // versionCmd prints build-time information.
@@ -102,7 +108,7 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 // It accepts an external context so it can be driven by either OS signals
 // (interactive) or the Windows SCM stop handler (service mode).
 func runDaemonWithContext(ctx context.Context) error {
-	cfg, err := config.LoadFrom(flagConfigDir)
+	cfg, err := loadConfig()
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
 	}
@@ -110,21 +116,115 @@ func runDaemonWithContext(ctx context.Context) error {
 	if !cfg.IsConfigured() {
 		return fmt.Errorf("agent is not configured — run: sessionforge auth login --key <your-api-key>")
 	}
-
-	// CLI flag overrides config file log level.
-	logLevel := cfg.LogLevel
-	if flagLogLevel != "" {
-		logLevel = flagLogLevel
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
 	}
 
-	logger := buildLogger(logLevel, cfg.LogFile)
+	// loadConfig has already applied --log-level over the file and env.
+	logger := buildLogger(cfg.LogLevel, cfg.LogFile)
 	slog.SetDefault(logger) // route slog.Default() to the file logger
+	profiles := cfg.ServedProfiles()
 	logger.Info("SessionForge Agent starting",
 		"version", version,
+		"profiles", profiles,
+		"os", system.GetOS(),
+	)
+
+	// Run the ConPTY probe eagerly at startup so it completes before the first
+	// session request arrives. Without this the probe blocks the session goroutine
+	// for up to 3 seconds and causes session_started to be sent with pid=0.
+	go session.WarmUpSpawnTier()
+
+	// Each served profile gets its own connection and sessions; the active
+	// profile comes first and keeps the top-level state files. If a later
+	// profile fails to start, cancel stops the ones already running.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var agents []*agent
+	for i, name := range profiles {
+		pcfg, plogger := cfg, logger
+		if len(profiles) > 1 {
+			plogger = logger.With("profile", name)
+		}
+		if i > 0 {
+			if pcfg, _, err = effectiveConfigFor(flagConfigDir, name); err != nil {
+				err = fmt.Errorf("profile %s: %w", name, err)
+				break
+			}
+			if !pcfg.IsConfigured() {
+				plogger.Warn("profile is not configured; not serving it")
+				continue
+			}
+		}
+		var a *agent
+		if a, err = startAgent(ctx, pcfg, name, i == 0, plogger); err != nil {
+			break
+		}
+		agents = append(agents, a)
+	}
+	if err == nil {
+		// Let `sessionforge session signal` reach these sessions locally.
+		managers := make([]*session.Manager, 0, len(agents))
+		for _, a := range agents {
+			managers = append(managers, a.mgr)
+		}
+		if dir, derr := agentDir(); derr != nil {
+			logger.Warn("control socket disabled", "err", derr)
+		} else if derr := serveControl(ctx, dir, managers, logger); derr != nil {
+			logger.Warn("control socket disabled", "err", derr)
+		}
+
+		// Block until context is cancelled (OS signal or SCM stop).
+		<-ctx.Done()
+		logger.Info("shutdown signal received — stopping all sessions")
+	}
+
+	cancel()
+	for _, a := range agents {
+		a.stop()
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("SessionForge Agent stopped")
+	return nil
+}
+
+// agent is the connection, session manager and debug log serving one profile.
+type agent struct {
+	mgr    *session.Manager
+	client *connection.Client
+	dl     *debuglog.Client
+}
+
+// stop ends every session of the agent and waits for its connection to close.
+func (a *agent) stop() {
+	a.mgr.StopAll()
+	a.client.Wait()
+	if a.dl != nil {
+		a.dl.Stop()
+	}
+}
+
+// startAgent connects one profile and starts its background loops. The
+// primary agent keeps its outbox and state file in the config directory so
+// `sessionforge status` finds them; other profiles use profiles/<name>.
+func startAgent(ctx context.Context, cfg *config.Config, profile string, primary bool, logger *slog.Logger) (*agent, error) {
+	// Fetch the key once up front so a broken credential helper fails at
+	// startup rather than on the first reconnect.
+	if _, err := cfg.ResolveAPIKey(); err != nil {
+		return nil, fmt.Errorf("api key: %w", err)
+	}
+	if system.IsForeignMachineID(cfg.MachineID) {
+		logger.Warn("machine_id was created on another host; this config was copied or the disk cloned, "+
+			"so two machines may share one identity",
+			"machineId", cfg.MachineID, "hint", "run: sessionforge auth login --reset-id")
+	}
+	logger.Info("connecting profile",
 		"machineId", cfg.MachineID,
 		"machineName", cfg.MachineName,
 		"server", cfg.ServerURL,
-		"os", system.GetOS(),
+		"fallbacks", cfg.ServerURLs,
 	)
 
 	// We build handler, client, and manager with mutual references via a
@@ -140,10 +240,34 @@ func runDaemonWithContext(ctx context.Context) error {
 
 	client := connection.NewClient(cfg, version, dispatchWrapper, logger)
 	mgr := session.NewManager(ctx, client, logger)
+	a := &agent{mgr: mgr, client: client}
+
+	// Persist lifecycle events so they survive disconnects and restarts.
+	if dir, err := agentDir(); err != nil {
+		logger.Warn("outbox disabled", "err", err)
+	} else {
+		if !primary {
+			dir = filepath.Join(dir, "profiles", profile)
+		}
+		if err := client.EnableOutbox(dir); err != nil {
+			logger.Warn("outbox disabled", "err", err)
+		}
+		// Let `sessionforge status` report whether the daemon is connected.
+		if err := client.EnableStateFile(dir); err != nil {
+			logger.Warn("state file disabled", "err", err)
+		}
+	}
 
 	// Wire up the debug log client if the agent is fully configured.
-	if cfg.APIKey != "" && cfg.MachineID != "" {
-		dl := debuglog.New(cfg.MachineID, cfg.APIKey, cfg.ServerURL, version)
+	if cfg.IsConfigured() {
+		dl := debuglog.New(cfg.MachineID, client.APIKey, cfg.ServerURL, version)
+		if rt, err := transport.HTTPTransport(cfg); err != nil {
+			logger.Warn("debug log: using direct connection", "err", err)
+		} else {
+			dl.SetTransport(rt)
+		}
+		dl.SetTokenFunc(client.AuthToken)
+		dl.SetServerURLFunc(client.Endpoint)
 		dl.Start()
 		mgr.SetDebugLogger(dl)
 		client.SetDebugLogger(dl)
@@ -152,14 +276,9 @@ func runDaemonWithContext(ctx context.Context) error {
 			"machineId": cfg.MachineID,
 			"os":        runtime.GOOS,
 		})
-		defer dl.Stop()
+		a.dl = dl
 	}
 
-	// Run the ConPTY probe eagerly at startup so it completes before the first
-	// session request arrives. Without this the probe blocks the session goroutine
-	// for up to 3 seconds and causes session_started to be sent with pid=0.
-	go session.WarmUpSpawnTier()
-
 	// If install stored a resolved claude path in config, prime the session
 	// package so the service (running as LocalSystem) can find claude without
 	// needing the user's npm directories in the system PATH.
@@ -175,45 +294,69 @@ func runDaemonWithContext(ctx context.Context) error {
 		logger.Info("using claude config dir from config", "claudeConfigDir", cfg.ClaudeConfigDir)
 	}
 
+	// Restart policy, idle/runtime limits and session profiles for sessions
+	// started from the cloud.
+	// The command allow-list and heartbeat interval are applied through
+	// liveConfig, which also applies config_update messages later.
+	mgr.SetSessionProfiles(cfg.SessionProfiles)
+	live := &liveConfig{dir: flagConfigDir, profile: profileFlag(profile, primary), primary: primary, cfg: cfg, mgr: mgr, client: client, logger: logger}
+	if err := live.applyLive(cfg); err != nil {
+		if a.dl != nil {
+			a.dl.Stop()
+		}
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	handler := connection.NewHandler(mgr, client, logger)
+	handler.SetConfigApplier(live)
+	handler.SetCredentialRotator(live)
 
 	// Wire up dispatch to the fully-constructed handler.
 	dispatch = handler.Handle
 
 	// Replay session_started for any active sessions after every reconnect so
 	// the cloud DB stays in sync even when the WebSocket drops and reconnects.
-	client.OnConnect = func() { mgr.ReplayToCloud() }
+	// sync_state then reconciles exits missed while offline and sessions the
+	// cloud wants terminated.
+	client.OnConnect = func() {
+		mgr.ReplayToCloud()
+		mgr.SendSyncState()
+	}
+
+	// A rejected API key stops reconnection; optionally stop sessions too so
+	// nothing keeps running on a machine the cloud no longer trusts.
+	client.OnUnauthorized = func(err error) {
+		if live.stopOnUnauthorized() {
+			logger.Warn("stopping all sessions (on_unauthorized = stop)")
+			mgr.StopAll()
+		}
+	}
 
-	// Start heartbeat (sends metrics + discovered processes every 10s).
+	// Start heartbeat (sends metrics + discovered processes every
+	// heartbeat_interval, 10s by default).
 	go connection.RunHeartbeat(ctx, client, cfg.MachineID, mgr, logger)
 
+	// Pick up config.toml edits and SIGHUP without restarting sessions.
+	go live.watch(ctx)
+
 	// Start the WebSocket client (blocks with auto-reconnect until ctx cancelled).
 	go client.Run(ctx)
+	return a, nil
+}
 
-	// Block until context is cancelled (OS signal or SCM stop).
-	<-ctx.Done()
-
-	logger.Info("shutdown signal received — stopping all sessions")
-	mgr.StopAll()
-	client.Wait()
-	logger.Info("SessionForge Agent stopped")
-	return nil
+// profileFlag is the profile a liveConfig reloads: the --profile selection
+// for the primary agent, which may be empty to follow active_profile.
+func profileFlag(profile string, primary bool) string {
+	if primary {
+		return flagProfile
+	}
+	return profile
 }
 
 // buildLogger creates a structured slog logger at the requested level.
 // If logFile is non-empty, output is written to that file (appended) instead of stderr.
 func buildLogger(level, logFile string) *slog.Logger {
-	var l slog.Level
-	switch level {
-	case "debug":
-		l = slog.LevelDebug
-	case "warn":
-		l = slog.LevelWarn
-	case "error":
-		l = slog.LevelError
-	default:
-		l = slog.LevelInfo
-	}
+	logLevel.Set(parseLogLevel(level))
 
 	var w io.Writer = os.Stderr
 	if logFile != "" {
@@ -224,5 +367,5 @@ func buildLogger(level, logFile string) *slog.Logger {
 		}
 	}
 
-	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: l}))
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: &logLevel}))
 }
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/connection"
+	"github.com/spf13/cobra"
+)
+
+var rotateFlagGrace time.Duration
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the API key without downtime",
+	Long: `Rotate asks the server for a new API key, saves it to config.toml and
+tells the running agent to reconnect with it. The server keeps accepting the
+old key for the grace period, so nothing disconnects for good while the new
+key propagates.
+
+Keys supplied by api_key_command, api_key_fd, a systemd credential or
+SESSIONFORGE_API_KEY cannot be written by the agent; rotate them where they
+are stored.
+
+Example:
+  sessionforge auth rotate
+  sessionforge --profile staging auth rotate --grace 1h`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, sources, err := effectiveConfig(flagConfigDir)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		if err := checkRotatable(cfg, sources); err != nil {
+			return err
+		}
+		profile, err := selectedProfile()
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		rk, err := connection.RotateAPIKey(ctx, cfg, version, rotateFlagGrace)
+		if connection.IsUnauthorized(err) {
+			return fmt.Errorf("%s rejected the stored credential — run: sessionforge auth login", cfg.ServerURL)
+		}
+		if err != nil {
+			return err
+		}
+		if err := saveRotatedKey(flagConfigDir, profile, rk.APIKey); err != nil {
+			// The server has issued the key; losing it would lock the
+			// machine out once the old one expires.
+			fmt.Fprintf(os.Stderr, "The new key could not be saved. Store it by hand before the old key expires:\n  %s\n", rk.APIKey)
+			return fmt.Errorf("save config: %w", err)
+		}
+
+		fmt.Printf("API key rotated for profile %q.\n", profile)
+		if !rk.PreviousKeyExpiresAt.IsZero() {
+			fmt.Printf("  The old key stays valid until %s.\n", rk.PreviousKeyExpiresAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		notifyDaemon()
+		return nil
+	},
+}
+
+// checkRotatable returns an error unless cfg's API key is stored in
+// config.toml, the only place a rotated key can be written to.
+func checkRotatable(cfg *config.Config, sources config.Sources) error {
+	switch src := cfg.APIKeySource(); {
+	case src == "":
+		return fmt.Errorf("not logged in — run: sessionforge auth login --device")
+	case src != "config":
+		return fmt.Errorf("the API key comes from %s; rotate it where it is stored", src)
+	case sources["api_key"] == config.SourceEnv:
+		return fmt.Errorf("the API key is set by %s; rotate it where it is stored", config.EnvName("api_key"))
+	}
+	return nil
+}
+
+// saveRotatedKey writes key for profile into config.toml under dir. It edits
+// the file as it is on disk, so environment overrides are not persisted.
+func saveRotatedKey(dir, profile, key string) error {
+	cfg, err := config.LoadFrom(dir)
+	if err != nil {
+		return err
+	}
+	if err := cfg.SetAPIKey(profile, key); err != nil {
+		return err
+	}
+	return config.SaveFrom(dir, cfg)
+}
+
+// notifyDaemon asks a running agent to reload config.toml now, in case its
+// directory watch is unavailable and it only polls the file.
+func notifyDaemon() {
+	dir, err := agentDir()
+	if err != nil {
+		return
+	}
+	st, err := connection.ReadState(dir)
+	if err != nil || st == nil || !st.Running() {
+		fmt.Println("  No agent is running; it will use the new key when started.")
+		return
+	}
+	if err := signalReload(st.PID); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not signal the agent (pid %d): %v\n", st.PID, err)
+		fmt.Println("  The agent picks up the new key from config.toml within a few seconds.")
+		return
+	}
+	fmt.Printf("  The running agent (pid %d) is reconnecting with the new key.\n", st.PID)
+}
+
+func init() {
+	rotateCmd.Flags().DurationVar(&rotateFlagGrace, "grace", connection.DefaultRotationGrace, "How long the server keeps accepting the old key")
+	authCmd.AddCommand(rotateCmd)
+}
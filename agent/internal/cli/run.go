@@ -9,10 +9,11 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/sessionforge/agent/internal/config"
 	"github.com/sessionforge/agent/internal/connection"
+	"github.com/sessionforge/agent/internal/session"
 )
 
 var (
@@ -31,7 +32,10 @@ Examples:
   sessionforge run claude --name "email-agent"
   sessionforge run bash --workdir ~/project
 
-Press Ctrl+] (ASCII 29) to detach. The session keeps running in the cloud.
+Press Ctrl+] or type ~. at the start of a line to detach. The session keeps
+running in the cloud. Type ~? at the start of a line for the other escape
+sequences (status, force-stop, literal ~). Both keys are configurable with
+--escape-char / --detach-key or escape_char / detach_key in config.toml.
 Reattach later: sessionforge session attach <session-id>`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: runRun,
@@ -40,10 +44,11 @@ Reattach later: sessionforge session attach <session-id>`,
 func init() {
 	runCmd.Flags().StringVar(&runName, "name", "", "Human-readable name shown in the dashboard")
 	runCmd.Flags().StringVarP(&runWorkdir, "workdir", "w", ".", "Working directory for the session")
+	addEscapeFlags(runCmd)
 }
 
 func runRun(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
@@ -51,6 +56,11 @@ func runRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("not configured — run: sessionforge auth login --key <key>")
 	}
 
+	escapes, err := escapeParserFor(cfg)
+	if err != nil {
+		return err
+	}
+
 	command := strings.Join(args, " ")
 	workdir := runWorkdir
 
@@ -92,26 +102,31 @@ func runRun(cmd *cobra.Command, args []string) error {
 	} else {
 		fmt.Fprintf(os.Stderr, "Session started: %s\n", sessionID)
 	}
-	fmt.Fprintln(os.Stderr, "Press Ctrl+] to detach.")
+	fmt.Fprintf(os.Stderr, "Press %s.\n", escapes.describe())
 
 	// Stdin passthrough loop: read local stdin, forward to PTY.
-	// Ctrl+] (byte 29) breaks the loop and detaches.
+	// The detach key or ~. breaks the loop and detaches.
 	detached := make(chan struct{})
 	go func() {
 		defer close(detached)
-		buf := make([]byte, 256)
-		for {
-			n, err := os.Stdin.Read(buf)
-			if err != nil {
-				return
-			}
-			if n > 0 && buf[0] == 29 { // Ctrl+]
-				return
-			}
-			if n > 0 {
-				_ = mgr.WriteInputRaw(sessionID, buf[:n])
-			}
-		}
+		_ = pumpStdin(os.Stdin, escapes,
+			func(data []byte) error { return mgr.WriteInputRaw(sessionID, data) },
+			func(action escapeAction) bool {
+				switch action {
+				case escapeDetach:
+					return true
+				case escapeStatus:
+					fmt.Fprint(os.Stderr, runStatusLine(mgr, sessionID, runName, command))
+				case escapeForceStop:
+					fmt.Fprint(os.Stderr, "\r\n[sessionforge] force-stopping session\r\n")
+					if err := mgr.Stop(sessionID, true); err != nil {
+						fmt.Fprintf(os.Stderr, "[sessionforge] stop failed: %v\r\n", err)
+					}
+				case escapeHelp:
+					fmt.Fprint(os.Stderr, "\r\n"+escapes.help())
+				}
+				return false
+			})
 	}()
 
 	// Block until process exits, detach, or OS signal.
@@ -136,6 +151,20 @@ func runRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// runStatusLine formats the ~s status output for `sessionforge run`.
+func runStatusLine(mgr *session.Manager, sessionID, name, command string) string {
+	s, err := mgr.Get(sessionID)
+	if err != nil {
+		return fmt.Sprintf("\r\n[sessionforge] session %s: not running\r\n", sessionID)
+	}
+	label := sessionID
+	if name != "" {
+		label = fmt.Sprintf("%s (%s)", sessionID, name)
+	}
+	return fmt.Sprintf("\r\n[sessionforge] session %s  pid %d  command %q  up %s\r\n",
+		label, s.PID(), command, time.Since(s.StartedAt).Round(time.Second))
+}
+
 // buildRunLogger builds a logger for 'sessionforge run' mode.
 // PTY output owns stdout; logs must not interleave with it.
 // If a log file is configured, write logs there only (not stderr).
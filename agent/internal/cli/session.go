@@ -36,6 +36,10 @@ var sessionListCmd = &cobra.Command{
 var (
 	sessionStartCommand string
 	sessionStartWorkdir string
+	sessionStartProfile     string
+	sessionStartRestart     string
+	sessionStartIdleTimeout int
+	sessionStartMaxRuntime  int
 )
 
 var sessionStartCmd = &cobra.Command{
@@ -45,7 +49,9 @@ var sessionStartCmd = &cobra.Command{
 
 Examples:
   sessionforge session start
-  sessionforge session start --command bash --workdir /home/user/project`,
+  sessionforge session start --command bash --workdir /home/user/project
+  sessionforge session start --session-profile queue-worker
+  sessionforge session start --restart on-failure`,
 	RunE: runSessionStart,
 }
 
@@ -56,13 +62,35 @@ var sessionStopCmd = &cobra.Command{
 	RunE:  runSessionStop,
 }
 
+var sessionSignalGroup bool
+
+var sessionSignalCmd = &cobra.Command{
+	Use:   "signal SESSION_ID SIGNAL",
+	Short: "Send a signal to a running session",
+	Long: `Signal delivers a Unix signal to a session's foreground process group
+(the job currently reading the terminal), or with --group to the session's
+own process group. The request goes to the agent running on this machine.
+
+Signals are checked against an allow-list: SIGINT, SIGHUP, SIGTERM, SIGQUIT,
+SIGKILL, SIGUSR1, SIGUSR2, SIGWINCH and SIGTSTP. Use pause/resume from the
+dashboard instead of SIGSTOP/SIGCONT.
+
+Examples:
+  sessionforge session signal 3f2a INT        # abort the current Claude turn
+  sessionforge session signal 3f2a SIGHUP --group`,
+	Args: cobra.ExactArgs(2),
+	RunE: runSessionSignal,
+}
+
 var sessionAttachCmd = &cobra.Command{
 	Use:   "attach SESSION_ID",
 	Short: "Attach an interactive terminal to a running session",
 	Long: `Attach your terminal to an existing session. Keystrokes are forwarded
 to the remote session; output streams back to your terminal.
 
-Press Ctrl+] (ASCII 29) to detach without terminating the session.`,
+Press Ctrl+] or type ~. at the start of a line to detach without
+terminating the session. Type ~? at the start of a line for the other escape
+sequences (status, force-stop, literal ~).`,
 	Args: cobra.ExactArgs(1),
 	RunE: runSessionAttach,
 }
@@ -72,10 +100,24 @@ func init() {
 		"Command to run (claude, bash, zsh, sh, powershell, cmd)")
 	sessionStartCmd.Flags().StringVarP(&sessionStartWorkdir, "workdir", "w", ".",
 		"Working directory for the session")
+	sessionStartCmd.Flags().StringVar(&sessionStartProfile, "session-profile", "",
+		"Session profile from [session_profiles.<name>] in config.toml")
+	sessionStartCmd.Flags().StringVar(&sessionStartRestart, "restart", "",
+		"Restart policy: never, on-failure or always (default from config)")
+	sessionStartCmd.Flags().IntVar(&sessionStartIdleTimeout, "idle-timeout", 0,
+		"Minutes without input or output before the session is stopped or paused (-1 disables)")
+	sessionStartCmd.Flags().IntVar(&sessionStartMaxRuntime, "max-runtime", 0,
+		"Maximum session lifetime in minutes (-1 disables)")
+
+	sessionSignalCmd.Flags().BoolVar(&sessionSignalGroup, "group", false,
+		"Signal the session's whole process group instead of the foreground job")
+
+	addEscapeFlags(sessionAttachCmd)
 
 	sessionCmd.AddCommand(sessionListCmd)
 	sessionCmd.AddCommand(sessionStartCmd)
 	sessionCmd.AddCommand(sessionStopCmd)
+	sessionCmd.AddCommand(sessionSignalCmd)
 	sessionCmd.AddCommand(sessionAttachCmd)
 }
 
@@ -95,20 +137,27 @@ func buildAgentComponents(ctx context.Context, cfg *config.Config) (*connection.
 	if cfg.ClaudeConfigDir != "" {
 		mgr.SetClaudeConfigDir(cfg.ClaudeConfigDir)
 	}
+	mgr.SetDefaultRestartPolicy(cfg.Restart)
+	mgr.SetDefaultLimits(cfg.SessionLimits)
+	mgr.SetSessionProfiles(cfg.SessionProfiles)
+	mgr.SetExitTailLines(cfg.ExitTailLines)
+	if err := mgr.SetAllowedCommands(cfg.AllowedCommands); err != nil {
+		logger.Warn("ignoring allowed_commands", "err", err)
+	}
 	handler := connection.NewHandler(mgr, client, logger)
 	dispatch = handler.Handle
 
-	// On every (re)connect, replay session_started for all active sessions
-	// so the server stays in sync if the connection was interrupted.
-	client.OnConnect = func() {
-		mgr.ReplayToCloud()
-	}
+	// On every (re)connect, replay session_started for the sessions this
+	// process owns. Unlike the daemon, a CLI process never sends sync_state:
+	// it only knows its own sessions, so an authoritative list from it would
+	// make the cloud mark the daemon's sessions stopped.
+	client.OnConnect = mgr.ReplayToCloud
 
 	return client, mgr
 }
 
 func runSessionList(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
@@ -133,7 +182,7 @@ func runSessionList(cmd *cobra.Command, args []string) error {
 	for _, s := range sessions {
 		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
 			s.ID,
-			s.PID,
+			s.PID(),
 			s.ProcessName,
 			s.Workdir,
 			s.StartedAt.Format("2006-01-02 15:04:05"),
@@ -143,7 +192,7 @@ func runSessionList(cmd *cobra.Command, args []string) error {
 }
 
 func runSessionStart(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
@@ -151,6 +200,28 @@ func runSessionStart(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("not configured — run: sessionforge auth login --key <key>")
 	}
 
+	opts := config.SessionOptions{
+		Profile: sessionStartProfile,
+		Limits: config.SessionLimits{
+			IdleTimeout: sessionStartIdleTimeout,
+			MaxRuntime:  sessionStartMaxRuntime,
+		},
+	}
+	if sessionStartRestart != "" {
+		switch sessionStartRestart {
+		case config.RestartNever, config.RestartOnFailure, config.RestartAlways:
+		default:
+			return fmt.Errorf("invalid --restart %q: use never, on-failure or always", sessionStartRestart)
+		}
+		restart := cfg.Restart
+		restart.Mode = sessionStartRestart
+		opts.Restart = &restart
+	}
+	command := sessionStartCommand
+	if sessionStartProfile != "" && !cmd.Flags().Changed("command") {
+		command = ""
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
@@ -162,13 +233,15 @@ func runSessionStart(cmd *cobra.Command, args []string) error {
 	// Give the WebSocket connection time to register.
 	time.Sleep(600 * time.Millisecond)
 
-	sessionID, err := mgr.Start("cli-start", "", sessionStartCommand, sessionStartWorkdir, nil)
+	sessionID, err := mgr.Start("cli-start", "", command, sessionStartWorkdir, nil, opts)
 	if err != nil {
 		return fmt.Errorf("start session: %w", err)
 	}
 
 	fmt.Printf("Session started: %s\n", sessionID)
-	fmt.Printf("Command: %s  |  Workdir: %s\n", sessionStartCommand, sessionStartWorkdir)
+	if s, err := mgr.Get(sessionID); err == nil {
+		fmt.Printf("Command: %s  |  Workdir: %s\n", s.Command, s.Workdir)
+	}
 	fmt.Println("Press Ctrl+C to stop.")
 
 	<-ctx.Done()
@@ -177,7 +250,7 @@ func runSessionStart(cmd *cobra.Command, args []string) error {
 }
 
 func runSessionStop(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
@@ -201,11 +274,41 @@ func runSessionStop(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// runSessionSignal asks the running daemon, through its control socket, to
+// signal one of its sessions. It does not connect to the cloud.
+func runSessionSignal(cmd *cobra.Command, args []string) error {
+	sessionID := args[0]
+	sig, err := session.NormalizeSignal(args[1])
+	if err != nil {
+		return err
+	}
+	target := session.SignalForeground
+	if sessionSignalGroup {
+		target = session.SignalGroup
+	}
+
+	dir, err := agentDir()
+	if err != nil {
+		return err
+	}
+	if err := sendControl(dir, controlRequest{
+		Type:      "signal_session",
+		SessionID: sessionID,
+		Signal:    sig,
+		Target:    target,
+	}); err != nil {
+		return fmt.Errorf("signal session %s: %w", sessionID, err)
+	}
+
+	fmt.Printf("Sent %s to session %s (%s).\n", sig, sessionID, target)
+	return nil
+}
+
 // runSessionAttach bridges the local terminal to an existing session via the cloud WebSocket.
 // Input typed locally is base64-encoded and sent as session_input messages.
 // Output from the session arrives as session_output messages and is written to stdout.
 func runSessionAttach(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
@@ -213,8 +316,13 @@ func runSessionAttach(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("not configured — run: sessionforge auth login --key <key>")
 	}
 
+	escapes, err := escapeParserFor(cfg)
+	if err != nil {
+		return err
+	}
+
 	sessionID := args[0]
-	fmt.Printf("Attaching to session %s (Ctrl+] to detach)...\n\n", sessionID)
+	fmt.Printf("Attaching to session %s (%s)...\n\n", sessionID, escapes.describe())
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
@@ -244,31 +352,44 @@ func runSessionAttach(cmd *cobra.Command, args []string) error {
 	go client.Run(ctx)
 	time.Sleep(400 * time.Millisecond)
 
-	// Read stdin and forward; Ctrl+] (byte 29) = detach.
-	buf := make([]byte, 256)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-		}
-
-		n, err := os.Stdin.Read(buf)
-		if err != nil {
-			return nil
-		}
-		if n > 0 && buf[0] == 29 { // Ctrl+]
-			fmt.Println("\nDetached.")
-			return nil
-		}
-
-		encoded := base64.StdEncoding.EncodeToString(buf[:n])
-		_ = client.SendJSON(map[string]string{
+	// Read stdin and forward; the detach key or ~. = detach.
+	send := func(data []byte) error {
+		return client.SendJSON(map[string]string{
 			"type":      "session_input",
 			"sessionId": sessionID,
-			"data":      encoded,
+			"data":      base64.StdEncoding.EncodeToString(data),
 		})
 	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = pumpStdin(os.Stdin, escapes, send, func(action escapeAction) bool {
+			switch action {
+			case escapeDetach:
+				fmt.Println("\r\nDetached.")
+				return true
+			case escapeStatus:
+				state, _ := client.State()
+				fmt.Printf("\r\n[sessionforge] attached to session %s  cloud: %s\r\n", sessionID, state)
+			case escapeForceStop:
+				fmt.Print("\r\n[sessionforge] force-stopping session\r\n")
+				_ = client.SendJSON(map[string]any{
+					"type":      "stop_session",
+					"sessionId": sessionID,
+					"force":     true,
+				})
+			case escapeHelp:
+				fmt.Print("\r\n" + escapes.help())
+			}
+			return false
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return nil
 }
 
 // handleAttachOutput decodes a session_output message and writes it to stdout.
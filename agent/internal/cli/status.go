@@ -8,7 +8,9 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/connection"
 	"github.com/sessionforge/agent/internal/system"
+	"github.com/sessionforge/agent/internal/transport"
 )
 
 var statusCmd = &cobra.Command{
@@ -19,7 +21,7 @@ var statusCmd = &cobra.Command{
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
 	}
@@ -30,6 +32,10 @@ func runStatus(cmd *cobra.Command, args []string) error {
 
 	// Machine info.
 	fmt.Printf("%-18s %s\n", "Machine ID:", orNA(cfg.MachineID))
+	if system.IsForeignMachineID(cfg.MachineID) {
+		fmt.Printf("%-18s %s\n", "", "WARNING: created on another host (cloned or copied config);")
+		fmt.Printf("%-18s %s\n", "", "run: sessionforge auth login --reset-id")
+	}
 	fmt.Printf("%-18s %s\n", "Machine Name:", orNA(cfg.MachineName))
 	fmt.Printf("%-18s %s\n", "Hostname:", system.GetHostname())
 	fmt.Printf("%-18s %s\n", "OS:", system.GetOS())
@@ -38,8 +44,22 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	fmt.Println(divider)
 
 	// Config info.
+	profile := cfg.ActiveProfile
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	fmt.Printf("%-18s %s\n", "Profile:", profile)
 	fmt.Printf("%-18s %s\n", "Server URL:", orNA(cfg.ServerURL))
-	fmt.Printf("%-18s %s\n", "API Key:", maskKey(cfg.APIKey))
+	for _, u := range cfg.ServerURLs {
+		fmt.Printf("%-18s %s\n", "Fallback URL:", u)
+	}
+	switch src := cfg.APIKeySource(); src {
+	case "", "config":
+		fmt.Printf("%-18s %s\n", "API Key:", maskKey(cfg.APIKey))
+	default:
+		// Don't run a credential helper just to print a masked key.
+		fmt.Printf("%-18s (from %s)\n", "API Key:", src)
+	}
 	fmt.Printf("%-18s %s\n", "Log Level:", orNA(cfg.LogLevel))
 	fmt.Println(divider)
 
@@ -51,30 +71,76 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	fmt.Print("Connection:        checking...")
-	status, latency := pingServer(cfg)
-	fmt.Printf("\r%-18s %s", "Connection:", status)
-	if latency > 0 {
-		fmt.Printf(" (%.0fms)", float64(latency)/float64(time.Millisecond))
+	// With fallbacks configured, check every endpoint so an outage in one
+	// region is visible before the agent needs to fail over.
+	endpoints := cfg.Endpoints()
+	for i, endpoint := range endpoints {
+		label := ""
+		if i == 0 {
+			label = "Connection:"
+		}
+		prefix := ""
+		if len(endpoints) > 1 {
+			prefix = endpoint + "  "
+		}
+		fmt.Printf("%-18s %schecking...", label, prefix)
+		status, latency := pingServer(cfg, endpoint)
+		fmt.Printf("\r%-18s %s%s", label, prefix, status)
+		if latency > 0 {
+			fmt.Printf(" (%.0fms)", float64(latency)/float64(time.Millisecond))
+		}
+		fmt.Println()
 	}
-	fmt.Println()
+	printAgentState()
 	fmt.Println(divider)
 	fmt.Printf("Agent Version:     v%s\n", version)
 
 	return nil
 }
 
-// pingServer does a quick HTTP health check against the server.
-func pingServer(cfg *config.Config) (string, time.Duration) {
-	healthURL := cfg.ServerURL + "/api/health"
-	client := &http.Client{Timeout: 5 * time.Second}
+// printAgentState reports the running daemon's connection state, which the
+// health ping cannot see: a reachable server may still be rejecting the key.
+func printAgentState() {
+	dir, err := agentDir()
+	if err != nil {
+		return
+	}
+	st, err := connection.ReadState(dir)
+	if err != nil || st == nil {
+		return
+	}
+	if !st.Running() {
+		fmt.Printf("%-18s %s\n", "Agent:", "not running")
+		return
+	}
+	line := fmt.Sprintf("%s since %s", strings.ToUpper(st.State), st.Since.Local().Format("2006-01-02 15:04:05"))
+	if st.Reason != "" && st.State != connection.StateConnected {
+		line += " (" + st.Reason + ")"
+	}
+	fmt.Printf("%-18s %s\n", "Agent:", line)
+	if st.Endpoint != "" {
+		fmt.Printf("%-18s %s\n", "Active Endpoint:", st.Endpoint)
+	}
+	if st.State == connection.StateUnauthorized {
+		fmt.Println()
+		fmt.Println("The server rejected the API key. Run: sessionforge auth login --key <your-api-key>")
+	}
+}
+
+// pingServer does a quick HTTP health check against a server endpoint.
+func pingServer(cfg *config.Config, serverURL string) (string, time.Duration) {
+	healthURL := serverURL + "/api/health"
+	client, err := transport.HTTPClient(cfg, 5*time.Second)
+	if err != nil {
+		return "ERROR (" + err.Error() + ")", 0
+	}
 
 	start := time.Now()
 	resp, err := client.Get(healthURL)
 	elapsed := time.Since(start)
 
 	if err != nil {
-		return "UNREACHABLE (" + err.Error() + ")", 0
+		return "UNREACHABLE (" + transport.DiagnoseTLS(err).Error() + ")", 0
 	}
 	defer resp.Body.Close()
 
@@ -2,59 +2,134 @@ package cli
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/sessionforge/agent/internal/semver"
+	"github.com/sessionforge/agent/internal/transport"
 	"github.com/sessionforge/agent/internal/updater"
 )
 
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Update the SessionForge agent to the latest version",
-	Long: `Checks GitHub Releases for a newer version of the agent.
-If one is found, downloads and installs it in-place.
+	Long: `Checks GitHub Releases for a newer version of the agent on the release
+channel set by update_channel (stable, beta or nightly; --channel overrides
+it). If one is found, downloads and installs it in-place.
 
-The old binary is renamed to sessionforge.old as a rollback option.`,
+--version installs a specific release from any channel. Installing an older
+version than the running one requires --allow-downgrade.
+
+The release's checksums.txt must carry a valid minisign signature from the
+key built into the agent, and the archive must match its checksum; otherwise
+nothing is installed.
+
+The old binary is renamed to sessionforge.old as a rollback option.
+
+Example:
+  sessionforge update --check
+  sessionforge update --channel beta
+  sessionforge update --version v1.4.2 --allow-downgrade`,
 	RunE: runUpdate,
 }
 
-var updateFlagCheck bool
+var (
+	updateFlagCheck          bool
+	updateFlagChannel        string
+	updateFlagVersion        string
+	updateFlagAllowDowngrade bool
+)
 
 func init() {
 	updateCmd.Flags().BoolVar(&updateFlagCheck, "check", false, "Only check for updates, do not install")
+	updateCmd.Flags().StringVar(&updateFlagChannel, "channel", "", "Release channel: stable, beta or nightly (default: update_channel)")
+	updateCmd.Flags().StringVar(&updateFlagVersion, "version", "", "Install this version instead of the latest, e.g. v1.4.2")
+	updateCmd.Flags().BoolVar(&updateFlagAllowDowngrade, "allow-downgrade", false, "Allow installing a version older than the running one")
 }
 
 func runUpdate(cmd *cobra.Command, args []string) error {
-	fmt.Printf("Current version: v%s\n", version)
-	fmt.Println("Checking for updates...")
-
-	release, err := updater.CheckLatest()
+	cfg, err := loadConfig()
 	if err != nil {
-		return fmt.Errorf("check for updates: %w", err)
+		return err
+	}
+	channelName := cfg.UpdateChannel
+	if updateFlagChannel != "" {
+		channelName = updateFlagChannel
 	}
+	channel, err := updater.ParseChannel(channelName)
+	if err != nil {
+		return err
+	}
+	rt, err := transport.PublicHTTPTransport(cfg)
+	if err != nil {
+		return err
+	}
+	updater.SetTransport(rt)
 
-	latestTag := strings.TrimPrefix(release.TagName, "v")
-	currentVersion := strings.TrimPrefix(version, "v")
+	current, currentErr := semver.Parse(version)
+	fmt.Printf("Current version: %s\n", displayVersion(version))
 
-	fmt.Printf("Latest version:  %s\n", release.TagName)
+	var release *updater.Release
+	if updateFlagVersion != "" {
+		fmt.Printf("Looking up %s...\n", updateFlagVersion)
+		release, err = updater.FindRelease(updateFlagVersion)
+	} else {
+		fmt.Printf("Checking for updates on the %s channel...\n", channel)
+		release, err = updater.CheckLatest(channel)
+	}
+	if err != nil {
+		return fmt.Errorf("check for updates: %w", transport.DiagnoseTLS(err))
+	}
+	target, err := release.Version()
+	if err != nil {
+		return fmt.Errorf("release %s: %w", release.TagName, err)
+	}
+	if updateFlagVersion == "" {
+		fmt.Printf("Latest version:  %s\n", target)
+	}
 
-	if !updater.IsNewer(currentVersion, latestTag) {
-		fmt.Println("You are already running the latest version.")
+	// A development build has no version to compare against; only an
+	// explicit --version replaces it.
+	if currentErr != nil && updateFlagVersion == "" {
+		fmt.Printf("Not updating a development build; use --version %s to replace it.\n", target)
 		return nil
 	}
-
-	fmt.Printf("New version available: %s -> %s\n", version, release.TagName)
+	if currentErr == nil {
+		switch c := target.Compare(current); {
+		case c == 0:
+			fmt.Printf("You are already running %s.\n", target)
+			return nil
+		case c < 0 && updateFlagVersion == "":
+			fmt.Printf("You are running a newer version than the latest %s release.\n", channel)
+			fmt.Printf("To switch to it, run: sessionforge update --version %s --allow-downgrade\n", target)
+			return nil
+		case c < 0 && !updateFlagAllowDowngrade:
+			return fmt.Errorf("%s is older than the running %s; pass --allow-downgrade to install it anyway", target, current)
+		case c < 0:
+			fmt.Printf("Downgrading: %s -> %s\n", current, target)
+		default:
+			fmt.Printf("New version available: %s -> %s\n", current, target)
+		}
+	}
 
 	if updateFlagCheck {
-		fmt.Println("Run 'sessionforge update' to install.")
+		fmt.Println("Run again without --check to install.")
 		return nil
 	}
 
 	fmt.Printf("Downloading and installing %s...\n", release.TagName)
 	if err := updater.DownloadAndInstall(release); err != nil {
-		return fmt.Errorf("install update: %w", err)
+		return fmt.Errorf("install update: %w", transport.DiagnoseTLS(err))
 	}
 
 	fmt.Printf("Successfully updated to %s. Restart the agent to apply.\n", release.TagName)
 	return nil
 }
+
+// displayVersion prints a version the way release tags are written,
+// leaving development builds as they are.
+func displayVersion(v string) string {
+	if pv, err := semver.Parse(v); err == nil {
+		return pv.String()
+	}
+	return v
+}
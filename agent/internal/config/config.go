@@ -3,9 +3,11 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -13,16 +15,40 @@ import (
 const (
 	DefaultServerURL = "https://sessionforge.dev"
 	DefaultLogLevel  = "info"
+	// DefaultEscapeChar is the ssh-style escape character recognised at the
+	// start of a line by `run` and `session attach`.
+	DefaultEscapeChar = "~"
+	// DefaultDetachKey is the single-keystroke detach shortcut.
+	DefaultDetachKey = "ctrl-]"
 	configDir        = ".sessionforge"
 	configFile       = "config.toml"
 )
 
 // Config holds all agent configuration values.
 type Config struct {
+	// ConfigVersion is the schema version of the file. Older files are
+	// migrated on load; see CurrentConfigVersion.
+	ConfigVersion int `toml:"config_version"`
 	// ServerURL is the base URL of the SessionForge cloud server.
 	ServerURL string `toml:"server_url"`
+	// ServerURLs are fallback endpoints (e.g. a second region), tried in
+	// order when server_url keeps failing. The agent returns to an earlier
+	// endpoint once it is healthy again.
+	ServerURLs []string `toml:"server_urls,omitempty"`
 	// APIKey is the agent API key used to authenticate with the server.
 	APIKey string `toml:"api_key"`
+	// APIKeyCommand is a credential helper run through the shell, e.g.
+	// "pass show sessionforge"; the first line it prints is the API key.
+	APIKeyCommand string `toml:"api_key_command,omitempty"`
+	// APIKeyFD reads the API key from an inherited file descriptor (3 or
+	// higher), typically set with SESSIONFORGE_API_KEY_FD by a supervisor.
+	APIKeyFD int `toml:"api_key_fd,omitempty"`
+	// APIKeyCredential names the systemd credential in
+	// $CREDENTIALS_DIRECTORY holding the API key. When the agent runs with
+	// a credentials directory, "sessionforge-api-key" is used if present.
+	// The fd, the credential and the helper take precedence over api_key,
+	// in that order; their keys are cached in memory only.
+	APIKeyCredential string `toml:"api_key_credential,omitempty"`
 	// MachineID is a persistent UUID identifying this machine.
 	MachineID string `toml:"machine_id"`
 	// MachineName is a human-readable label for this machine.
@@ -47,13 +73,170 @@ type Config struct {
 	// ClaudeInstalledVia records how Claude Code was installed (informational).
 	// Values: "gitbash", "" (not set). Not used for tier selection.
 	ClaudeInstalledVia string `toml:"claude_installed_via,omitempty"`
+	// ProxyURL routes all cloud traffic (WebSocket, health ping, updater,
+	// debug log) through a proxy: http://host:port for HTTP CONNECT or
+	// socks5://host:port for SOCKS5. Credentials go in the URL userinfo
+	// (URL-encoded). Empty falls back to HTTPS_PROXY / HTTP_PROXY; "direct"
+	// ignores the environment and connects directly.
+	ProxyURL string `toml:"proxy_url,omitempty"`
+	// NoProxy is a comma-separated list of hosts, domains (".corp.example")
+	// and CIDRs that bypass the proxy. Empty falls back to NO_PROXY.
+	NoProxy string `toml:"no_proxy,omitempty"`
+	// TLSCAFile is a PEM bundle of extra CAs to trust, e.g. the internal CA
+	// of a self-hosted server or a TLS-inspecting proxy. The system roots
+	// remain trusted.
+	TLSCAFile string `toml:"tls_ca_file,omitempty"`
+	// TLSClientCert and TLSClientKey are PEM files presented to the server
+	// for mutual TLS. Both must be set together.
+	TLSClientCert string `toml:"tls_client_cert,omitempty"`
+	TLSClientKey  string `toml:"tls_client_key,omitempty"`
+	// TLSServerName overrides the name used to verify the server
+	// certificate, for servers reached by IP or through a tunnel.
+	TLSServerName string `toml:"tls_server_name,omitempty"`
+	// OnUnauthorized is what the agent does with its local sessions when the
+	// server rejects the API key: "keep" (default) leaves them running,
+	// "stop" stops them. Either way the agent stops reconnecting.
+	OnUnauthorized string `toml:"on_unauthorized,omitempty"`
+	// EscapeChar is the ssh-style escape character used by `run` and
+	// `session attach` (e.g. "~" makes "~." after a newline detach).
+	// Set to "none" to disable escape sequences.
+	EscapeChar string `toml:"escape_char,omitempty"`
+	// DetachKey is a control key that detaches immediately wherever it appears
+	// in the input stream, e.g. "ctrl-]" or "^]". Set to "none" to disable.
+	DetachKey string `toml:"detach_key,omitempty"`
+	// ExitTailLines is how many trailing output lines are attached to
+	// session_stopped / session_crashed. 0 uses the default (20); negative
+	// disables the tail.
+	ExitTailLines int `toml:"exit_tail_lines,omitempty"`
+	// HeartbeatInterval is how often, in seconds, metrics and session
+	// details are reported. 0 uses the default (10s).
+	HeartbeatInterval int `toml:"heartbeat_interval,omitempty"`
+	// AllowedCommands narrows the commands sessions may run to a subset of
+	// the built-in allow-list (claude, bash, zsh, sh, powershell, cmd).
+	// Empty allows all of them.
+	AllowedCommands []string `toml:"allowed_commands,omitempty"`
+	// ConfigSigningKey is the base64 ed25519 public key that config_update
+	// messages from the cloud must be signed with. Remote configuration is
+	// refused while it is empty.
+	ConfigSigningKey string `toml:"config_signing_key,omitempty"`
+	// RemoteConfigVersion is the version of the last config_update applied;
+	// older or replayed updates are refused.
+	RemoteConfigVersion int64 `toml:"remote_config_version,omitempty"`
+	// UpdateChannel selects the releases `sessionforge update` installs:
+	// "stable" (default), "beta" or "nightly".
+	UpdateChannel string `toml:"update_channel,omitempty"`
+	// Restart is the default restart policy for sessions started from the
+	// cloud. A start_session message or a session profile can override it.
+	Restart RestartPolicy `toml:"restart,omitempty"`
+	// SessionLimits holds the global idle_timeout / max_runtime settings.
+	// Sessions started from a profile or start_session message may override them.
+	SessionLimits
+	// SessionProfiles are named session templates that a start_session
+	// message can reference by name instead of spelling out every field.
+	SessionProfiles map[string]SessionProfile `toml:"session_profiles,omitempty"`
+	// ActiveProfile selects the [profiles.<name>] credentials used when
+	// --profile is not given. Empty uses the top-level keys ("default").
+	ActiveProfile string `toml:"active_profile,omitempty"`
+	// ServeProfiles are additional profiles the daemon connects at the same
+	// time as the active one, each with its own sessions.
+	ServeProfiles []string `toml:"serve_profiles,omitempty"`
+	// Profiles are named credentials for other servers or accounts.
+	Profiles map[string]Profile `toml:"profiles,omitempty"`
+}
+
+// Restart modes accepted by RestartPolicy.Mode.
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// RestartPolicy controls whether a session is respawned after its process exits.
+// Zero values select the defaults noted on each field.
+type RestartPolicy struct {
+	// Mode is "never" (default), "on-failure" (non-zero exit or crash) or "always".
+	// A session stopped explicitly is never restarted.
+	Mode string `toml:"mode,omitempty" json:"mode,omitempty"`
+	// MaxRetries caps consecutive restarts; 0 means unlimited.
+	MaxRetries int `toml:"max_retries,omitempty" json:"maxRetries,omitempty"`
+	// BackoffSeconds is the delay before the first restart (default 1).
+	// It doubles on every consecutive attempt.
+	BackoffSeconds int `toml:"backoff_seconds,omitempty" json:"backoffSeconds,omitempty"`
+	// MaxBackoffSeconds caps the restart delay (default 60).
+	MaxBackoffSeconds int `toml:"max_backoff_seconds,omitempty" json:"maxBackoffSeconds,omitempty"`
+	// ResetAfterSeconds is how long a run must last before the attempt
+	// counter and backoff reset to zero (default 300).
+	ResetAfterSeconds int `toml:"reset_after_seconds,omitempty" json:"resetAfterSeconds,omitempty"`
+}
+
+// Policies accepted by Config.OnUnauthorized.
+const (
+	UnauthorizedKeep = "keep"
+	UnauthorizedStop = "stop"
+)
+
+// Release channels accepted by Config.UpdateChannel.
+const (
+	UpdateChannelStable  = "stable"
+	UpdateChannelBeta    = "beta"
+	UpdateChannelNightly = "nightly"
+)
+
+// Timeout actions accepted by SessionLimits.TimeoutAction.
+const (
+	TimeoutActionStop  = "stop"
+	TimeoutActionPause = "pause"
+)
+
+// SessionLimits bounds how long a session may stay open. All durations are in
+// minutes. In the global config 0 means "no limit"; in a profile or
+// start_session message 0 inherits the global value and a negative value
+// disables the limit for that session.
+type SessionLimits struct {
+	// IdleTimeout acts on a session with no PTY input or output for this long.
+	IdleTimeout int `toml:"idle_timeout,omitempty" json:"idleTimeout,omitempty"`
+	// MaxRuntime acts on a session that has been open for this long.
+	MaxRuntime int `toml:"max_runtime,omitempty" json:"maxRuntime,omitempty"`
+	// TimeoutAction is "stop" (default) or "pause".
+	TimeoutAction string `toml:"timeout_action,omitempty" json:"timeoutAction,omitempty"`
+	// TimeoutWarning is how long before acting the cloud and terminal are
+	// warned (default 5, capped at half the limit).
+	TimeoutWarning int `toml:"timeout_warning,omitempty" json:"timeoutWarning,omitempty"`
+}
+
+// SessionProfile is a named session template from [session_profiles.<name>].
+type SessionProfile struct {
+	// Command is the command to run (e.g. "claude").
+	Command string `toml:"command"`
+	// Workdir is the working directory for the session.
+	Workdir string `toml:"workdir,omitempty"`
+	// Env is merged into the session environment.
+	Env map[string]string `toml:"env,omitempty"`
+	// Restart overrides the global restart policy for this profile.
+	Restart *RestartPolicy `toml:"restart,omitempty"`
+	// SessionLimits overrides the global idle_timeout / max_runtime settings.
+	SessionLimits
+}
+
+// SessionOptions carries optional per-session settings sent with
+// start_session. Empty fields fall back to the profile, then to config.
+type SessionOptions struct {
+	// Profile names an entry in SessionProfiles.
+	Profile string `json:"profile,omitempty"`
+	// Restart overrides the profile and global restart policy.
+	Restart *RestartPolicy `json:"restart,omitempty"`
+	// Limits overrides the profile and global session limits field by field.
+	Limits SessionLimits `json:"limits,omitempty"`
 }
 
 // DefaultConfig returns a Config populated with sensible defaults.
 func DefaultConfig() *Config {
 	return &Config{
-		ServerURL: DefaultServerURL,
-		LogLevel:  DefaultLogLevel,
+		ConfigVersion: CurrentConfigVersion,
+		ServerURL:     DefaultServerURL,
+		LogLevel:      DefaultLogLevel,
+		EscapeChar:    DefaultEscapeChar,
+		DetachKey:     DefaultDetachKey,
 	}
 }
 
@@ -75,6 +258,15 @@ func ConfigPath() (string, error) {
 	return filepath.Join(dir, configFile), nil
 }
 
+// PathIn returns the config file path inside dir, or the default path if
+// dir is empty.
+func PathIn(dir string) (string, error) {
+	if dir == "" {
+		return ConfigPath()
+	}
+	return filepath.Join(dir, configFile), nil
+}
+
 // Load reads the config file from the default location (~/.sessionforge/config.toml).
 // If the file does not exist, it returns the default configuration without error.
 func Load() (*Config, error) {
@@ -83,104 +275,98 @@ func Load() (*Config, error) {
 
 // LoadFrom reads the config file from the given directory.
 // If dir is empty, it falls back to the default ~/.sessionforge directory.
+// Environment overrides are not applied; see LoadEffective.
 func LoadFrom(dir string) (*Config, error) {
-	var path string
-	if dir != "" {
-		path = filepath.Join(dir, configFile)
-	} else {
-		var err error
-		path, err = ConfigPath()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	cfg := DefaultConfig()
-
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// No config file yet; return defaults.
-		return cfg, nil
-	}
-
-	if _, err := toml.DecodeFile(path, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
-	}
-
-	return cfg, nil
+	cfg, _, err := loadFile(dir)
+	return cfg, err
 }
 
 // SaveFrom writes the config to the given directory, creating it if needed.
 // If dir is empty it falls back to the default ~/.sessionforge directory.
+// The file is replaced via a temp file and rename, so a crash or a
+// concurrent reader never sees a half-written config.
 func SaveFrom(dir string, cfg *Config) error {
-	var path string
-	if dir != "" {
-		if err := os.MkdirAll(dir, 0700); err != nil {
-			return fmt.Errorf("failed to create config directory %s: %w", dir, err)
-		}
-		path = filepath.Join(dir, configFile)
-	} else {
-		var err error
-		path, err = ConfigPath()
-		if err != nil {
-			return err
-		}
-		d := filepath.Dir(path)
-		if err := os.MkdirAll(d, 0700); err != nil {
-			return fmt.Errorf("failed to create config directory %s: %w", d, err)
-		}
-	}
-
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	path, err := PathIn(dir)
 	if err != nil {
-		return fmt.Errorf("failed to open config file %s for writing: %w", path, err)
+		return err
 	}
-	defer f.Close()
-
-	enc := toml.NewEncoder(f)
-	if err := enc.Encode(cfg); err != nil {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(cfg); err != nil {
 		return fmt.Errorf("failed to encode config: %w", err)
 	}
-	return nil
+	return writeFileAtomic(path, buf.Bytes())
 }
 
-// Save writes the config to disk, creating the directory if needed.
-func Save(cfg *Config) error {
-	dir, err := ConfigDir()
-	if err != nil {
-		return err
-	}
-
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return fmt.Errorf("failed to create config directory %s: %w", dir, err)
+// writeFileAtomic replaces path with data via a temp file in the same
+// directory, creating the directory if needed.
+func writeFileAtomic(path string, data []byte) error {
+	d := filepath.Dir(path)
+	if err := os.MkdirAll(d, 0700); err != nil {
+		return fmt.Errorf("failed to create config directory %s: %w", d, err)
 	}
 
-	path, err := ConfigPath()
+	f, err := os.CreateTemp(d, configFile+".*.tmp")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create temp config file in %s: %w", d, err)
 	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // no-op once renamed
 
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to open config file %s for writing: %w", path, err)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
 	}
-	defer f.Close()
-
-	enc := toml.NewEncoder(f)
-	if err := enc.Encode(cfg); err != nil {
-		return fmt.Errorf("failed to encode config: %w", err)
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set config file permissions: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace config file %s: %w", path, err)
 	}
-
 	return nil
 }
 
+// Save writes the config to disk, creating the directory if needed.
+func Save(cfg *Config) error {
+	return SaveFrom("", cfg)
+}
+
 // IsConfigured returns true if the minimum required fields are present.
 func (c *Config) IsConfigured() bool {
-	return c.APIKey != "" && c.MachineID != ""
+	return c.HasAPIKey() && c.MachineID != ""
+}
+
+// Endpoints returns the server URLs in order of preference: server_url
+// followed by server_urls, without trailing slashes or duplicates.
+func (c *Config) Endpoints() []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, u := range append([]string{c.ServerURL}, c.ServerURLs...) {
+		u = strings.TrimRight(strings.TrimSpace(u), "/")
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	return urls
 }
 
-// WebSocketURL constructs the WebSocket endpoint URL from ServerURL and APIKey.
+// WebSocketURL constructs the WebSocket endpoint URL from ServerURL.
 func (c *Config) WebSocketURL() string {
-	base := c.ServerURL
+	return WebSocketURLFor(c.ServerURL)
+}
+
+// WebSocketURLFor constructs the WebSocket endpoint URL for a server base URL.
+func WebSocketURLFor(serverURL string) string {
+	base := serverURL
 	// Replace https:// with wss:// and http:// with ws://
 	switch {
 	case len(base) >= 8 && base[:8] == "https://":
@@ -188,5 +374,7 @@ func (c *Config) WebSocketURL() string {
 	case len(base) >= 7 && base[:7] == "http://":
 		base = "ws://" + base[7:]
 	}
-	return base + "/api/ws/agent?key=" + c.APIKey
+	// Credentials travel in the Authorization header, never the URL, so they
+	// stay out of logs and proxy access logs.
+	return base + "/api/ws/agent"
 }
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAPIKeyCredential is the systemd credential read from
+	// $CREDENTIALS_DIRECTORY when api_key_credential is not set, e.g.
+	// LoadCredential=sessionforge-api-key:/etc/sessionforge/api-key.
+	DefaultAPIKeyCredential = "sessionforge-api-key"
+	// apiKeyCommandTimeout bounds api_key_command, which may prompt a GPG
+	// agent or a keychain.
+	apiKeyCommandTimeout = 30 * time.Second
+	// maxAPIKeySize caps what is read from a helper, fd or credential file.
+	maxAPIKeySize = 4096
+)
+
+// apiKeyCache holds keys obtained from helpers for the life of the process.
+// They are never written to disk.
+var apiKeyCache = struct {
+	sync.Mutex
+	keys map[string]string
+}{keys: map[string]string{}}
+
+// HasAPIKey reports whether any API key source is configured.
+func (c *Config) HasAPIKey() bool {
+	return c.APIKey != "" || c.apiKeyFD() > 0 || c.APIKeyCommand != "" || c.credentialPath() != ""
+}
+
+// APIKeySource describes where ResolveAPIKey gets the key, for status output.
+func (c *Config) APIKeySource() string {
+	switch {
+	case c.apiKeyFD() > 0:
+		return "file descriptor " + strconv.Itoa(c.apiKeyFD())
+	case c.credentialPath() != "":
+		return "systemd credential " + filepath.Base(c.credentialPath())
+	case c.APIKeyCommand != "":
+		return "api_key_command"
+	case c.APIKey != "":
+		return "config"
+	}
+	return ""
+}
+
+// ResolveAPIKey returns the API key from the first configured source:
+// api_key_fd, a systemd credential, api_key_command, then the plaintext
+// api_key. Keys from the first three are cached in memory only. The fd and
+// the credential hold the top-level key, so they are skipped for a profile
+// with a key of its own.
+func (c *Config) ResolveAPIKey() (string, error) {
+	var (
+		cacheKey string
+		fetch    func() (string, error)
+	)
+	switch fd := c.apiKeyFD(); {
+	case fd > 0:
+		cacheKey = "fd:" + strconv.Itoa(fd)
+		fetch = func() (string, error) { return readAPIKeyFD(fd) }
+	case c.credentialPath() != "":
+		path := c.credentialPath()
+		cacheKey = "credential:" + path
+		fetch = func() (string, error) { return readAPIKeyFile(path) }
+	case c.APIKeyCommand != "":
+		cacheKey = "command:" + c.APIKeyCommand
+		fetch = func() (string, error) { return runAPIKeyCommand(c.APIKeyCommand) }
+	default:
+		return c.APIKey, nil
+	}
+
+	apiKeyCache.Lock()
+	defer apiKeyCache.Unlock()
+	if key, ok := apiKeyCache.keys[cacheKey]; ok {
+		return key, nil
+	}
+	key, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	apiKeyCache.keys[cacheKey] = key
+	return key, nil
+}
+
+// ForgetAPIKey drops cached helper and credential keys so the next
+// ResolveAPIKey fetches a fresh one, e.g. after the key was rotated. A key
+// read from a file descriptor is kept: the descriptor can only be read once.
+func ForgetAPIKey() {
+	apiKeyCache.Lock()
+	defer apiKeyCache.Unlock()
+	for k := range apiKeyCache.keys {
+		if !strings.HasPrefix(k, "fd:") {
+			delete(apiKeyCache.keys, k)
+		}
+	}
+}
+
+// usesTopLevelKey reports whether the selected profile authenticates with
+// the top-level key. Only then do api_key_fd and the systemd credential,
+// which are process-wide, apply; otherwise every served profile would send
+// the default key to its own server.
+func (c *Config) usesTopLevelKey() bool {
+	if isDefault(c.ActiveProfile) {
+		return true
+	}
+	p, ok := c.Profiles[c.ActiveProfile]
+	return !ok || (p.APIKey == "" && p.APIKeyCommand == "")
+}
+
+// apiKeyFD returns api_key_fd, or 0 when it does not apply to the selected
+// profile.
+func (c *Config) apiKeyFD() int {
+	if !c.usesTopLevelKey() {
+		return 0
+	}
+	return c.APIKeyFD
+}
+
+// credentialPath returns the systemd credential file holding the API key,
+// or "" when the agent was not started with one or the selected profile has
+// a key of its own.
+func (c *Config) credentialPath() string {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" || !c.usesTopLevelKey() {
+		return ""
+	}
+	name := c.APIKeyCredential
+	if name == "" {
+		name = DefaultAPIKeyCredential
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, name)
+}
+
+func readAPIKeyFD(fd int) (string, error) {
+	f := os.NewFile(uintptr(fd), "api_key_fd")
+	if f == nil {
+		return "", fmt.Errorf("api_key_fd %d is not an open file descriptor", fd)
+	}
+	defer f.Close()
+	key, err := readAPIKey(f)
+	if err != nil {
+		return "", fmt.Errorf("api_key_fd %d: %w", fd, err)
+	}
+	return key, nil
+}
+
+func readAPIKeyFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read API key credential: %w", err)
+	}
+	defer f.Close()
+	key, err := readAPIKey(f)
+	if err != nil {
+		return "", fmt.Errorf("API key credential %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// runAPIKeyCommand runs a git-style credential helper through the shell and
+// takes the first line of its output as the key. Its stderr is passed
+// through so prompts and errors reach the user.
+func runAPIKeyCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiKeyCommandTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("api_key_command failed: %w", err)
+	}
+	key, err := readAPIKey(strings.NewReader(string(out)))
+	if err != nil {
+		return "", fmt.Errorf("api_key_command: %w", err)
+	}
+	return key, nil
+}
+
+// readAPIKey returns the first line of r, trimmed.
+func readAPIKey(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(io.LimitReader(r, maxAPIKeySize)).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	key := strings.TrimSpace(line)
+	if key == "" {
+		return "", errors.New("no API key in output")
+	}
+	return key, nil
+}
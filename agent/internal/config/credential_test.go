@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResolveAPIKey_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	t.Cleanup(ForgetAPIKey)
+	counter := filepath.Join(t.TempDir(), "runs")
+	cfg := DefaultConfig()
+	cfg.APIKey = "sf_live_plaintext"
+	cfg.APIKeyCommand = "echo run >> " + counter + "; printf 'sf_live_helper\\nignored\\n'"
+
+	for i := 0; i < 2; i++ {
+		key, err := cfg.ResolveAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "sf_live_helper" {
+			t.Fatalf("key = %q, want the helper's first line", key)
+		}
+	}
+	if runs := countLines(t, counter); runs != 1 {
+		t.Errorf("helper ran %d times, want 1 (cached)", runs)
+	}
+	ForgetAPIKey()
+	if _, err := cfg.ResolveAPIKey(); err != nil {
+		t.Fatal(err)
+	}
+	if runs := countLines(t, counter); runs != 2 {
+		t.Errorf("helper ran %d times after ForgetAPIKey, want 2", runs)
+	}
+
+	cfg.APIKeyCommand = "exit 3"
+	if _, err := cfg.ResolveAPIKey(); err == nil {
+		t.Error("failing helper returned a key")
+	}
+	cfg.APIKeyCommand = "true"
+	if _, err := cfg.ResolveAPIKey(); err == nil {
+		t.Error("helper with no output returned a key")
+	}
+}
+
+func TestResolveAPIKey_FDAndCredential(t *testing.T) {
+	t.Cleanup(ForgetAPIKey)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, DefaultAPIKeyCredential), []byte("sf_live_systemd\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	cfg := DefaultConfig()
+	cfg.APIKeyCommand = "echo sf_live_helper"
+	if key, err := cfg.ResolveAPIKey(); err != nil || key != "sf_live_systemd" {
+		t.Fatalf("key = %q, %v; want the systemd credential over the helper", key, err)
+	}
+	if src := cfg.APIKeySource(); !strings.Contains(src, DefaultAPIKeyCredential) {
+		t.Errorf("source = %q", src)
+	}
+
+	cfg.MachineID = "m-1"
+	if !cfg.IsConfigured() {
+		t.Error("IsConfigured ignores the systemd credential")
+	}
+}
+
+func TestResolveAPIKey_ServedProfilesKeepTheirOwnKeys(t *testing.T) {
+	t.Cleanup(ForgetAPIKey)
+	credDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(credDir, DefaultAPIKeyCredential), []byte("sf_live_prod\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", credDir)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, configFile), []byte(`
+serve_profiles = ["staging", "mirror"]
+
+[profiles.staging]
+server_url = "https://staging.example.com"
+api_key = "sf_live_staging"
+
+[profiles.mirror]
+server_url = "https://mirror.example.com"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	top, err := LoadFrom(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		DefaultProfile: "sf_live_prod",
+		"staging":      "sf_live_staging",
+		"mirror":       "sf_live_prod", // no key of its own: inherits the top level
+	}
+	for _, name := range top.ServedProfiles() {
+		cfg, sources, err := LoadEffective(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.ActiveProfile = name
+		if err := ApplyProfile(cfg, sources, name); err != nil {
+			t.Fatal(err)
+		}
+		if key, err := cfg.ResolveAPIKey(); err != nil || key != want[name] {
+			t.Errorf("%s: key = %q, %v; want %q", name, key, err, want[name])
+		}
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package config
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestResolveAPIKey_FD(t *testing.T) {
+	t.Cleanup(ForgetAPIKey)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString("sf_live_fd\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	// Hand over a descriptor that r does not own, as an inherited one would be.
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	cfg := DefaultConfig()
+	cfg.APIKeyCommand = "echo sf_live_helper"
+	cfg.APIKeyFD = fd
+	for i := 0; i < 2; i++ {
+		if key, err := cfg.ResolveAPIKey(); err != nil || key != "sf_live_fd" {
+			t.Fatalf("read %d: key = %q, %v; want the fd key", i, key, err)
+		}
+		ForgetAPIKey() // must not drop a key that cannot be read again
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ChangedFields returns the config.toml keys whose values differ between a
+// and b, in declaration order. Keys of the embedded SessionLimits are
+// reported individually.
+func ChangedFields(a, b *Config) []string {
+	return changedFields(reflect.ValueOf(*a), reflect.ValueOf(*b))
+}
+
+func changedFields(a, b reflect.Value) []string {
+	var changed []string
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			changed = append(changed, changedFields(a.Field(i), b.Field(i))...)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, tomlKey(f))
+		}
+	}
+	return changed
+}
+
+// tomlKey returns the config.toml name of a struct field.
+func tomlKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix starts the environment variables that override config.toml.
+// The rest of the name is the upper-cased key with dots as underscores,
+// e.g. SESSIONFORGE_LOG_LEVEL or SESSIONFORGE_RESTART_MODE. Lists are
+// comma-separated. Tables such as session_profiles can only be set in the
+// file.
+const EnvPrefix = "SESSIONFORGE_"
+
+// Source identifies where an effective config value came from. Precedence,
+// highest first: flag, env, file, default.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Sources maps each config key to the layer its effective value came from.
+type Sources map[string]Source
+
+// field is one settable config key.
+type field struct {
+	key   string   // dotted name, e.g. "restart.mode"
+	path  []string // TOML path, e.g. ["restart", "mode"]
+	index []int    // reflect index path into Config
+}
+
+// EnvName returns the environment variable that overrides key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func (f field) value(cfg *Config) reflect.Value {
+	return reflect.ValueOf(cfg).Elem().FieldByIndex(f.index)
+}
+
+// configFields lists every key in declaration order. Nested tables with
+// fixed fields (restart) are expanded; the embedded session limits appear
+// at top level as they do in the file.
+var configFields = walkFields(reflect.TypeOf(Config{}), nil, nil)
+
+func walkFields(t reflect.Type, path []string, index []int) []field {
+	var out []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int(nil), index...), i)
+		if f.Anonymous {
+			out = append(out, walkFields(f.Type, path, idx)...)
+			continue
+		}
+		p := append(append([]string(nil), path...), tomlKey(f))
+		if f.Type.Kind() == reflect.Struct {
+			out = append(out, walkFields(f.Type, p, idx)...)
+			continue
+		}
+		out = append(out, field{key: strings.Join(p, "."), path: p, index: idx})
+	}
+	return out
+}
+
+func lookupField(key string) (field, error) {
+	for _, f := range configFields {
+		if f.key == key {
+			return f, nil
+		}
+	}
+	return field{}, fmt.Errorf("unknown config key %q (see: sessionforge config list)", key)
+}
+
+// Keys returns every config key in declaration order.
+func Keys() []string {
+	keys := make([]string, len(configFields))
+	for i, f := range configFields {
+		keys[i] = f.key
+	}
+	return keys
+}
+
+// LoadEffective loads config.toml from dir (the default directory if empty),
+// applies SESSIONFORGE_* overrides from the environment and records where
+// each value came from. Callers layer command-line flags on top. Commands
+// that write the file must use LoadFrom instead, so environment values are
+// never persisted.
+func LoadEffective(dir string) (*Config, Sources, error) {
+	cfg, md, err := loadFile(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources := Sources{}
+	for _, f := range configFields {
+		sources[f.key] = SourceDefault
+		if md.IsDefined(f.path...) {
+			sources[f.key] = SourceFile
+		}
+	}
+	if err := applyEnv(cfg, sources, os.LookupEnv); err != nil {
+		return nil, nil, err
+	}
+	return cfg, sources, nil
+}
+
+// applyEnv overrides cfg from lookup, which has the signature of os.LookupEnv.
+func applyEnv(cfg *Config, sources Sources, lookup func(string) (string, bool)) error {
+	for _, f := range configFields {
+		raw, ok := lookup(EnvName(f.key))
+		if !ok {
+			continue
+		}
+		if err := setField(f.value(cfg), raw); err != nil {
+			return fmt.Errorf("%s: %w", EnvName(f.key), err)
+		}
+		sources[f.key] = SourceEnv
+	}
+	return nil
+}
+
+// Get returns the value of key formatted as `config get` prints it.
+func Get(cfg *Config, key string) (string, error) {
+	f, err := lookupField(key)
+	if err != nil {
+		return "", err
+	}
+	return formatField(f.value(cfg)), nil
+}
+
+// Set parses value according to key's type and stores it in cfg.
+func Set(cfg *Config, key, value string) error {
+	f, err := lookupField(key)
+	if err != nil {
+		return err
+	}
+	if err := setField(f.value(cfg), value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// Unset restores key to its default value in cfg.
+func Unset(cfg *Config, key string) error {
+	f, err := lookupField(key)
+	if err != nil {
+		return err
+	}
+	f.value(cfg).Set(f.value(DefaultConfig()))
+	return nil
+}
+
+func setField(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("this key is a table; edit config.toml to change it")
+	}
+	return nil
+}
+
+func formatField(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	case reflect.Map:
+		var names []string
+		for _, k := range v.MapKeys() {
+			names = append(names, k.String())
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	return v.String()
+}
+
+// loadFile reads config.toml over the defaults, migrating it to the current
+// schema and warning about unknown keys. A missing file yields the defaults
+// and empty metadata.
+func loadFile(dir string) (*Config, toml.MetaData, error) {
+	path, err := PathIn(dir)
+	if err != nil {
+		return nil, toml.MetaData{}, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// No config file yet; return defaults.
+		return DefaultConfig(), toml.MetaData{}, nil
+	}
+	cfg, md, unknown, err := decodeFile(path)
+	if err != nil {
+		return nil, toml.MetaData{}, err
+	}
+	if len(unknown) > 0 {
+		slog.Warn("config: ignoring unknown keys", "file", path, "keys", unknown)
+	}
+	return cfg, md, nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadEffective_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, configFile), []byte(`
+log_level = "warn"
+heartbeat_interval = 20
+[restart]
+mode = "on-failure"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSIONFORGE_HEARTBEAT_INTERVAL", "45")
+	t.Setenv("SESSIONFORGE_RESTART_MAX_RETRIES", "7")
+	t.Setenv("SESSIONFORGE_SERVER_URLS", "https://a.example.com, https://b.example.com")
+
+	cfg, sources, err := LoadEffective(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != "warn" || sources["log_level"] != SourceFile {
+		t.Errorf("log_level = %q (%s), want warn from file", cfg.LogLevel, sources["log_level"])
+	}
+	if cfg.HeartbeatInterval != 45 || sources["heartbeat_interval"] != SourceEnv {
+		t.Errorf("heartbeat_interval = %d (%s), want 45 from env", cfg.HeartbeatInterval, sources["heartbeat_interval"])
+	}
+	if cfg.Restart.Mode != "on-failure" || sources["restart.mode"] != SourceFile {
+		t.Errorf("restart.mode = %q (%s), want on-failure from file", cfg.Restart.Mode, sources["restart.mode"])
+	}
+	if cfg.Restart.MaxRetries != 7 || sources["restart.max_retries"] != SourceEnv {
+		t.Errorf("restart.max_retries = %d (%s), want 7 from env", cfg.Restart.MaxRetries, sources["restart.max_retries"])
+	}
+	if strings.Join(cfg.ServerURLs, " ") != "https://a.example.com https://b.example.com" {
+		t.Errorf("server_urls = %v", cfg.ServerURLs)
+	}
+	if sources["server_url"] != SourceDefault {
+		t.Errorf("server_url source = %s, want default", sources["server_url"])
+	}
+
+	file, err := LoadFrom(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.HeartbeatInterval != 20 {
+		t.Errorf("LoadFrom applied env: heartbeat_interval = %d", file.HeartbeatInterval)
+	}
+}
+
+func TestLoadEffective_BadEnv(t *testing.T) {
+	t.Setenv("SESSIONFORGE_IDLE_TIMEOUT", "soon")
+	if _, _, err := LoadEffective(t.TempDir()); err == nil || !strings.Contains(err.Error(), "SESSIONFORGE_IDLE_TIMEOUT") {
+		t.Fatalf("err = %v, want it to name the variable", err)
+	}
+}
+
+func TestSetUnsetAndSave(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	if err := Set(cfg, "allowed_commands", "claude,bash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set(cfg, "restart.mode", "always"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set(cfg, "session_profiles", "x"); err == nil {
+		t.Error("setting a table key succeeded")
+	}
+	if err := Set(cfg, "no_such_key", "x"); err == nil {
+		t.Error("setting an unknown key succeeded")
+	}
+	if err := SaveFrom(dir, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadFrom(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := Get(got, "allowed_commands"); v != "claude,bash" {
+		t.Errorf("allowed_commands = %q", v)
+	}
+	if err := Unset(got, "restart.mode"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Restart.Mode != DefaultConfig().Restart.Mode {
+		t.Errorf("restart.mode = %q after unset", got.Restart.Mode)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != configFile {
+		t.Errorf("config dir holds %v, want only %s", entries, configFile)
+	}
+}
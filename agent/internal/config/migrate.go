@@ -0,0 +1,176 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CurrentConfigVersion is the config.toml schema this agent writes. Files
+// with an older config_version are migrated on load and written back.
+const CurrentConfigVersion = 1
+
+// migration upgrades a decoded config.toml to version. It edits the raw
+// TOML tables so keys can be renamed or moved before the file is decoded
+// into Config.
+type migration struct {
+	version int
+	name    string
+	apply   func(raw map[string]any) error
+}
+
+// migrations run in order; each one runs when the file is older than its
+// version. Append new steps here and bump CurrentConfigVersion.
+var migrations = []migration{
+	{1, "lower-case enumerations and trim server URLs", migrateEnumsAndURLs},
+}
+
+// migrateEnumsAndURLs brings files written before validation existed into
+// line with it: the logger and policies used to accept values in any case,
+// and a trailing slash on a server URL was silently ignored.
+func migrateEnumsAndURLs(raw map[string]any) error {
+	lower := func(table map[string]any, key string) {
+		if s, ok := table[key].(string); ok {
+			table[key] = strings.ToLower(strings.TrimSpace(s))
+		}
+	}
+	trimURL := func(table map[string]any, key string) {
+		switch v := table[key].(type) {
+		case string:
+			table[key] = strings.TrimRight(strings.TrimSpace(v), "/")
+		case []any:
+			for i, u := range v {
+				if s, ok := u.(string); ok {
+					v[i] = strings.TrimRight(strings.TrimSpace(s), "/")
+				}
+			}
+		}
+	}
+
+	lower(raw, "log_level")
+	lower(raw, "on_unauthorized")
+	lower(raw, "timeout_action")
+	trimURL(raw, "server_url")
+	trimURL(raw, "server_urls")
+	if restart, ok := raw["restart"].(map[string]any); ok {
+		lower(restart, "mode")
+	}
+	if profiles, ok := raw["session_profiles"].(map[string]any); ok {
+		for _, p := range profiles {
+			if p, ok := p.(map[string]any); ok {
+				lower(p, "timeout_action")
+				if restart, ok := p["restart"].(map[string]any); ok {
+					lower(restart, "mode")
+				}
+			}
+		}
+	}
+	if profiles, ok := raw["profiles"].(map[string]any); ok {
+		for _, p := range profiles {
+			if p, ok := p.(map[string]any); ok {
+				trimURL(p, "server_url")
+				trimURL(p, "server_urls")
+			}
+		}
+	}
+	return nil
+}
+
+// fileVersion returns the config_version of a decoded file; 0 if absent.
+func fileVersion(raw map[string]any) (int, error) {
+	v, ok := raw["config_version"]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.(int64)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("config_version must be a non-negative integer")
+	}
+	return int(n), nil
+}
+
+// migrate applies the migrations newer than the file's version and reports
+// whether any ran. Files from a newer agent are left as they are.
+func migrate(raw map[string]any, path string) (bool, error) {
+	version, err := fileVersion(raw)
+	if err != nil {
+		return false, err
+	}
+	if version > CurrentConfigVersion {
+		slog.Warn("config: file was written by a newer agent; unknown settings are ignored",
+			"file", path, "config_version", version, "supported", CurrentConfigVersion)
+		return false, nil
+	}
+	ran := false
+	for _, m := range migrations {
+		if version >= m.version {
+			continue
+		}
+		if err := m.apply(raw); err != nil {
+			return false, fmt.Errorf("migrate config to version %d (%s): %w", m.version, m.name, err)
+		}
+		slog.Info("config: migrated", "file", path, "to", m.version, "step", m.name)
+		version = m.version
+		ran = true
+	}
+	raw["config_version"] = int64(version)
+	return ran, nil
+}
+
+// decodeFile reads path, migrates it and decodes it over the defaults. It
+// returns the decoding metadata and the keys Config does not know about. A
+// migrated file is written back.
+func decodeFile(path string) (*Config, toml.MetaData, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, toml.MetaData{}, nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	raw := map[string]any{}
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return nil, toml.MetaData{}, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	migrated, err := migrate(raw, path)
+	if err != nil {
+		return nil, toml.MetaData{}, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return nil, toml.MetaData{}, nil, fmt.Errorf("failed to encode migrated config: %w", err)
+	}
+	cfg := DefaultConfig()
+	md, err := toml.Decode(buf.String(), cfg)
+	if err != nil {
+		return nil, toml.MetaData{}, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	var unknown []string
+	for _, key := range md.Undecoded() {
+		unknown = append(unknown, key.String())
+	}
+	if migrated {
+		// Write back the migrated tables rather than cfg so unknown keys
+		// survive for the user to fix. A read-only file is still usable.
+		if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+			slog.Warn("config: migrated in memory but could not save", "file", path, "err", err)
+		}
+	}
+	return cfg, md, unknown, nil
+}
+
+// UnknownKeys returns the keys in config.toml under dir that the agent does
+// not recognise, typically typos.
+func UnknownKeys(dir string) ([]string, error) {
+	path, err := PathIn(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	_, _, unknown, err := decodeFile(path)
+	return unknown, err
+}
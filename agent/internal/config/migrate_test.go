@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadFrom_MigratesAndWritesBack(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFile)
+	if err := os.WriteFile(path, []byte(`
+server_url = "https://self-hosted.example.com/"
+log_level = "DEBUG"
+log_levle = "warn"
+
+[restart]
+mode = "On-Failure"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFrom(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConfigVersion != CurrentConfigVersion {
+		t.Errorf("config_version = %d, want %d", cfg.ConfigVersion, CurrentConfigVersion)
+	}
+	if cfg.ServerURL != "https://self-hosted.example.com" || cfg.LogLevel != "debug" || cfg.Restart.Mode != RestartOnFailure {
+		t.Errorf("not migrated: server_url=%q log_level=%q restart.mode=%q", cfg.ServerURL, cfg.LogLevel, cfg.Restart.Mode)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("migrated config invalid: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "config_version = 1") {
+		t.Errorf("migrated file not written back:\n%s", data)
+	}
+	unknown, err := UnknownKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(unknown, ",") != "log_levle" {
+		t.Errorf("unknown keys = %v, want the typo kept and reported", unknown)
+	}
+}
+
+func TestLoadFrom_NewerVersionUntouched(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFile)
+	content := "config_version = 99\nlog_level = \"INFO\"\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFrom(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConfigVersion != 99 || cfg.LogLevel != "INFO" {
+		t.Errorf("newer file was migrated: %+v", cfg)
+	}
+	if data, _ := os.ReadFile(path); string(data) != content {
+		t.Errorf("newer file rewritten:\n%s", data)
+	}
+}
+
+func TestValidate_TypedFields(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		edit func(*Config)
+	}{
+		{"ws scheme", func(c *Config) { c.ServerURL = "wss://example.com" }},
+		{"no host", func(c *Config) { c.ServerURLs = []string{"https://"} }},
+		{"profile url", func(c *Config) { c.Profiles = map[string]Profile{"x": {ServerURL: "example.com"}} }},
+		{"proxy scheme", func(c *Config) { c.ProxyURL = "ftp://proxy:21" }},
+		{"relative log", func(c *Config) { c.LogFile = "agent.log" }},
+		{"half mtls", func(c *Config) { c.TLSClientCert = filepath.Join(t.TempDir(), "c.pem") }},
+		{"installed via", func(c *Config) { c.ClaudeInstalledVia = "brew" }},
+		{"signing key", func(c *Config) { c.ConfigSigningKey = "not-a-key" }},
+		{"update channel", func(c *Config) { c.UpdateChannel = "edge" }},
+	} {
+		cfg := DefaultConfig()
+		tc.edit(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.ProxyURL = "socks5://127.0.0.1:1080"
+	cfg.LogFile = filepath.Join(t.TempDir(), "agent.log")
+	cfg.UpdateChannel = UpdateChannelBeta
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+// DefaultProfile names the credentials stored in the top-level server_url,
+// api_key, machine_id and machine_name keys.
+const DefaultProfile = "default"
+
+// SourceProfile marks a value taken from the selected [profiles.<name>] table.
+const SourceProfile Source = "profile"
+
+var profileNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Profile is a named set of server credentials, stored as a
+// [profiles.<name>] table. Empty fields fall back to the top-level values,
+// except that a profile with its own server_url does not inherit the
+// top-level server_urls fallbacks, and a profile with its own api_key or
+// api_key_command replaces both top-level settings.
+type Profile struct {
+	ServerURL     string   `toml:"server_url,omitempty"`
+	ServerURLs    []string `toml:"server_urls,omitempty"`
+	APIKey        string   `toml:"api_key,omitempty"`
+	APIKeyCommand string   `toml:"api_key_command,omitempty"`
+	MachineID     string   `toml:"machine_id,omitempty"`
+	MachineName   string   `toml:"machine_name,omitempty"`
+	// ConfigSigningKey and RemoteConfigVersion guard config_update messages
+	// from this profile's server. They are never inherited from the top
+	// level, so each server needs its own key and keeps its own counter.
+	ConfigSigningKey    string `toml:"config_signing_key,omitempty"`
+	RemoteConfigVersion int64  `toml:"remote_config_version,omitempty"`
+	// Remote holds the settings this profile's server pushed with
+	// config_update. They override the top-level values for this profile.
+	Remote *RemoteConfig `toml:"remote,omitempty"`
+}
+
+// isDefault reports whether name selects the top-level credentials.
+func isDefault(name string) bool {
+	return name == "" || name == DefaultProfile
+}
+
+// ProfileNames returns "default" followed by the named profiles, sorted.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
+}
+
+// HasProfile reports whether name is "default" or a configured profile.
+func (c *Config) HasProfile(name string) bool {
+	if isDefault(name) {
+		return true
+	}
+	_, ok := c.Profiles[name]
+	return ok
+}
+
+// GetProfile returns the credentials stored under name without inheriting
+// from the top level.
+func (c *Config) GetProfile(name string) (Profile, error) {
+	if isDefault(name) {
+		return Profile{
+			ServerURL:     c.ServerURL,
+			ServerURLs:    c.ServerURLs,
+			APIKey:        c.APIKey,
+			APIKeyCommand: c.APIKeyCommand,
+			MachineID:     c.MachineID,
+			MachineName:   c.MachineName,
+		}, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q does not exist (see: sessionforge auth profiles list)", name)
+	}
+	return p, nil
+}
+
+// SetProfile stores p under name, creating the profile if needed.
+func (c *Config) SetProfile(name string, p Profile) error {
+	if isDefault(name) {
+		c.ServerURL = p.ServerURL
+		c.ServerURLs = p.ServerURLs
+		c.APIKey = p.APIKey
+		c.APIKeyCommand = p.APIKeyCommand
+		c.MachineID = p.MachineID
+		c.MachineName = p.MachineName
+		return nil
+	}
+	if !profileNameRE.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_'", name)
+	}
+	if c.Profiles == nil {
+		c.Profiles = map[string]Profile{}
+	}
+	c.Profiles[name] = p
+	return nil
+}
+
+// SetAPIKey replaces the plaintext API key profile name authenticates with.
+// A profile without a key of its own uses the top-level one, so the key is
+// replaced where it is stored. Keys from api_key_command cannot be written.
+func (c *Config) SetAPIKey(name, key string) error {
+	p, err := c.GetProfile(name)
+	if err != nil {
+		return err
+	}
+	if !isDefault(name) && p.APIKey == "" && p.APIKeyCommand == "" {
+		name = DefaultProfile
+		p, _ = c.GetProfile(name)
+	}
+	if p.APIKeyCommand != "" {
+		return fmt.Errorf("the API key of profile %q comes from api_key_command; store the new key there", name)
+	}
+	p.APIKey = key
+	return c.SetProfile(name, p)
+}
+
+// RemoveProfile deletes a named profile and any reference to it from
+// active_profile and serve_profiles. The default profile cannot be removed.
+func (c *Config) RemoveProfile(name string) error {
+	if isDefault(name) {
+		return fmt.Errorf("the default profile cannot be removed; use 'sessionforge auth logout' to clear it")
+	}
+	if _, ok := c.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	delete(c.Profiles, name)
+	if c.ActiveProfile == name {
+		c.ActiveProfile = ""
+	}
+	served := c.ServeProfiles[:0:0]
+	for _, s := range c.ServeProfiles {
+		if s != name {
+			served = append(served, s)
+		}
+	}
+	c.ServeProfiles = served
+	return nil
+}
+
+// ServedProfiles returns the profiles the daemon connects: the active
+// profile first, then serve_profiles without duplicates.
+func (c *Config) ServedProfiles() []string {
+	active := c.ActiveProfile
+	if isDefault(active) {
+		active = DefaultProfile
+	}
+	names := []string{active}
+	seen := map[string]bool{active: true}
+	for _, name := range c.ServeProfiles {
+		if isDefault(name) {
+			name = DefaultProfile
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ApplyProfile overlays the credentials of profile name onto the top-level
+// fields of cfg and records them in sources. Values that came from the
+// environment or a flag keep precedence over the profile.
+func ApplyProfile(cfg *Config, sources Sources, name string) error {
+	if isDefault(name) {
+		return nil
+	}
+	p, err := cfg.GetProfile(name)
+	if err != nil {
+		return err
+	}
+	overlay := func(key string, dst *string, v string) {
+		if v == "" || sources[key] == SourceEnv || sources[key] == SourceFlag {
+			return
+		}
+		*dst = v
+		sources[key] = SourceProfile
+	}
+	if p.ServerURL != "" && sources["server_urls"] != SourceEnv {
+		cfg.ServerURLs = p.ServerURLs
+		sources["server_urls"] = SourceProfile
+	}
+	overlay("server_url", &cfg.ServerURL, p.ServerURL)
+	// A plaintext key in one layer must not shadow a helper in the other.
+	if (p.APIKey != "" || p.APIKeyCommand != "") &&
+		sources["api_key"] != SourceEnv && sources["api_key"] != SourceFlag &&
+		sources["api_key_command"] != SourceEnv && sources["api_key_command"] != SourceFlag {
+		cfg.APIKey, cfg.APIKeyCommand = p.APIKey, p.APIKeyCommand
+		sources["api_key"], sources["api_key_command"] = SourceProfile, SourceProfile
+	}
+	overlay("machine_id", &cfg.MachineID, p.MachineID)
+	overlay("machine_name", &cfg.MachineName, p.MachineName)
+	applyProfileRemote(cfg, sources, p)
+	return nil
+}
+
+// applyProfileRemote replaces the config_update state of cfg with that of
+// p: its signing key, version counter and pushed settings. Environment and
+// flag overrides still win.
+func applyProfileRemote(cfg *Config, sources Sources, p Profile) {
+	next := *cfg
+	next.ConfigSigningKey = p.ConfigSigningKey
+	next.RemoteConfigVersion = p.RemoteConfigVersion
+	if p.Remote != nil {
+		p.Remote.ApplyTo(&next)
+	}
+	for _, f := range configFields {
+		if sources[f.key] == SourceEnv || sources[f.key] == SourceFlag {
+			continue
+		}
+		if v := f.value(&next); !reflect.DeepEqual(f.value(cfg).Interface(), v.Interface()) {
+			f.value(cfg).Set(v)
+			sources[f.key] = SourceProfile
+		}
+	}
+}
+
+// validateProfiles checks profile names and references to them.
+func (c *Config) validateProfiles() error {
+	for name, p := range c.Profiles {
+		if isDefault(name) || !profileNameRE.MatchString(name) {
+			return fmt.Errorf("profiles.%s: invalid profile name", name)
+		}
+		if err := validateSigningKey(p.ConfigSigningKey); err != nil {
+			return fmt.Errorf("profiles.%s: %w", name, err)
+		}
+		if p.Remote != nil {
+			if err := p.Remote.validate(); err != nil {
+				return fmt.Errorf("profiles.%s.remote: %w", name, err)
+			}
+		}
+	}
+	if !c.HasProfile(c.ActiveProfile) {
+		return fmt.Errorf("active_profile %q does not exist", c.ActiveProfile)
+	}
+	for _, name := range c.ServeProfiles {
+		if !c.HasProfile(name) {
+			return fmt.Errorf("serve_profiles: profile %q does not exist", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, configFile), []byte(`
+server_url = "https://prod.example.com"
+server_urls = ["https://prod-eu.example.com"]
+api_key = "sf_live_prod"
+machine_id = "m-prod"
+machine_name = "laptop"
+active_profile = "staging"
+
+[profiles.staging]
+server_url = "https://staging.example.com"
+api_key = "sf_live_staging"
+machine_id = "m-staging"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSIONFORGE_MACHINE_ID", "m-env")
+
+	cfg, sources, err := LoadEffective(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyProfile(cfg, sources, cfg.ActiveProfile); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServerURL != "https://staging.example.com" || sources["server_url"] != SourceProfile {
+		t.Errorf("server_url = %q (%s), want staging from profile", cfg.ServerURL, sources["server_url"])
+	}
+	if len(cfg.ServerURLs) != 0 {
+		t.Errorf("staging inherited prod fallbacks %v", cfg.ServerURLs)
+	}
+	if cfg.APIKey != "sf_live_staging" {
+		t.Errorf("api_key = %q", cfg.APIKey)
+	}
+	if cfg.MachineID != "m-env" || sources["machine_id"] != SourceEnv {
+		t.Errorf("machine_id = %q (%s), want the env override", cfg.MachineID, sources["machine_id"])
+	}
+	if cfg.MachineName != "laptop" {
+		t.Errorf("machine_name = %q, want it inherited", cfg.MachineName)
+	}
+
+	if err := ApplyProfile(cfg, sources, "missing"); err == nil {
+		t.Error("unknown profile accepted")
+	}
+}
+
+func TestProfiles_SetRemoveServe(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.SetProfile("../evil", Profile{}); err == nil {
+		t.Error("path-like profile name accepted")
+	}
+	for _, name := range []string{"staging", "eu"} {
+		if err := cfg.SetProfile(name, Profile{ServerURL: "https://" + name + ".example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.ActiveProfile = "staging"
+	cfg.ServeProfiles = []string{"default", "staging", "eu"}
+	if got := strings.Join(cfg.ServedProfiles(), ","); got != "staging,default,eu" {
+		t.Errorf("ServedProfiles = %s", got)
+	}
+	if got := strings.Join(cfg.ProfileNames(), ","); got != "default,eu,staging" {
+		t.Errorf("ProfileNames = %s", got)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.RemoveProfile(DefaultProfile); err == nil {
+		t.Error("removed the default profile")
+	}
+	if err := cfg.RemoveProfile("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ActiveProfile != "" || strings.Join(cfg.ServeProfiles, ",") != "default,eu" {
+		t.Errorf("references remain: active=%q serve=%v", cfg.ActiveProfile, cfg.ServeProfiles)
+	}
+
+	cfg.ServeProfiles = append(cfg.ServeProfiles, "gone")
+	if err := cfg.Validate(); err == nil {
+		t.Error("serve_profiles naming a missing profile passed validation")
+	}
+}
+
+func TestSetAPIKey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIKey = "sf_live_old"
+	cfg.Profiles = map[string]Profile{
+		"own":      {ServerURL: "https://a.example.com", APIKey: "sf_live_own"},
+		"inherits": {ServerURL: "https://b.example.com"},
+		"helper":   {APIKeyCommand: "pass show sf"},
+	}
+
+	if err := cfg.SetAPIKey("own", "sf_live_own2"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profiles["own"].APIKey != "sf_live_own2" || cfg.APIKey != "sf_live_old" {
+		t.Errorf("own profile: profile key %q, top-level key %q", cfg.Profiles["own"].APIKey, cfg.APIKey)
+	}
+	if err := cfg.SetAPIKey("inherits", "sf_live_new"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIKey != "sf_live_new" || cfg.Profiles["inherits"].APIKey != "" {
+		t.Errorf("inheriting profile: top-level key %q, profile key %q", cfg.APIKey, cfg.Profiles["inherits"].APIKey)
+	}
+	if err := cfg.SetAPIKey("helper", "sf_live_x"); err == nil || !strings.Contains(err.Error(), "api_key_command") {
+		t.Errorf("helper profile: err = %v, want api_key_command refusal", err)
+	}
+	if err := cfg.SetAPIKey("missing", "sf_live_x"); err == nil {
+		t.Error("missing profile accepted")
+	}
+}
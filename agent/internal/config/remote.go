@@ -0,0 +1,236 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// remoteSignaturePrefix domain-separates config_update signatures from
+// anything else the same key might sign.
+const remoteSignaturePrefix = "sessionforge-config-update:"
+
+// RemoteConfig is the partial configuration carried by a config_update
+// message. Only settings that can be applied without a restart are accepted;
+// absent fields are left unchanged. Updates for a named profile are stored
+// in its [profiles.<name>.remote] table, hence the toml tags.
+type RemoteConfig struct {
+	LogLevel          *string        `json:"logLevel,omitempty" toml:"log_level,omitempty"`
+	HeartbeatInterval *int           `json:"heartbeatInterval,omitempty" toml:"heartbeat_interval,omitempty"`
+	ExitTailLines     *int           `json:"exitTailLines,omitempty" toml:"exit_tail_lines,omitempty"`
+	Restart           *RemoteRestart `json:"restart,omitempty" toml:"restart,omitempty"`
+	Limits            *RemoteLimits  `json:"limits,omitempty" toml:"limits,omitempty"`
+	// AllowedCommands replaces the list; an empty array clears it.
+	AllowedCommands *[]string `json:"allowedCommands,omitempty" toml:"allowed_commands,omitempty"`
+}
+
+// RemoteRestart is a partial RestartPolicy: absent fields keep their value.
+type RemoteRestart struct {
+	Mode              *string `json:"mode,omitempty" toml:"mode,omitempty"`
+	MaxRetries        *int    `json:"maxRetries,omitempty" toml:"max_retries,omitempty"`
+	BackoffSeconds    *int    `json:"backoffSeconds,omitempty" toml:"backoff_seconds,omitempty"`
+	MaxBackoffSeconds *int    `json:"maxBackoffSeconds,omitempty" toml:"max_backoff_seconds,omitempty"`
+	ResetAfterSeconds *int    `json:"resetAfterSeconds,omitempty" toml:"reset_after_seconds,omitempty"`
+}
+
+// RemoteLimits is a partial SessionLimits: absent fields keep their value.
+type RemoteLimits struct {
+	IdleTimeout    *int    `json:"idleTimeout,omitempty" toml:"idle_timeout,omitempty"`
+	MaxRuntime     *int    `json:"maxRuntime,omitempty" toml:"max_runtime,omitempty"`
+	TimeoutAction  *string `json:"timeoutAction,omitempty" toml:"timeout_action,omitempty"`
+	TimeoutWarning *int    `json:"timeoutWarning,omitempty" toml:"timeout_warning,omitempty"`
+}
+
+// policy returns base with the set fields of r applied.
+func (r *RemoteRestart) policy(base RestartPolicy) RestartPolicy {
+	setFields(&base, r)
+	return base
+}
+
+// limits returns base with the set fields of r applied.
+func (r *RemoteLimits) limits(base SessionLimits) SessionLimits {
+	setFields(&base, r)
+	return base
+}
+
+// setFields copies each non-nil pointer field of src, a pointer to a struct,
+// into the field of the same name in dst, a pointer to a struct. A pointer
+// field of dst receives the pointer, any other field the value.
+func setFields(dst, src any) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		v := s.Field(i)
+		if v.IsNil() {
+			continue
+		}
+		f := d.FieldByName(s.Type().Field(i).Name)
+		if f.Kind() == reflect.Pointer {
+			f.Set(v)
+		} else {
+			f.Set(v.Elem())
+		}
+	}
+}
+
+// VerifyRemoteConfig checks the ed25519 signature over the version and the
+// exact payload bytes, using the base64 public key from config_signing_key.
+func VerifyRemoteConfig(publicKey string, version int64, payload []byte, signature string) error {
+	if publicKey == "" {
+		return errors.New("remote configuration is disabled: config_signing_key is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("config_signing_key is not a base64 ed25519 public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not base64: %w", err)
+	}
+	if !ed25519.Verify(key, RemoteConfigMessage(version, payload), sig) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// RemoteConfigMessage returns the bytes a config_update signature covers.
+func RemoteConfigMessage(version int64, payload []byte) []byte {
+	msg := []byte(remoteSignaturePrefix + strconv.FormatInt(version, 10) + ":")
+	return append(msg, payload...)
+}
+
+// ParseRemoteConfig decodes a config_update payload, rejecting unknown
+// fields and values outside the schema.
+func ParseRemoteConfig(payload []byte) (*RemoteConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	var rc RemoteConfig
+	if err := dec.Decode(&rc); err != nil {
+		return nil, fmt.Errorf("invalid config payload: %w", err)
+	}
+	if err := rc.validate(); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+func (rc *RemoteConfig) validate() error {
+	if rc.LogLevel != nil {
+		if err := ValidateLogLevel(*rc.LogLevel); err != nil {
+			return err
+		}
+	}
+	if rc.HeartbeatInterval != nil {
+		if err := ValidateHeartbeatInterval(*rc.HeartbeatInterval); err != nil {
+			return err
+		}
+	}
+	if rc.ExitTailLines != nil {
+		if err := ValidateExitTailLines(*rc.ExitTailLines); err != nil {
+			return err
+		}
+	}
+	if rc.Restart != nil {
+		if err := rc.Restart.policy(RestartPolicy{}).Validate(); err != nil {
+			return err
+		}
+	}
+	if rc.Limits != nil {
+		if err := rc.Limits.limits(SessionLimits{}).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyTo copies the set fields into cfg and returns the config.toml names
+// of those whose value changed.
+func (rc *RemoteConfig) ApplyTo(cfg *Config) []string {
+	var changed []string
+	if rc.LogLevel != nil && *rc.LogLevel != cfg.LogLevel {
+		cfg.LogLevel = *rc.LogLevel
+		changed = append(changed, "log_level")
+	}
+	if rc.HeartbeatInterval != nil && *rc.HeartbeatInterval != cfg.HeartbeatInterval {
+		cfg.HeartbeatInterval = *rc.HeartbeatInterval
+		changed = append(changed, "heartbeat_interval")
+	}
+	if rc.ExitTailLines != nil && *rc.ExitTailLines != cfg.ExitTailLines {
+		cfg.ExitTailLines = *rc.ExitTailLines
+		changed = append(changed, "exit_tail_lines")
+	}
+	if rc.Restart != nil {
+		if next := rc.Restart.policy(cfg.Restart); next != cfg.Restart {
+			cfg.Restart = next
+			changed = append(changed, "restart")
+		}
+	}
+	if rc.Limits != nil {
+		if next := rc.Limits.limits(cfg.SessionLimits); next != cfg.SessionLimits {
+			// Limits are top-level keys in config.toml, so report each one.
+			changed = append(changed, changedFields(reflect.ValueOf(cfg.SessionLimits), reflect.ValueOf(next))...)
+			cfg.SessionLimits = next
+		}
+	}
+	if rc.AllowedCommands != nil && !slices.Equal(*rc.AllowedCommands, cfg.AllowedCommands) {
+		cfg.AllowedCommands = *rc.AllowedCommands
+		changed = append(changed, "allowed_commands")
+	}
+	return changed
+}
+
+// merge copies the set fields of from into rc. Restart and limits are
+// merged field by field, so a partial update keeps the other values.
+func (rc *RemoteConfig) merge(from *RemoteConfig) {
+	if from.LogLevel != nil {
+		rc.LogLevel = from.LogLevel
+	}
+	if from.HeartbeatInterval != nil {
+		rc.HeartbeatInterval = from.HeartbeatInterval
+	}
+	if from.ExitTailLines != nil {
+		rc.ExitTailLines = from.ExitTailLines
+	}
+	if from.Restart != nil {
+		if rc.Restart == nil {
+			rc.Restart = &RemoteRestart{}
+		}
+		setFields(rc.Restart, from.Restart)
+	}
+	if from.Limits != nil {
+		if rc.Limits == nil {
+			rc.Limits = &RemoteLimits{}
+		}
+		setFields(rc.Limits, from.Limits)
+	}
+	if from.AllowedCommands != nil {
+		rc.AllowedCommands = from.AllowedCommands
+	}
+}
+
+// StoreRemoteConfig records an applied config_update for profile. The
+// default profile's settings are the top-level keys; a named profile keeps
+// them, and its version counter, in its own table so that one server's
+// updates never change another profile's settings.
+func (c *Config) StoreRemoteConfig(profile string, rc *RemoteConfig, version int64) error {
+	if isDefault(profile) {
+		rc.ApplyTo(c)
+		c.RemoteConfigVersion = version
+		return nil
+	}
+	p, err := c.GetProfile(profile)
+	if err != nil {
+		return err
+	}
+	if p.Remote == nil {
+		p.Remote = &RemoteConfig{}
+	}
+	p.Remote.merge(rc)
+	p.RemoteConfigVersion = version
+	return c.SetProfile(profile, p)
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func signRemote(t *testing.T, priv ed25519.PrivateKey, version int64, payload string) string {
+	t.Helper()
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, RemoteConfigMessage(version, []byte(payload))))
+}
+
+func TestVerifyRemoteConfig(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(pub)
+	payload := `{"logLevel":"debug"}`
+	sig := signRemote(t, priv, 7, payload)
+
+	if err := VerifyRemoteConfig(key, 7, []byte(payload), sig); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := VerifyRemoteConfig(key, 8, []byte(payload), sig); err == nil {
+		t.Error("signature accepted for a different version")
+	}
+	if err := VerifyRemoteConfig(key, 7, []byte(`{"logLevel":"error"}`), sig); err == nil {
+		t.Error("signature accepted for a different payload")
+	}
+	if err := VerifyRemoteConfig("", 7, []byte(payload), sig); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("missing key: err = %v, want remote config disabled", err)
+	}
+}
+
+func TestParseRemoteConfig(t *testing.T) {
+	rc, err := ParseRemoteConfig([]byte(`{"logLevel":"warn","heartbeatInterval":30,"limits":{"idleTimeout":60,"timeoutAction":"pause"},"allowedCommands":["claude"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	changed := rc.ApplyTo(cfg)
+	if strings.Join(changed, ",") != "log_level,heartbeat_interval,idle_timeout,timeout_action,allowed_commands" {
+		t.Fatalf("changed = %v", changed)
+	}
+	if cfg.LogLevel != "warn" || cfg.HeartbeatInterval != 30 || cfg.IdleTimeout != 60 || cfg.AllowedCommands[0] != "claude" {
+		t.Fatalf("not applied: %+v", cfg)
+	}
+	if again := rc.ApplyTo(cfg); len(again) != 0 {
+		t.Fatalf("re-applying reported changes %v", again)
+	}
+
+	for _, bad := range []string{
+		`{"apiKey":"x"}`,
+		`{"logLevel":"verbose"}`,
+		`{"heartbeatInterval":1}`,
+		`{"restart":{"mode":"sometimes"}}`,
+		`{"limits":{"timeoutAction":"explode"}}`,
+		`not json`,
+	} {
+		if _, err := ParseRemoteConfig([]byte(bad)); err == nil {
+			t.Errorf("ParseRemoteConfig(%s) accepted", bad)
+		}
+	}
+}
+
+func TestStoreRemoteConfig_ScopedToProfile(t *testing.T) {
+	dir := t.TempDir()
+	key := base64.StdEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize))
+	cfg := DefaultConfig()
+	cfg.ConfigSigningKey = key
+	cfg.RemoteConfigVersion = 9
+	cfg.HeartbeatInterval = 10
+	if err := cfg.SetProfile("staging", Profile{ServerURL: "https://staging.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := ParseRemoteConfig([]byte(`{"heartbeatInterval":30,"allowedCommands":["bash"],"limits":{"idleTimeout":7}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.StoreRemoteConfig("staging", rc, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveFrom(dir, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	top, sources, err := LoadEffective(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top.HeartbeatInterval != 10 || top.RemoteConfigVersion != 9 || len(top.AllowedCommands) != 0 {
+		t.Fatalf("staging update leaked into the top level: %+v", top)
+	}
+	staging := *top
+	if err := ApplyProfile(&staging, sources, "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if staging.HeartbeatInterval != 30 || staging.RemoteConfigVersion != 3 || staging.IdleTimeout != 7 ||
+		strings.Join(staging.AllowedCommands, ",") != "bash" {
+		t.Errorf("staging settings not applied: %+v", staging)
+	}
+	if staging.ConfigSigningKey != "" || sources["config_signing_key"] != SourceProfile {
+		t.Errorf("staging inherited the top-level signing key")
+	}
+
+	if err := top.StoreRemoteConfig(DefaultProfile, rc, 10); err != nil {
+		t.Fatal(err)
+	}
+	if top.HeartbeatInterval != 30 || top.RemoteConfigVersion != 10 || top.Profiles["staging"].RemoteConfigVersion != 3 {
+		t.Errorf("default update not stored at the top level: %+v", top)
+	}
+}
+
+func TestRemoteConfig_PartialUpdateKeepsOtherKeys(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SessionLimits = SessionLimits{IdleTimeout: 30, TimeoutAction: TimeoutActionPause, TimeoutWarning: 3}
+	cfg.Restart = RestartPolicy{Mode: RestartOnFailure, MaxRetries: 5}
+
+	rc, err := ParseRemoteConfig([]byte(`{"limits":{"maxRuntime":120},"restart":{"backoffSeconds":2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := rc.ApplyTo(cfg)
+	if strings.Join(changed, ",") != "restart,max_runtime" {
+		t.Errorf("changed = %v", changed)
+	}
+	wantLimits := SessionLimits{IdleTimeout: 30, MaxRuntime: 120, TimeoutAction: TimeoutActionPause, TimeoutWarning: 3}
+	if cfg.SessionLimits != wantLimits {
+		t.Errorf("limits = %+v, want %+v", cfg.SessionLimits, wantLimits)
+	}
+	if want := (RestartPolicy{Mode: RestartOnFailure, MaxRetries: 5, BackoffSeconds: 2}); cfg.Restart != want {
+		t.Errorf("restart = %+v, want %+v", cfg.Restart, want)
+	}
+
+	// Stored per profile, successive partial updates accumulate.
+	if err := cfg.SetProfile("staging", Profile{}); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ParseRemoteConfig([]byte(`{"limits":{"idleTimeout":5,"timeoutAction":"pause"}}`))
+	if err := cfg.StoreRemoteConfig("staging", first, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.StoreRemoteConfig("staging", rc, 2); err != nil {
+		t.Fatal(err)
+	}
+	got := cfg.Profiles["staging"].Remote.Limits.limits(SessionLimits{})
+	if want := (SessionLimits{IdleTimeout: 5, MaxRuntime: 120, TimeoutAction: TimeoutActionPause}); got != want {
+		t.Errorf("stored staging limits = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Bounds for numeric settings.
+const (
+	MinHeartbeatInterval = 5
+	MaxHeartbeatInterval = 3600
+	MaxExitTailLines     = 1000
+)
+
+// ValidateLogLevel accepts the levels understood by the agent's logger.
+func ValidateLogLevel(level string) error {
+	switch strings.ToLower(level) {
+	case "", "debug", "info", "warn", "error":
+		return nil
+	}
+	return fmt.Errorf("log_level %q must be debug, info, warn or error", level)
+}
+
+// ValidateHeartbeatInterval checks heartbeat_interval in seconds; 0 means default.
+func ValidateHeartbeatInterval(secs int) error {
+	if secs != 0 && (secs < MinHeartbeatInterval || secs > MaxHeartbeatInterval) {
+		return fmt.Errorf("heartbeat_interval %d must be between %d and %d seconds", secs, MinHeartbeatInterval, MaxHeartbeatInterval)
+	}
+	return nil
+}
+
+// ValidateExitTailLines checks exit_tail_lines; negative disables the tail.
+func ValidateExitTailLines(n int) error {
+	if n > MaxExitTailLines {
+		return fmt.Errorf("exit_tail_lines %d exceeds the maximum of %d", n, MaxExitTailLines)
+	}
+	return nil
+}
+
+// Validate checks a restart policy's mode and timings.
+func (p RestartPolicy) Validate() error {
+	switch p.Mode {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("restart mode %q must be %s, %s or %s", p.Mode, RestartNever, RestartOnFailure, RestartAlways)
+	}
+	if p.MaxRetries < 0 || p.BackoffSeconds < 0 || p.MaxBackoffSeconds < 0 || p.ResetAfterSeconds < 0 {
+		return fmt.Errorf("restart retries and durations must not be negative")
+	}
+	return nil
+}
+
+// Validate checks the timeout action and warning. Negative limits are
+// allowed: in a profile or message they disable the limit.
+func (l SessionLimits) Validate() error {
+	if l.TimeoutWarning < 0 {
+		return fmt.Errorf("timeout_warning must not be negative")
+	}
+	switch strings.ToLower(l.TimeoutAction) {
+	case "", TimeoutActionStop, TimeoutActionPause:
+	default:
+		return fmt.Errorf("timeout_action %q must be %s or %s", l.TimeoutAction, TimeoutActionStop, TimeoutActionPause)
+	}
+	return nil
+}
+
+// Validate checks the settings that have a fixed set of valid values, so a
+// bad edit is reported instead of silently falling back to a default.
+func (c *Config) Validate() error {
+	if err := ValidateLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if err := ValidateHeartbeatInterval(c.HeartbeatInterval); err != nil {
+		return err
+	}
+	if err := ValidateExitTailLines(c.ExitTailLines); err != nil {
+		return err
+	}
+	if err := c.Restart.Validate(); err != nil {
+		return err
+	}
+	if err := c.SessionLimits.Validate(); err != nil {
+		return err
+	}
+	for name, p := range c.SessionProfiles {
+		if p.Restart != nil {
+			if err := p.Restart.Validate(); err != nil {
+				return fmt.Errorf("session_profiles.%s: %w", name, err)
+			}
+		}
+		if err := p.SessionLimits.Validate(); err != nil {
+			return fmt.Errorf("session_profiles.%s: %w", name, err)
+		}
+	}
+	switch strings.ToLower(c.OnUnauthorized) {
+	case "", UnauthorizedKeep, UnauthorizedStop:
+	default:
+		return fmt.Errorf("on_unauthorized %q must be %s or %s", c.OnUnauthorized, UnauthorizedKeep, UnauthorizedStop)
+	}
+	switch c.UpdateChannel {
+	case "", UpdateChannelStable, UpdateChannelBeta, UpdateChannelNightly:
+	default:
+		return fmt.Errorf("update_channel %q must be %s, %s or %s", c.UpdateChannel,
+			UpdateChannelStable, UpdateChannelBeta, UpdateChannelNightly)
+	}
+	if err := c.validateURLs(); err != nil {
+		return err
+	}
+	if err := c.validatePaths(); err != nil {
+		return err
+	}
+	switch c.ClaudeInstalledVia {
+	case "", "gitbash":
+	default:
+		return fmt.Errorf("claude_installed_via %q must be empty or gitbash", c.ClaudeInstalledVia)
+	}
+	if c.APIKeyFD < 0 {
+		return fmt.Errorf("api_key_fd must not be negative")
+	}
+	if c.APIKeyCredential != "" && (c.APIKeyCredential != filepath.Base(c.APIKeyCredential) || c.APIKeyCredential == "..") {
+		return fmt.Errorf("api_key_credential %q must be a credential name, not a path", c.APIKeyCredential)
+	}
+	if err := validateSigningKey(c.ConfigSigningKey); err != nil {
+		return err
+	}
+	return c.validateProfiles()
+}
+
+// validateSigningKey checks a config_signing_key value; empty is allowed.
+func validateSigningKey(key string) error {
+	if key == "" {
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return fmt.Errorf("config_signing_key must be a base64 ed25519 public key")
+	}
+	return nil
+}
+
+// validateURLs checks that server and proxy URLs use a scheme the agent can
+// connect with.
+func (c *Config) validateURLs() error {
+	if c.ServerURL == "" {
+		return fmt.Errorf("server_url must be set")
+	}
+	if err := ValidateServerURL("server_url", c.ServerURL); err != nil {
+		return err
+	}
+	for _, u := range c.ServerURLs {
+		if err := ValidateServerURL("server_urls", u); err != nil {
+			return err
+		}
+	}
+	for name, p := range c.Profiles {
+		if p.ServerURL != "" {
+			if err := ValidateServerURL("profiles."+name+".server_url", p.ServerURL); err != nil {
+				return err
+			}
+		}
+		for _, u := range p.ServerURLs {
+			if err := ValidateServerURL("profiles."+name+".server_urls", u); err != nil {
+				return err
+			}
+		}
+	}
+	if c.ProxyURL != "" && c.ProxyURL != "direct" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("proxy_url must be a URL such as http://host:port, or \"direct\"")
+		}
+		switch u.Scheme {
+		case "http", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("proxy_url scheme %q must be http, socks5 or socks5h", u.Scheme)
+		}
+	}
+	return nil
+}
+
+// ValidateServerURL checks that raw is an http:// or https:// URL with a host.
+func ValidateServerURL(key, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s %q is not a URL such as https://sessionforge.dev", key, raw)
+	}
+	if !strings.HasPrefix(raw, "https://") && !strings.HasPrefix(raw, "http://") {
+		return fmt.Errorf("%s %q must start with https:// or http://", key, raw)
+	}
+	return nil
+}
+
+// validatePaths checks that file settings are absolute paths, because the
+// service does not run in the directory the user edited config.toml from.
+func (c *Config) validatePaths() error {
+	for _, p := range []struct{ key, path string }{
+		{"claude_path", c.ClaudePath},
+		{"log_file", c.LogFile},
+		{"claude_config_dir", c.ClaudeConfigDir},
+		{"tls_ca_file", c.TLSCAFile},
+		{"tls_client_cert", c.TLSClientCert},
+		{"tls_client_key", c.TLSClientKey},
+	} {
+		if p.path != "" && !filepath.IsAbs(p.path) {
+			return fmt.Errorf("%s %q must be an absolute path", p.key, p.path)
+		}
+	}
+	if (c.TLSClientCert == "") != (c.TLSClientKey == "") {
+		return fmt.Errorf("tls_client_cert and tls_client_key must be set together")
+	}
+	return nil
+}
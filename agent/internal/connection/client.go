@@ -4,17 +4,21 @@ package connection
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
 	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sessionforge/agent/internal/config"
 	"github.com/sessionforge/agent/internal/debuglog"
 	"github.com/sessionforge/agent/internal/system"
+	"github.com/sessionforge/agent/internal/transport"
 )
 
 const (
@@ -36,6 +40,10 @@ type registerMsg struct {
 	Version   string  `json:"version"`
 	CpuModel  string  `json:"cpuModel"`
 	RamGb     float64 `json:"ramGb"`
+	// ProtocolVersion and Capabilities let the server decide which message
+	// types it may send; it answers with register_ack.
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
 }
 
 // CloudMessage is the minimal envelope used to route incoming messages.
@@ -59,15 +67,58 @@ type Client struct {
 	// Use this to replay state (e.g. session_started messages) so the server stays in sync.
 	OnConnect func()
 
+	// OnUnauthorized is called when the server rejects the credentials and
+	// the client stops retrying, e.g. to stop local sessions by policy.
+	OnUnauthorized func(err error)
+
 	mu   sync.Mutex
 	conn *websocket.Conn
 
-	sendCh        chan []byte
+	sendCh        chan outgoing
 	stopCh        chan struct{}
 	doneCh        chan struct{}
 	connectedCh   chan struct{} // closed once on first successful connection
 	connectedOnce sync.Once
 
+	tokens *tokenSource
+
+	// endpoints is the ordered server list from config. redialing marks a
+	// connection closed on purpose, to return to a preferred endpoint or to
+	// present rotated credentials.
+	endpoints     *endpointSet
+	failbackEvery time.Duration
+	redialing     atomic.Bool
+
+	// heartbeatEvery overrides heartbeatInterval when non-zero; heartbeatCh
+	// tells RunHeartbeat it changed.
+	heartbeatEvery atomic.Int64
+	heartbeatCh    chan struct{}
+
+	// negotiated holds the capabilities accepted in register_ack, nil until
+	// one arrives; negotiatedCh is closed then. legacyServer is set when
+	// none arrived within negotiationWait. incompatible is set when the
+	// server was refused.
+	protoMu         sync.Mutex
+	negotiated      map[string]bool
+	negotiatedCh    chan struct{}
+	negotiationWait time.Duration
+	legacyServer    bool
+	incompatible    *incompatibleError
+
+	// reauthCh wakes Run out of the unauthorized state.
+	reauthCh chan struct{}
+
+	stateMu   sync.Mutex
+	state     AgentState
+	statePath string
+
+	// outbox persists control events while offline; nil unless EnableOutbox
+	// was called. An added event queues a flush marker on sendCh so it goes
+	// out in order with output; outboxCh wakes the write loop instead when
+	// sendCh is full.
+	outbox   *outbox
+	outboxCh chan struct{}
+
 	debugLog *debuglog.Client
 }
 
@@ -76,20 +127,64 @@ func (c *Client) SetDebugLogger(dl *debuglog.Client) {
 	c.debugLog = dl
 }
 
+// EnableOutbox persists non-output events (session lifecycle, exit info,
+// alerts) in dir so they survive disconnects and daemon restarts. Only the
+// long-running agent should enable it; short-lived CLI clients would race
+// it for the same file.
+func (c *Client) EnableOutbox(dir string) error {
+	o, err := openOutbox(dir, c.logger)
+	if err != nil {
+		return err
+	}
+	c.outbox = o
+	return nil
+}
+
 // NewClient creates a Client. Call Run() to connect.
 func NewClient(cfg *config.Config, version string, handler MessageHandler, logger *slog.Logger) *Client {
+	urls := cfg.Endpoints()
+	if len(urls) == 0 {
+		urls = []string{cfg.ServerURL}
+	}
 	return &Client{
-		cfg:         cfg,
-		version:     version,
-		handler:     handler,
-		logger:      logger,
-		sendCh:      make(chan []byte, 256),
-		stopCh:      make(chan struct{}),
-		doneCh:      make(chan struct{}),
-		connectedCh: make(chan struct{}),
+		cfg:             cfg,
+		version:         version,
+		handler:         handler,
+		logger:          logger,
+		sendCh:          make(chan outgoing, 256),
+		stopCh:          make(chan struct{}),
+		doneCh:          make(chan struct{}),
+		connectedCh:     make(chan struct{}),
+		outboxCh:        make(chan struct{}, 1),
+		reauthCh:        make(chan struct{}, 1),
+		heartbeatCh:     make(chan struct{}, 1),
+		tokens:          newTokenSource(cfg, version, logger),
+		endpoints:       newEndpointSet(urls),
+		failbackEvery:   failbackInterval,
+		negotiationWait: negotiationTimeout,
+		state:           AgentState{State: StateConnecting, Endpoint: urls[0], Since: time.Now(), PID: os.Getpid()},
 	}
 }
 
+// Reauthorize resumes connecting after the client gave up on rejected
+// credentials. Call it once the API key has been replaced; a key from
+// api_key_command or a credential file is fetched again.
+func (c *Client) Reauthorize() {
+	config.ForgetAPIKey()
+	c.tokens.invalidate()
+	select {
+	case c.reauthCh <- struct{}{}:
+	default:
+	}
+}
+
+// AuthToken returns the credential to send as "Authorization: Bearer" to the
+// SessionForge server: a short-lived session token, or the API key for
+// servers without the token exchange.
+func (c *Client) AuthToken(ctx context.Context) (string, error) {
+	return c.tokens.Token(ctx)
+}
+
 // WaitConnected blocks until the first successful WS connection or ctx is cancelled.
 func (c *Client) WaitConnected(ctx context.Context) error {
 	select {
@@ -100,12 +195,27 @@ func (c *Client) WaitConnected(ctx context.Context) error {
 	}
 }
 
+// IsConnected reports whether a WebSocket connection is currently open.
+func (c *Client) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
 // Run connects to the cloud and maintains the connection until ctx is cancelled.
-// It implements exponential backoff: 1s, 2s, 4s … capped at 60s.
+// Failed attempts back off exponentially (1s, 2s, 4s … capped at 60s) with
+// jitter, or as long as the server's Retry-After asks when it is throttling.
+// With several endpoints configured, repeated failures move on to the next
+// one and a connection to a fallback returns to the preferred endpoint once
+// it is healthy.
+// If the server rejects the credentials, Run stops retrying and waits in
+// StateUnauthorized until Reauthorize is called.
 func (c *Client) Run(ctx context.Context) {
 	defer close(c.doneCh)
+	defer c.setState(StateStopped, "")
 
 	attempt := 0
+	var delay time.Duration
 	for {
 		select {
 		case <-ctx.Done():
@@ -114,7 +224,6 @@ func (c *Client) Run(ctx context.Context) {
 		default:
 		}
 
-		delay := backoffDelay(attempt)
 		if attempt > 0 {
 			c.logger.Info("connection: reconnecting", "attempt", attempt, "delay", delay)
 			if c.debugLog != nil {
@@ -130,49 +239,114 @@ func (c *Client) Run(ctx context.Context) {
 			}
 		}
 
-		if err := c.connect(ctx); err != nil {
-			c.logger.Warn("connection: failed", "err", err, "attempt", attempt)
+		err := c.connect(ctx)
+		if err == nil || errors.Is(err, errRedial) {
+			// Successful connection; reset backoff.
+			attempt = 0
+			continue
+		}
+
+		var incompatible *incompatibleError
+		if errors.As(err, &incompatible) {
+			c.logger.Error("connection: refusing incompatible server", "err", err, "retryIn", incompatibleRetry)
 			if c.debugLog != nil {
-				c.debugLog.Warn("ws_connection", "Connection lost", map[string]any{
-					"attempt": attempt,
-					"error":   err.Error(),
-				})
+				c.debugLog.Error("ws_connection", "Incompatible server", map[string]any{"error": err.Error()})
+			}
+			c.setState(StateIncompatible, err.Error())
+			select {
+			case <-time.After(incompatibleRetry):
+				attempt = 0
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if isUnauthorized(err) {
+			c.unauthorized(err)
+			select {
+			case <-c.reauthCh:
+				c.logger.Info("connection: credentials changed, reconnecting")
+				c.setState(StateConnecting, "")
+				attempt = 0
+				continue
+			case <-ctx.Done():
+				return
 			}
-			attempt++
-			continue
 		}
 
-		// Successful connection; reset backoff.
-		attempt = 0
+		c.logger.Warn("connection: failed", "err", err, "attempt", attempt)
+		if c.debugLog != nil {
+			c.debugLog.Warn("ws_connection", "Connection lost", map[string]any{
+				"attempt": attempt,
+				"error":   err.Error(),
+			})
+		}
+		c.setState(StateReconnecting, err.Error())
+		attempt++
+		delay = withJitter(backoffDelay(attempt))
+		if c.endpoints.failed() {
+			// A throttling server's Retry-After does not apply to the next one.
+			c.failover(ctx)
+		} else if d := retryAfter(err); d > 0 {
+			c.logger.Info("connection: server is throttling, honouring Retry-After", "delay", d)
+			delay = d
+		}
+	}
+}
+
+// unauthorized enters StateUnauthorized. Retrying a revoked or wrong key
+// only adds load and log noise, so the client waits for new credentials.
+func (c *Client) unauthorized(err error) {
+	c.logger.Error("connection: server rejected the API key; not retrying until credentials change",
+		"err", err, "hint", "run: sessionforge auth login --key <key>")
+	c.setState(StateUnauthorized, err.Error())
+	if c.OnUnauthorized != nil {
+		c.OnUnauthorized(err)
 	}
 }
 
 // connect opens one WebSocket session, handles messages, and returns when disconnected.
 func (c *Client) connect(ctx context.Context) error {
-	wsURL := c.cfg.WebSocketURL()
-	c.logger.Info("connection: connecting", "url", wsURL)
+	c.resetNegotiation()
+	endpoint, preference := c.endpoints.current()
+	wsURL := config.WebSocketURLFor(endpoint)
+	c.logger.Info("connection: connecting", "url", transport.Redact(wsURL))
 
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 15 * time.Second,
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	dialer, err := transport.WebSocketDialer(c.cfg)
+	if err != nil {
+		return fmt.Errorf("websocket dialer: %w", err)
 	}
 	conn, resp, err := dialer.DialContext(ctx, wsURL, http.Header{
-		"User-Agent": []string{"SessionForge-Agent/" + c.version},
+		"User-Agent":    []string{"SessionForge-Agent/" + c.version},
+		"Authorization": []string{"Bearer " + token},
 	})
 	if err != nil {
 		if resp != nil {
-			return fmt.Errorf("websocket dial (HTTP %d): %w", resp.StatusCode, err)
+			if resp.StatusCode == http.StatusUnauthorized {
+				// The token may have been revoked server-side; exchange afresh next time.
+				c.tokens.invalidate()
+			}
+			return fmt.Errorf("websocket dial: %w", newDialError(resp, err))
 		}
-		return fmt.Errorf("websocket dial: %w", err)
+		return fmt.Errorf("websocket dial: %w", transport.DiagnoseTLS(err))
 	}
 
 	c.mu.Lock()
 	c.conn = conn
 	c.mu.Unlock()
 
-	c.logger.Info("connection: connected")
+	c.logger.Info("connection: connected", "endpoint", endpoint)
+	c.endpoints.succeeded()
+	c.setState(StateConnected, "")
 	if c.debugLog != nil {
 		c.debugLog.Info("ws_connection", "Connected to server", map[string]any{
-			"url": wsURL,
+			"url":      transport.Redact(wsURL),
+			"endpoint": endpoint,
 		})
 	}
 
@@ -190,6 +364,14 @@ func (c *Client) connect(ctx context.Context) error {
 		go c.OnConnect()
 	}
 
+	// Keep the session token fresh for as long as this connection lives.
+	connCtx, cancelConn := context.WithCancel(ctx)
+	defer cancelConn()
+	go c.refreshToken(connCtx)
+	if preference > 0 {
+		go c.failback(connCtx, conn)
+	}
+
 	// Goroutine for writes.
 	writeErrCh := make(chan error, 1)
 	go c.writeLoop(ctx, conn, writeErrCh)
@@ -212,9 +394,48 @@ func (c *Client) connect(ctx context.Context) error {
 	c.conn = nil
 	c.mu.Unlock()
 
+	if c.redialing.CompareAndSwap(true, false) {
+		return errRedial
+	}
+	if err := c.takeIncompatible(); err != nil {
+		return err
+	}
 	return readErr
 }
 
+// refreshToken replaces the session token shortly before it expires and
+// hands the new one to the server, so the connection and the next reconnect
+// never present an expired credential.
+func (c *Client) refreshToken(ctx context.Context) {
+	for {
+		at := c.tokens.nextRefresh()
+		if at.IsZero() {
+			return // API key in use; nothing to refresh.
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(at)):
+		}
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			c.logger.Warn("connection: token refresh failed", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(30 * time.Second):
+			}
+			continue
+		}
+		if !c.Supports(capAuthRefresh) {
+			continue // the next reconnect picks up the fresh token
+		}
+		if err := c.SendJSON(map[string]string{"type": "auth_refresh", "token": token}); err != nil {
+			c.logger.Warn("connection: failed to send auth_refresh", "err", err)
+		}
+	}
+}
+
 // readLoop reads messages from the server and dispatches them to the handler.
 func (c *Client) readLoop(ctx context.Context, conn *websocket.Conn) error {
 	conn.SetReadDeadline(time.Now().Add(pingInterval * 2))
@@ -255,16 +476,46 @@ func (c *Client) readLoop(ctx context.Context, conn *websocket.Conn) error {
 	}
 }
 
+// outgoing is one entry of the send queue: a message, or a marker asking
+// the write loop to deliver outbox events up to and including flushTo.
+// Sharing one queue keeps lifecycle events and output in the order they
+// were sent, e.g. session_started before the first output.
+type outgoing struct {
+	data    []byte
+	flushTo uint64
+}
+
 // writeLoop drains sendCh and sends pings on a ticker.
 func (c *Client) writeLoop(ctx context.Context, conn *websocket.Conn, errCh chan<- error) {
 	ticker := time.NewTicker(pingInterval)
 	defer ticker.Stop()
 
+	// Messages whose encoding depends on what the server understands wait
+	// for register_ack, and everything queued behind them with them; the
+	// rest, terminal output included, goes out straight away.
+	negotiationDeadline := time.Now().Add(c.negotiationWait)
 	send := func(msgType int, data []byte) error {
+		if msgType == websocket.TextMessage {
+			if dependsOnNegotiation(data) {
+				c.awaitNegotiation(ctx, negotiationDeadline)
+			}
+			adapted, ok := c.adaptOutgoing(data)
+			if !ok {
+				c.logger.Debug("writeLoop: dropping message the server did not negotiate", "type", messageType(data))
+				return nil
+			}
+			data = adapted
+		}
 		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 		return conn.WriteMessage(msgType, data)
 	}
 
+	// Deliver events queued while offline before anything else.
+	if err := c.flushOutbox(send, 0); err != nil {
+		errCh <- err
+		return
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -274,14 +525,27 @@ func (c *Client) writeLoop(ctx context.Context, conn *websocket.Conn, errCh chan
 			errCh <- nil
 			return
 
-		case data := <-c.sendCh:
-			c.logger.Debug("writeLoop: sending", "bytes", len(data))
-			if err := send(websocket.TextMessage, data); err != nil {
+		case msg := <-c.sendCh:
+			if msg.flushTo > 0 {
+				if err := c.flushOutbox(send, msg.flushTo); err != nil {
+					errCh <- err
+					return
+				}
+				continue
+			}
+			c.logger.Debug("writeLoop: sending", "bytes", len(msg.data))
+			if err := send(websocket.TextMessage, msg.data); err != nil {
 				c.logger.Warn("writeLoop: send error", "err", err)
 				errCh <- fmt.Errorf("write: %w", err)
 				return
 			}
-			c.logger.Debug("writeLoop: sent ok", "bytes", len(data))
+			c.logger.Debug("writeLoop: sent ok", "bytes", len(msg.data))
+
+		case <-c.outboxCh:
+			if err := c.flushOutbox(send, 0); err != nil {
+				errCh <- err
+				return
+			}
 
 		case <-ticker.C:
 			if err := send(websocket.PingMessage, nil); err != nil {
@@ -292,15 +556,76 @@ func (c *Client) writeLoop(ctx context.Context, conn *websocket.Conn, errCh chan
 	}
 }
 
+// flushOutbox writes the persisted events up to and including seq (every
+// event if seq is 0) in order and removes the ones that were written. A
+// write error leaves the rest for the next connection.
+func (c *Client) flushOutbox(send func(int, []byte) error, seq uint64) error {
+	if c.outbox == nil {
+		return nil
+	}
+	pending := c.outbox.pending()
+	if len(pending) == 0 {
+		return nil
+	}
+	var acked uint64
+	var sendErr error
+	for _, e := range pending {
+		if seq > 0 && e.seq > seq {
+			break
+		}
+		if sendErr = send(websocket.TextMessage, e.data); sendErr != nil {
+			break
+		}
+		acked = e.seq
+	}
+	if acked > 0 {
+		if err := c.outbox.ack(acked); err != nil {
+			c.logger.Warn("outbox: failed to persist delivery", "err", err)
+		}
+	}
+	if sendErr != nil {
+		return fmt.Errorf("write: %w", sendErr)
+	}
+	c.logger.Debug("outbox: flushed", "through", acked)
+	return nil
+}
+
 // SendJSON serialises v to JSON and queues it for delivery.
-// Non-blocking: if the send buffer is full, the message is dropped with a warning.
+// With an outbox enabled, control events are persisted and delivered in
+// order once connected. Other messages are non-blocking: if the send buffer
+// is full, the message is dropped with a warning.
 func (c *Client) SendJSON(v any) error {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
+
+	msgType := messageType(data)
+	if c.outbox != nil && !transientTypes[msgType] {
+		seq, err := c.outbox.add(data)
+		if err != nil {
+			return fmt.Errorf("outbox: %w", err)
+		}
+		// The marker sits behind output already queued, so the event is
+		// delivered after it and before anything sent later.
+		select {
+		case c.sendCh <- outgoing{flushTo: seq}:
+		default:
+			select {
+			case c.outboxCh <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}
+	// A heartbeat is stale by the time a connection comes back; don't let
+	// them fill the send buffer while offline.
+	if msgType == "heartbeat" && !c.IsConnected() {
+		return nil
+	}
+
 	select {
-	case c.sendCh <- data:
+	case c.sendCh <- outgoing{data: data}:
 		return nil
 	default:
 		c.logger.Warn("connection: send buffer full, dropping message")
@@ -319,6 +644,9 @@ func (c *Client) sendRegister() error {
 		Version:   c.version,
 		CpuModel:  system.GetCPUModel(),
 		RamGb:     system.GetRAMGB(),
+
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    agentCapabilities,
 	}
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -0,0 +1,257 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/system"
+	"github.com/sessionforge/agent/internal/transport"
+)
+
+const (
+	// deviceCodePath starts a device authorization: the server returns a
+	// short code for the user to approve in the dashboard.
+	deviceCodePath = "/api/agent/device/code"
+	// deviceTokenPath is polled until the code is approved, denied or expired.
+	deviceTokenPath = "/api/agent/device/token"
+	// whoAmIPath describes the account behind a credential.
+	whoAmIPath = "/api/agent/whoami"
+
+	// Defaults in seconds for a server that omits interval or expiresIn.
+	defaultDevicePollSeconds = 5
+	defaultDeviceCodeSeconds = 15 * 60
+	authRequestTimeout       = 15 * time.Second
+)
+
+// devicePollUnit is the unit of interval and expiresIn; tests shorten it.
+var devicePollUnit = time.Second
+
+var (
+	// ErrDeviceDenied means the user rejected the login in the dashboard.
+	ErrDeviceDenied = errors.New("login was denied in the dashboard")
+	// ErrDeviceExpired means the code was not approved in time.
+	ErrDeviceExpired = errors.New("device code expired before it was approved")
+)
+
+// DeviceCode is the server's answer to a device authorization request.
+type DeviceCode struct {
+	DeviceCode      string `json:"deviceCode"`
+	UserCode        string `json:"userCode"`
+	VerificationURI string `json:"verificationUri"`
+	// VerificationURIComplete embeds the user code, so opening it skips
+	// typing the code.
+	VerificationURIComplete string `json:"verificationUriComplete,omitempty"`
+	ExpiresIn               int    `json:"expiresIn"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceCredential is issued once the user approves the device. The server
+// may assign the machine ID and name; empty fields keep the local values.
+type DeviceCredential struct {
+	APIKey      string `json:"apiKey"`
+	MachineID   string `json:"machineId,omitempty"`
+	MachineName string `json:"machineName,omitempty"`
+}
+
+// Identity describes the account and machine a credential belongs to.
+type Identity struct {
+	MachineID    string `json:"machineId"`
+	MachineName  string `json:"machineName,omitempty"`
+	User         string `json:"user,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	KeyName      string `json:"keyName,omitempty"`
+}
+
+// deviceError is the body of a non-200 device token response, in the style
+// of RFC 8628.
+type deviceError struct {
+	Error string `json:"error"`
+}
+
+// RequestDeviceCode asks cfg.ServerURL for a device code for this machine.
+func RequestDeviceCode(ctx context.Context, cfg *config.Config, version string) (*DeviceCode, error) {
+	body := map[string]string{
+		"machineId":   cfg.MachineID,
+		"machineName": cfg.MachineName,
+		"hostname":    system.GetHostname(),
+		"os":          system.GetOS(),
+		"version":     version,
+	}
+	resp, err := postJSON(ctx, cfg, version, deviceCodePath, body)
+	if err != nil {
+		return nil, fmt.Errorf("device login: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errors.New("device login: this server does not support device login; use --key")
+	default:
+		return nil, fmt.Errorf("device login: %w", newDialError(resp, errors.New(http.StatusText(resp.StatusCode))))
+	}
+
+	var dc DeviceCode
+	if err := json.NewDecoder(resp.Body).Decode(&dc); err != nil {
+		return nil, fmt.Errorf("device login: decode response: %w", err)
+	}
+	if dc.DeviceCode == "" || dc.UserCode == "" || dc.VerificationURI == "" {
+		return nil, errors.New("device login: incomplete response from server")
+	}
+	return &dc, nil
+}
+
+// PollDeviceCredential polls until dc is approved and returns the issued
+// credential, or fails when it is denied, expires or ctx is cancelled.
+func PollDeviceCredential(ctx context.Context, cfg *config.Config, version string, dc *DeviceCode) (*DeviceCredential, error) {
+	pollSecs, lifeSecs := dc.Interval, dc.ExpiresIn
+	if pollSecs <= 0 {
+		pollSecs = defaultDevicePollSeconds
+	}
+	if lifeSecs <= 0 {
+		lifeSecs = defaultDeviceCodeSeconds
+	}
+	interval := time.Duration(pollSecs) * devicePollUnit
+	deadline := time.Now().Add(time.Duration(lifeSecs) * devicePollUnit)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrDeviceExpired
+		}
+
+		cred, code, err := pollDeviceOnce(ctx, cfg, version, dc.DeviceCode)
+		if err != nil {
+			return nil, err
+		}
+		switch code {
+		case "":
+			return cred, nil
+		case "authorization_pending":
+		case "slow_down":
+			// RFC 8628: back off by five seconds on every slow_down.
+			interval += defaultDevicePollSeconds * devicePollUnit
+		case "access_denied":
+			return nil, ErrDeviceDenied
+		case "expired_token":
+			return nil, ErrDeviceExpired
+		default:
+			return nil, fmt.Errorf("device login: server error %q", code)
+		}
+	}
+}
+
+// pollDeviceOnce asks whether the device code was approved. It returns the
+// credential, or the RFC 8628 error code while the login is not complete.
+func pollDeviceOnce(ctx context.Context, cfg *config.Config, version, deviceCode string) (*DeviceCredential, string, error) {
+	resp, err := postJSON(ctx, cfg, version, deviceTokenPath, map[string]string{"deviceCode": deviceCode})
+	if err != nil {
+		return nil, "", fmt.Errorf("device login: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var de deviceError
+		if json.NewDecoder(resp.Body).Decode(&de) != nil || de.Error == "" {
+			return nil, "", fmt.Errorf("device login: %w", newDialError(resp, errors.New(http.StatusText(resp.StatusCode))))
+		}
+		return nil, de.Error, nil
+	}
+	var cred DeviceCredential
+	if err := json.NewDecoder(resp.Body).Decode(&cred); err != nil {
+		return nil, "", fmt.Errorf("device login: decode response: %w", err)
+	}
+	if cred.APIKey == "" {
+		return nil, "", errors.New("device login: server approved the login but issued no credential")
+	}
+	return &cred, "", nil
+}
+
+// WhoAmI asks the server which account and machine cfg's credential
+// belongs to, verifying the credential on the way.
+func WhoAmI(ctx context.Context, cfg *config.Config, version string) (*Identity, error) {
+	resp, err := authenticatedRequest(ctx, cfg, version, http.MethodGet, whoAmIPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("whoami: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("whoami: %w", newDialError(resp, errors.New(http.StatusText(resp.StatusCode))))
+	}
+	var id Identity
+	if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+		return nil, fmt.Errorf("whoami: decode response: %w", err)
+	}
+	return &id, nil
+}
+
+// IsUnauthorized reports whether err is the server rejecting the credential.
+func IsUnauthorized(err error) bool {
+	return isUnauthorized(err)
+}
+
+// authenticatedRequest sends a request to cfg.ServerURL + path with cfg's
+// credential, and a JSON body unless body is nil.
+func authenticatedRequest(ctx context.Context, cfg *config.Config, version, method, path string, body any) (*http.Response, error) {
+	ts := newTokenSource(cfg, version, slog.New(slog.DiscardHandler))
+	token, err := ts.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := transport.HTTPClient(cfg, authRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(cfg.ServerURL, "/")+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "SessionForge-Agent/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, transport.DiagnoseTLS(err)
+	}
+	return resp, nil
+}
+
+// postJSON sends an unauthenticated JSON request to cfg.ServerURL + path.
+func postJSON(ctx context.Context, cfg *config.Config, version, path string, body any) (*http.Response, error) {
+	client, err := transport.HTTPClient(cfg, authRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(cfg.ServerURL, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SessionForge-Agent/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, transport.DiagnoseTLS(err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDeviceServer approves the device code after pending polls and
+// answers whoami for the key it issued.
+func fakeDeviceServer(t *testing.T, pending int, outcome string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var polls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case deviceCodePath:
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["machineId"] != "machine-1" {
+				t.Errorf("device code request machineId = %q", body["machineId"])
+			}
+			_ = json.NewEncoder(w).Encode(DeviceCode{
+				DeviceCode: "dev-123", UserCode: "WDJB-MJHT",
+				VerificationURI: "https://example.com/device", ExpiresIn: 2000, Interval: 1,
+			})
+		case deviceTokenPath:
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["deviceCode"] != "dev-123" {
+				t.Errorf("polled with device code %q", body["deviceCode"])
+			}
+			if int(polls.Add(1)) <= pending {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(deviceError{Error: "authorization_pending"})
+				return
+			}
+			if outcome != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(deviceError{Error: outcome})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(DeviceCredential{APIKey: "sf_live_issued", MachineName: "build-box"})
+		case tokenPath:
+			http.NotFound(w, r)
+		case whoAmIPath:
+			if r.Header.Get("Authorization") != "Bearer sf_live_issued" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(Identity{MachineID: "machine-1", User: "ada@example.com", Organization: "Acme"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &polls
+}
+
+func shortDevicePolls(t *testing.T) {
+	old := devicePollUnit
+	devicePollUnit = time.Millisecond
+	t.Cleanup(func() { devicePollUnit = old })
+}
+
+func TestDeviceLogin_ApprovedThenWhoAmI(t *testing.T) {
+	shortDevicePolls(t)
+	srv, polls := fakeDeviceServer(t, 2, "")
+	cfg := testConfig(srv.URL)
+	cfg.APIKey = ""
+	ctx := context.Background()
+
+	dc, err := RequestDeviceCode(ctx, cfg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.UserCode != "WDJB-MJHT" {
+		t.Fatalf("user code = %q", dc.UserCode)
+	}
+	cred, err := PollDeviceCredential(ctx, cfg, "test", dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.APIKey != "sf_live_issued" || cred.MachineName != "build-box" {
+		t.Fatalf("credential = %+v", cred)
+	}
+	if n := polls.Load(); n != 3 {
+		t.Errorf("polled %d times, want 3", n)
+	}
+
+	cfg.APIKey = cred.APIKey
+	id, err := WhoAmI(ctx, cfg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.User != "ada@example.com" || id.MachineID != "machine-1" {
+		t.Errorf("identity = %+v", id)
+	}
+
+	cfg.APIKey = "sf_live_revoked"
+	if _, err := WhoAmI(ctx, cfg, "test"); !IsUnauthorized(err) {
+		t.Errorf("whoami with a rejected key: err = %v, want unauthorized", err)
+	}
+}
+
+func TestDeviceLogin_DeniedAndExpired(t *testing.T) {
+	shortDevicePolls(t)
+	for outcome, want := range map[string]error{
+		"access_denied": ErrDeviceDenied,
+		"expired_token": ErrDeviceExpired,
+	} {
+		srv, _ := fakeDeviceServer(t, 1, outcome)
+		cfg := testConfig(srv.URL)
+		dc, err := RequestDeviceCode(context.Background(), cfg, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := PollDeviceCredential(context.Background(), cfg, "test", dc); !errors.Is(err, want) {
+			t.Errorf("%s: err = %v, want %v", outcome, err, want)
+		}
+	}
+
+	// A code the user never approves runs out locally too.
+	srv, _ := fakeDeviceServer(t, 1<<30, "")
+	cfg := testConfig(srv.URL)
+	dc := &DeviceCode{DeviceCode: "dev-123", Interval: 1, ExpiresIn: 20}
+	if _, err := PollDeviceCredential(context.Background(), cfg, "test", dc); !errors.Is(err, ErrDeviceExpired) {
+		t.Errorf("unapproved code: err = %v, want expiry", err)
+	}
+}
+
+func TestRequestDeviceCode_Unsupported(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := RequestDeviceCode(context.Background(), testConfig(srv.URL), "test"); err == nil {
+		t.Fatal("server without device login accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter caps a server-supplied Retry-After so a bogus value cannot
+// park the agent for days.
+const maxRetryAfter = time.Hour
+
+// dialError is a connection attempt rejected by the server with an HTTP
+// status, as opposed to a network failure.
+type dialError struct {
+	status     int
+	retryAfter time.Duration
+	err        error
+}
+
+func newDialError(resp *http.Response, err error) *dialError {
+	return &dialError{
+		status:     resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		err:        err,
+	}
+}
+
+func (e *dialError) Error() string {
+	return fmt.Sprintf("HTTP %d: %v", e.status, e.err)
+}
+
+func (e *dialError) Unwrap() error { return e.err }
+
+// unauthorized reports whether retrying with the same credentials is futile.
+// Only 401 counts: a 403 is as often a WAF, CDN or proxy block as a revoked
+// key, so it is retried with backoff like any other server error.
+func (e *dialError) unauthorized() bool {
+	return e.status == http.StatusUnauthorized
+}
+
+// throttled reports whether the server asked the agent to back off.
+func (e *dialError) throttled() bool {
+	return e.status == http.StatusTooManyRequests || e.status == http.StatusServiceUnavailable
+}
+
+// isUnauthorized reports whether err is a credential rejection.
+func isUnauthorized(err error) bool {
+	var de *dialError
+	return errors.As(err, &de) && de.unauthorized()
+}
+
+// retryAfter returns the server-requested delay for a throttled attempt, or
+// zero if err is not throttling or carries no Retry-After.
+func retryAfter(err error) time.Duration {
+	var de *dialError
+	if errors.As(err, &de) && de.throttled() {
+		return de.retryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header: delay
+// seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
+
+// withJitter spreads d uniformly over [d/2, d) so agents disconnected by the
+// same server restart do not reconnect in lockstep.
+func withJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + rand.N(d-half)
+}
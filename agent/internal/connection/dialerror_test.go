@@ -0,0 +1,125 @@
+package connection
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"":        0,
+		"30":      30 * time.Second,
+		"-5":      0,
+		"garbage": 0,
+		"999999":  maxRetryAfter,
+		now.Add(90 * time.Second).Format(http.TimeFormat): 90 * time.Second,
+		now.Add(-time.Minute).Format(http.TimeFormat):     0,
+	}
+	for in, want := range cases {
+		if got := parseRetryAfter(in, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestWithJitter_StaysInRange(t *testing.T) {
+	d := 8 * time.Second
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		j := withJitter(d)
+		if j < d/2 || j >= d {
+			t.Fatalf("withJitter(%s) = %s, want in [%s, %s)", d, j, d/2, d)
+		}
+		seen[j] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("withJitter returned the same delay every time")
+	}
+}
+
+func TestClassifyDialError(t *testing.T) {
+	resp := func(status int, retry string) *http.Response {
+		h := http.Header{}
+		if retry != "" {
+			h.Set("Retry-After", retry)
+		}
+		return &http.Response{StatusCode: status, Header: h}
+	}
+	if !isUnauthorized(newDialError(resp(http.StatusUnauthorized, ""), nil)) {
+		t.Error("401 should be unauthorized")
+	}
+	if isUnauthorized(newDialError(resp(http.StatusForbidden, ""), nil)) {
+		t.Error("403 should be retried, not treated as a rejected key")
+	}
+	if got := retryAfter(newDialError(resp(http.StatusTooManyRequests, "7"), nil)); got != 7*time.Second {
+		t.Errorf("429 Retry-After = %s, want 7s", got)
+	}
+	if got := retryAfter(newDialError(resp(http.StatusServiceUnavailable, "3"), nil)); got != 3*time.Second {
+		t.Errorf("503 Retry-After = %s, want 3s", got)
+	}
+	if got := retryAfter(newDialError(resp(http.StatusBadGateway, "3"), nil)); got != 0 {
+		t.Errorf("502 should not honour Retry-After, got %s", got)
+	}
+}
+
+func TestRun_StopsRetryingWhenUnauthorized(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c := NewClient(testConfig(srv.URL), "test", func(CloudMessage) {}, testLogger())
+	if err := c.EnableStateFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	notified := make(chan error, 1)
+	c.OnUnauthorized = func(err error) { notified <- err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnUnauthorized was not called")
+	}
+	if state, _ := c.State(); state != StateUnauthorized {
+		t.Fatalf("state = %q, want %q", state, StateUnauthorized)
+	}
+	st, err := ReadState(dir)
+	if err != nil || st == nil || st.State != StateUnauthorized || !st.Running() {
+		t.Fatalf("state file = %+v, %v; want a running unauthorized agent", st, err)
+	}
+
+	// No further attempts until the credentials change.
+	before := attempts.Load()
+	time.Sleep(1500 * time.Millisecond)
+	if attempts.Load() != before {
+		t.Fatalf("client kept retrying: %d attempts after giving up", attempts.Load()-before)
+	}
+
+	c.Reauthorize()
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reauthorize did not trigger a new attempt")
+	}
+	if attempts.Load() == before {
+		t.Fatal("Reauthorize did not reconnect")
+	}
+
+	cancel()
+	c.Wait()
+	if st, _ := ReadState(dir); st == nil || st.State != StateStopped {
+		t.Fatalf("state after shutdown = %+v, want %q", st, StateStopped)
+	}
+}
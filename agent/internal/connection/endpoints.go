@@ -0,0 +1,162 @@
+package connection
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// failoverThreshold is how many consecutive failures on the active
+	// endpoint move the client to the next one.
+	failoverThreshold = 3
+	// failbackInterval is how often a more preferred endpoint is probed
+	// while connected to a fallback.
+	failbackInterval = 5 * time.Minute
+	healthTimeout    = 5 * time.Second
+)
+
+// endpointSet tracks which of the configured server URLs is in use.
+// urls[0] is the most preferred.
+type endpointSet struct {
+	mu       sync.Mutex
+	urls     []string
+	active   int
+	failures int
+}
+
+func newEndpointSet(urls []string) *endpointSet {
+	return &endpointSet{urls: urls}
+}
+
+// current returns the active URL and its index.
+func (e *endpointSet) current() (string, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.urls[e.active], e.active
+}
+
+// all returns a copy of the configured URLs.
+func (e *endpointSet) all() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.urls...)
+}
+
+func (e *endpointSet) succeeded() {
+	e.mu.Lock()
+	e.failures = 0
+	e.mu.Unlock()
+}
+
+// failed records a failure on the active endpoint and reports whether it
+// has failed often enough to move on.
+func (e *endpointSet) failed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures++
+	return len(e.urls) > 1 && e.failures >= failoverThreshold
+}
+
+// use makes urls[i] active and reports whether that changed anything.
+func (e *endpointSet) use(i int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = 0
+	if e.active == i {
+		return false
+	}
+	e.active = i
+	return true
+}
+
+// Endpoint returns the server URL the client is currently using.
+func (c *Client) Endpoint() string {
+	url, _ := c.endpoints.current()
+	return url
+}
+
+// failover moves to the next endpoint that passes a health check, trying
+// them in order after the active one. If none answers it still moves on,
+// since a broken health route should not pin the agent to a dead endpoint.
+func (c *Client) failover(ctx context.Context) {
+	urls := c.endpoints.all()
+	_, cur := c.endpoints.current()
+	next := (cur + 1) % len(urls)
+	for k := 1; k < len(urls); k++ {
+		i := (cur + k) % len(urls)
+		if c.healthy(ctx, urls[i]) {
+			next = i
+			break
+		}
+	}
+	c.switchEndpoint(next, "failover")
+}
+
+// failback periodically probes endpoints preferred over the active one while
+// connected, and drops the connection once one is healthy so Run reconnects
+// to it.
+func (c *Client) failback(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(c.failbackEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		urls := c.endpoints.all()
+		_, cur := c.endpoints.current()
+		for i := 0; i < cur; i++ {
+			if !c.healthy(ctx, urls[i]) {
+				continue
+			}
+			c.switchEndpoint(i, "failback")
+			c.redial(conn)
+			return
+		}
+	}
+}
+
+// switchEndpoint makes urls[i] active. Session tokens are issued per server,
+// so the cached one is dropped.
+func (c *Client) switchEndpoint(i int, reason string) {
+	from := c.Endpoint()
+	if !c.endpoints.use(i) {
+		return
+	}
+	to := c.Endpoint()
+	c.tokens.useServer(to)
+	c.logger.Warn("connection: switching endpoint", "reason", reason, "from", from, "to", to)
+	if c.debugLog != nil {
+		c.debugLog.Warn("ws_connection", "Switching endpoint", map[string]any{
+			"reason": reason,
+			"from":   from,
+			"to":     to,
+		})
+	}
+}
+
+// healthy reports whether base answers its health check. Probes share the
+// token exchange's HTTP client, and so its connection pool.
+func (c *Client) healthy(ctx context.Context, base string) bool {
+	client, err := c.tokens.client()
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/api/health", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent
+}
@@ -0,0 +1,95 @@
+package connection
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeRegion is a server that can be taken down and brought back. While up
+// it accepts WebSocket connections and holds them open.
+func fakeRegion(t *testing.T, up *atomic.Bool, connected chan<- string, name string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/health":
+			w.WriteHeader(http.StatusOK)
+		case "/api/ws/agent":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			connected <- name
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		default:
+			http.NotFound(w, r) // no token exchange; use the API key
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRun_FailsOverAndBack(t *testing.T) {
+	var primaryUp, fallbackUp atomic.Bool
+	fallbackUp.Store(true)
+	connected := make(chan string, 8)
+	primary := fakeRegion(t, &primaryUp, connected, "primary")
+	fallback := fakeRegion(t, &fallbackUp, connected, "fallback")
+
+	cfg := testConfig(primary.URL)
+	cfg.ServerURLs = []string{fallback.URL}
+	c := NewClient(cfg, "test", func(CloudMessage) {}, testLogger())
+	c.failbackEvery = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	wait := func(want string) {
+		t.Helper()
+		select {
+		case got := <-connected:
+			if got != want {
+				t.Fatalf("connected to %s, want %s", got, want)
+			}
+		case <-time.After(15 * time.Second):
+			t.Fatalf("never connected to %s", want)
+		}
+	}
+
+	wait("fallback")
+	if c.Endpoint() != fallback.URL {
+		t.Fatalf("Endpoint() = %s, want the fallback", c.Endpoint())
+	}
+
+	primaryUp.Store(true)
+	wait("primary")
+	if c.Endpoint() != primary.URL {
+		t.Fatalf("Endpoint() = %s, want the primary after failback", c.Endpoint())
+	}
+
+	cancel()
+	c.Wait()
+}
+
+func TestEndpoints_OrderAndDedupe(t *testing.T) {
+	cfg := testConfig("https://eu.example.com/")
+	cfg.ServerURLs = []string{"https://us.example.com", "https://eu.example.com", " "}
+	got := cfg.Endpoints()
+	if len(got) != 2 || got[0] != "https://eu.example.com" || got[1] != "https://us.example.com" {
+		t.Fatalf("Endpoints() = %v", got)
+	}
+}
@@ -2,20 +2,42 @@ package connection
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
 )
 
 // SessionManager is the interface the handler uses to control sessions.
 // Implemented by session.Manager.
 type SessionManager interface {
-	Start(requestID, sessionID, command, workdir string, env map[string]string) (string, error)
+	Start(requestID, sessionID, command, workdir string, env map[string]string, opts config.SessionOptions) (string, error)
 	Stop(sessionID string, force bool) error
 	Pause(sessionID string) error
 	Resume(sessionID string) error
+	Signal(sessionID, sig, target string) error
+	Reconcile(remote map[string]string, terminate []string)
 	WriteInput(sessionID, data string) error
 	Resize(sessionID string, cols, rows uint16) error
 }
 
+// ConfigApplier verifies and applies configuration pushed by the cloud.
+// Implemented by the daemon, which owns the live config.
+type ConfigApplier interface {
+	// ApplyRemoteConfig returns the config.toml names of the settings that
+	// changed, or an error if the update was refused.
+	ApplyRemoteConfig(version int64, payload []byte, signature string) ([]string, error)
+}
+
+// CredentialRotator stores and switches to an API key issued by the cloud.
+// Implemented by the daemon, which knows where the key is kept.
+type CredentialRotator interface {
+	// RotateCredentials returns an error if the key cannot be stored, in
+	// which case the agent keeps the old one.
+	RotateCredentials(apiKey string, grace time.Duration) error
+}
+
 // --- Incoming message structs (CloudToAgentMessage) ---
 
 type startSessionMsg struct {
@@ -25,6 +47,12 @@ type startSessionMsg struct {
 	Command   string            `json:"command"`
 	Workdir   string            `json:"workdir"`
 	Env       map[string]string `json:"env"`
+	// Profile names a [session_profiles.<name>] entry in config.toml.
+	Profile string `json:"profile"`
+	// Restart overrides the profile / config restart policy.
+	Restart *config.RestartPolicy `json:"restart"`
+	// Per-session idleTimeout / maxRuntime / timeoutAction / timeoutWarning.
+	config.SessionLimits
 }
 
 type stopSessionMsg struct {
@@ -43,6 +71,41 @@ type resumeSessionMsg struct {
 	SessionID string `json:"sessionId"`
 }
 
+type signalSessionMsg struct {
+	Type      string `json:"type"`
+	SessionID string `json:"sessionId"`
+	Signal    string `json:"signal"` // e.g. "SIGINT"; must be allow-listed
+	Target    string `json:"target"` // "foreground" (default) or "group"
+}
+
+// syncStateReplyMsg is the cloud's answer to the agent's sync_state.
+type syncStateReplyMsg struct {
+	Type     string `json:"type"`
+	Sessions []struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	} `json:"sessions"`
+	// Terminate lists sessions the cloud has marked for termination.
+	Terminate []string `json:"terminate"`
+}
+
+// configUpdateMsg carries a signed partial config; see config.RemoteConfig.
+type configUpdateMsg struct {
+	Type      string          `json:"type"`
+	Version   int64           `json:"version"`
+	Config    json.RawMessage `json:"config"`
+	Signature string          `json:"signature"` // base64 ed25519
+}
+
+// rotateCredentialsMsg hands the agent a new API key. The server accepts
+// the old key until PreviousKeyExpiresAt.
+type rotateCredentialsMsg struct {
+	Type                 string    `json:"type"`
+	RequestID            string    `json:"requestId"`
+	APIKey               string    `json:"apiKey"`
+	PreviousKeyExpiresAt time.Time `json:"previousKeyExpiresAt"`
+}
+
 type sessionInputMsg struct {
 	Type      string `json:"type"`
 	SessionID string `json:"sessionId"`
@@ -61,6 +124,8 @@ type Handler struct {
 	sessions SessionManager
 	client   *Client
 	logger   *slog.Logger
+	config   ConfigApplier
+	rotator  CredentialRotator
 }
 
 // NewHandler creates a Handler.
@@ -72,11 +137,31 @@ func NewHandler(sessions SessionManager, client *Client, logger *slog.Logger) *H
 	}
 }
 
+// SetConfigApplier enables config_update handling. Without one, updates
+// are refused.
+func (h *Handler) SetConfigApplier(a ConfigApplier) {
+	h.config = a
+}
+
+// SetCredentialRotator enables rotate_credentials handling. Without one,
+// rotations are refused.
+func (h *Handler) SetCredentialRotator(r CredentialRotator) {
+	h.rotator = r
+}
+
 // Handle processes one CloudToAgentMessage. It is called from the Client read loop.
 func (h *Handler) Handle(msg CloudMessage) {
 	h.logger.Debug("handler: received message", "type", msg.Type)
 
+	if capability, gated := messageCapabilities[msg.Type]; gated && !h.client.Supports(capability) {
+		h.refuseUnnegotiated(msg, capability)
+		return
+	}
+
 	switch msg.Type {
+	case "register_ack":
+		h.handleRegisterAck(msg.Raw)
+
 	case "start_session":
 		h.handleStartSession(msg.Raw)
 
@@ -89,6 +174,18 @@ func (h *Handler) Handle(msg CloudMessage) {
 	case "resume_session":
 		h.handleResumeSession(msg.Raw)
 
+	case "signal_session":
+		h.handleSignalSession(msg.Raw)
+
+	case "sync_state_reply":
+		h.handleSyncStateReply(msg.Raw)
+
+	case "config_update":
+		h.handleConfigUpdate(msg.Raw)
+
+	case "rotate_credentials":
+		h.handleRotateCredentials(msg.Raw)
+
 	case "session_input":
 		h.handleSessionInput(msg.Raw)
 
@@ -109,10 +206,11 @@ func (h *Handler) handleStartSession(raw []byte) {
 		h.logger.Error("handler: parse start_session", "err", err)
 		return
 	}
-	if m.Command == "" {
+	// With a profile, empty fields are filled from the profile by the manager.
+	if m.Command == "" && m.Profile == "" {
 		m.Command = "claude"
 	}
-	if m.Workdir == "" {
+	if m.Workdir == "" && m.Profile == "" {
 		m.Workdir = "."
 	}
 
@@ -121,11 +219,19 @@ func (h *Handler) handleStartSession(raw []byte) {
 		"sessionId", m.SessionID,
 		"command", m.Command,
 		"workdir", m.Workdir,
+		"profile", m.Profile,
 	)
 
-	sessionID, err := h.sessions.Start(m.RequestID, m.SessionID, m.Command, m.Workdir, m.Env)
+	sessionID, err := h.sessions.Start(m.RequestID, m.SessionID, m.Command, m.Workdir, m.Env,
+		config.SessionOptions{Profile: m.Profile, Restart: m.Restart, Limits: m.SessionLimits})
 	if err != nil {
 		h.logger.Error("handler: start_session failed", "err", err, "requestId", m.RequestID)
+		_ = h.client.SendJSON(map[string]any{
+			"type":      "session_error",
+			"requestId": m.RequestID,
+			"sessionId": m.SessionID,
+			"error":     err.Error(),
+		})
 		return
 	}
 
@@ -178,6 +284,103 @@ func (h *Handler) handleResumeSession(raw []byte) {
 	}
 }
 
+func (h *Handler) handleSignalSession(raw []byte) {
+	var m signalSessionMsg
+	if err := json.Unmarshal(raw, &m); err != nil {
+		h.logger.Error("handler: parse signal_session", "err", err)
+		return
+	}
+	h.logger.Info("handler: signal_session", "sessionId", m.SessionID, "signal", m.Signal, "target", m.Target)
+	if err := h.sessions.Signal(m.SessionID, m.Signal, m.Target); err != nil {
+		h.logger.Warn("handler: signal_session failed", "sessionId", m.SessionID, "err", err)
+		_ = h.client.SendJSON(map[string]any{
+			"type":      "session_error",
+			"sessionId": m.SessionID,
+			"error":     err.Error(),
+		})
+	}
+}
+
+func (h *Handler) handleSyncStateReply(raw []byte) {
+	var m syncStateReplyMsg
+	if err := json.Unmarshal(raw, &m); err != nil {
+		h.logger.Error("handler: parse sync_state_reply", "err", err)
+		return
+	}
+	remote := make(map[string]string, len(m.Sessions))
+	for _, s := range m.Sessions {
+		remote[s.ID] = s.Status
+	}
+	h.logger.Info("handler: sync_state_reply", "sessions", len(remote), "terminate", len(m.Terminate))
+	h.sessions.Reconcile(remote, m.Terminate)
+}
+
+func (h *Handler) handleConfigUpdate(raw []byte) {
+	var m configUpdateMsg
+	if err := json.Unmarshal(raw, &m); err != nil {
+		h.logger.Error("handler: parse config_update", "err", err)
+		return
+	}
+	h.logger.Info("handler: config_update", "version", m.Version)
+
+	var applied []string
+	err := fmt.Errorf("remote configuration is not supported by this agent process")
+	if h.config != nil {
+		applied, err = h.config.ApplyRemoteConfig(m.Version, m.Config, m.Signature)
+	}
+	if err != nil {
+		h.logger.Warn("handler: config_update refused", "version", m.Version, "err", err)
+		_ = h.client.SendJSON(map[string]any{
+			"type":    "config_rejected",
+			"version": m.Version,
+			"error":   err.Error(),
+		})
+		return
+	}
+	h.logger.Info("handler: config_update applied", "version", m.Version, "changed", applied)
+	_ = h.client.SendJSON(map[string]any{
+		"type":    "config_applied",
+		"version": m.Version,
+		"changed": applied,
+	})
+}
+
+func (h *Handler) handleRotateCredentials(raw []byte) {
+	var m rotateCredentialsMsg
+	if err := json.Unmarshal(raw, &m); err != nil {
+		h.logger.Error("handler: parse rotate_credentials", "err", err)
+		return
+	}
+	h.logger.Info("handler: rotate_credentials", "requestId", m.RequestID, "previousKeyExpiresAt", m.PreviousKeyExpiresAt)
+
+	var grace time.Duration
+	if !m.PreviousKeyExpiresAt.IsZero() {
+		grace = time.Until(m.PreviousKeyExpiresAt)
+	}
+	err := fmt.Errorf("credential rotation is not supported by this agent process")
+	switch {
+	case m.APIKey == "":
+		err = fmt.Errorf("no API key in rotate_credentials")
+	case h.rotator != nil:
+		err = h.rotator.RotateCredentials(m.APIKey, grace)
+	}
+	if err != nil {
+		h.logger.Warn("handler: rotate_credentials refused", "requestId", m.RequestID, "err", err)
+		_ = h.client.SendJSON(map[string]any{
+			"type":      "credentials_rotation_failed",
+			"requestId": m.RequestID,
+			"error":     err.Error(),
+		})
+		return
+	}
+	// The client is reconnecting with the new key by now, so this
+	// confirmation goes out over the new connection.
+	_ = h.client.SendJSON(map[string]any{
+		"type":      "credentials_rotated",
+		"requestId": m.RequestID,
+	})
+}
+
 func (h *Handler) handleSessionInput(raw []byte) {
 	var m sessionInputMsg
 	if err := json.Unmarshal(raw, &m); err != nil {
@@ -201,6 +404,31 @@ func (h *Handler) handleResize(raw []byte) {
 	}
 }
 
+func (h *Handler) handleRegisterAck(raw []byte) {
+	if err := h.client.negotiate(raw); err != nil {
+		h.logger.Error("handler: register_ack rejected", "err", err)
+	}
+}
+
+// refuseUnnegotiated rejects a message whose capability the server did not
+// accept in register_ack, rather than acting on a message the two sides may
+// understand differently.
+func (h *Handler) refuseUnnegotiated(msg CloudMessage, capability string) {
+	var m struct {
+		SessionID string `json:"sessionId"`
+	}
+	_ = json.Unmarshal(msg.Raw, &m)
+	h.logger.Warn("handler: refusing message for capability not negotiated",
+		"type", msg.Type, "capability", capability, "sessionId", m.SessionID)
+	if m.SessionID != "" {
+		_ = h.client.SendJSON(map[string]any{
+			"type":      "session_error",
+			"sessionId": m.SessionID,
+			"error":     fmt.Sprintf("%s requires capability %q, which was not negotiated", msg.Type, capability),
+		})
+	}
+}
+
 // handlePing responds to a server ping with a pong message.
 func (h *Handler) handlePing() {
 	h.logger.Debug("handler: ping received")
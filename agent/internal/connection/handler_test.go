@@ -0,0 +1,29 @@
+package connection
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestHandler_ReportsStartFailure(t *testing.T) {
+	c := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0", func(CloudMessage) {}, testLogger())
+	mgr := &recordingManager{startErr: errors.New(`unknown session profile "nope"`)}
+	h := NewHandler(mgr, c, testLogger())
+
+	h.Handle(CloudMessage{Type: "start_session", Raw: []byte(`{"type":"start_session","requestId":"r1","profile":"nope"}`)})
+	if len(c.sendCh) != 1 {
+		t.Fatalf("%d messages sent, want one session_error", len(c.sendCh))
+	}
+	var got struct {
+		Type      string `json:"type"`
+		RequestID string `json:"requestId"`
+		Error     string `json:"error"`
+	}
+	if err := json.Unmarshal((<-c.sendCh).data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "session_error" || got.RequestID != "r1" || got.Error != mgr.startErr.Error() {
+		t.Errorf("sent %+v, want session_error for r1 with the start error", got)
+	}
+}
@@ -6,12 +6,17 @@ import (
 	"strings"
 	"time"
 
-	goproc "github.com/shirou/gopsutil/v3/process"
-
+	"github.com/sessionforge/agent/internal/session"
 	"github.com/sessionforge/agent/internal/system"
 )
 
-const heartbeatInterval = 10 * time.Second
+const (
+	// heartbeatInterval is the default; heartbeat_interval overrides it.
+	heartbeatInterval = 10 * time.Second
+	// Bounds for heartbeat_interval, matching config validation.
+	minHeartbeatInterval = 5 * time.Second
+	maxHeartbeatInterval = time.Hour
+)
 
 // discoveredProcess mirrors the DiscoveredProcess type in ws-protocol.ts.
 type discoveredProcess struct {
@@ -21,6 +26,22 @@ type discoveredProcess struct {
 	Workdir string `json:"workdir"`
 }
 
+// sessionDetail is the per-session entry in a heartbeat.
+type sessionDetail struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	PID   int    `json:"pid"`
+	// Seconds until idle_timeout / max_runtime fire; omitted when no limit applies.
+	IdleTimeoutRemaining *int `json:"idleTimeoutRemaining,omitempty"`
+	MaxRuntimeRemaining  *int `json:"maxRuntimeRemaining,omitempty"`
+	// Aggregates over the session's whole process tree.
+	CPUPercent float64          `json:"cpuPercent"`
+	RSSBytes   uint64           `json:"rssBytes"`
+	OpenFiles  int32            `json:"openFiles"`
+	Threads    int32            `json:"threads"`
+	Processes  []sessionProcess `json:"processes"`
+}
+
 // heartbeatMsg matches the AgentMessage 'heartbeat' type in the WebSocket protocol.
 type heartbeatMsg struct {
 	Type                string              `json:"type"`
@@ -29,6 +50,7 @@ type heartbeatMsg struct {
 	Memory              float64             `json:"memory"`
 	Disk                float64             `json:"disk"`
 	SessionCount        int                 `json:"sessionCount"`
+	Sessions            []sessionDetail     `json:"sessions"`
 	DiscoveredProcesses []discoveredProcess `json:"discoveredProcesses"`
 }
 
@@ -49,25 +71,74 @@ type SessionPIDLister interface {
 	ManagedPIDs() map[int32]bool
 }
 
-// SessionScanner combines both interfaces — session.Manager satisfies both.
+// SessionLister is satisfied by session.Manager (GetAll method).
+type SessionLister interface {
+	GetAll() []*session.Session
+}
+
+// SessionScanner combines the interfaces above — session.Manager satisfies all of them.
 type SessionScanner interface {
 	SessionCounter
 	SessionPIDLister
+	SessionLister
+}
+
+// collectSessionDetails builds the per-session heartbeat entries, including
+// resource usage summed over each session's process tree.
+func collectSessionDetails(sessions []*session.Session, pt *processTable, cpu *cpuTracker, now time.Time) []sessionDetail {
+	details := make([]sessionDetail, 0, len(sessions))
+	for _, s := range sessions {
+		pid := s.PID()
+		d := sessionDetail{ID: s.ID, State: s.State(), PID: pid, Processes: []sessionProcess{}}
+		if pid != 0 {
+			u := collectUsage(pt, cpu, int32(pid), now)
+			d.CPUPercent = u.CPUPercent
+			d.RSSBytes = u.RSSBytes
+			d.OpenFiles = u.OpenFiles
+			d.Threads = u.Threads
+			if u.Processes != nil {
+				d.Processes = u.Processes
+			}
+		}
+		if rem, ok := s.IdleRemaining(now); ok {
+			d.IdleTimeoutRemaining = remainingSeconds(rem)
+		}
+		if rem, ok := s.RuntimeRemaining(now); ok {
+			d.MaxRuntimeRemaining = remainingSeconds(rem)
+		}
+		details = append(details, d)
+	}
+	return details
+}
+
+// remainingSeconds converts a countdown to whole seconds, clamped at zero.
+func remainingSeconds(d time.Duration) *int {
+	secs := int(d.Seconds())
+	if secs < 0 {
+		secs = 0
+	}
+	return &secs
 }
 
 // scanProcesses returns a list of running processes that match the allow-list
-// and are NOT already managed by SessionForge (i.e. not in managedPIDs).
+// and are NOT already managed by SessionForge (i.e. not in managedPIDs or
+// running underneath one of them, like claude launched from a managed shell).
 // Errors from individual process attribute reads are ignored silently —
 // this runs inside a Windows SCM service with no console, so best-effort is correct.
-func scanProcesses(managedPIDs map[int32]bool) []discoveredProcess {
-	procs, err := goproc.Processes()
-	if err != nil {
+func scanProcesses(pt *processTable, managedPIDs map[int32]bool) []discoveredProcess {
+	if pt == nil {
 		return nil
 	}
+	managed := make(map[int32]bool, len(managedPIDs))
+	for pid := range managedPIDs {
+		for _, p := range pt.descendants(pid) {
+			managed[p] = true
+		}
+	}
 
 	var found []discoveredProcess
-	for _, p := range procs {
-		if managedPIDs[p.Pid] {
+	for _, p := range pt.procs {
+		if managed[p.Pid] {
 			continue
 		}
 		name, err := p.Name()
@@ -108,16 +179,21 @@ func scanProcesses(managedPIDs map[int32]bool) []discoveredProcess {
 }
 
 // RunHeartbeat sends a heartbeat every 10 seconds until ctx is cancelled.
-// It collects live CPU/RAM/disk metrics and scans for unmanaged processes on each tick.
+// It collects live CPU/RAM/disk metrics, per-session process trees and scans
+// for unmanaged processes on each tick from a single process-list snapshot.
 func RunHeartbeat(ctx context.Context, client *Client, machineID string, sessions SessionScanner, logger *slog.Logger) {
-	logger.Info("heartbeat: started", "interval", heartbeatInterval)
-	ticker := time.NewTicker(heartbeatInterval)
+	interval := client.HeartbeatInterval()
+	logger.Info("heartbeat: started", "interval", interval)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	cpu := newCPUTracker()
 	send := func() {
 		metrics := system.Collect()
-		managedPIDs := sessions.ManagedPIDs()
-		discovered := scanProcesses(managedPIDs)
+		procs := snapshotProcesses()
+		details := collectSessionDetails(sessions.GetAll(), procs, cpu, time.Now())
+		cpu.rotate()
+		discovered := scanProcesses(procs, sessions.ManagedPIDs())
 		msg := heartbeatMsg{
 			Type:                "heartbeat",
 			MachineID:           machineID,
@@ -125,6 +201,7 @@ func RunHeartbeat(ctx context.Context, client *Client, machineID string, session
 			Memory:              metrics.MemoryPercent,
 			Disk:                metrics.DiskPercent,
 			SessionCount:        sessions.Count(),
+			Sessions:            details,
 			DiscoveredProcesses: discovered,
 		}
 		if err := client.SendJSON(msg); err != nil {
@@ -150,6 +227,34 @@ func RunHeartbeat(ctx context.Context, client *Client, machineID string, session
 			return
 		case <-ticker.C:
 			send()
+		case <-client.heartbeatCh:
+			if d := client.HeartbeatInterval(); d != interval {
+				interval = d
+				ticker.Reset(interval)
+				logger.Info("heartbeat: interval changed", "interval", interval)
+			}
 		}
 	}
 }
+
+// HeartbeatInterval returns how often RunHeartbeat reports.
+func (c *Client) HeartbeatInterval() time.Duration {
+	if d := time.Duration(c.heartbeatEvery.Load()); d > 0 {
+		return d
+	}
+	return heartbeatInterval
+}
+
+// SetHeartbeatInterval changes the heartbeat period, taking effect on the
+// running heartbeat loop. Zero restores the default; values are clamped to
+// the supported range.
+func (c *Client) SetHeartbeatInterval(d time.Duration) {
+	if d != 0 {
+		d = min(max(d, minHeartbeatInterval), maxHeartbeatInterval)
+	}
+	c.heartbeatEvery.Store(int64(d))
+	select {
+	case c.heartbeatCh <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,221 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// outboxFile lives in the config directory next to config.toml.
+	outboxFile = "outbox.jsonl"
+	// maxOutboxEntries and maxOutboxBytes bound the outbox; the oldest
+	// events are dropped first when either limit is exceeded.
+	maxOutboxEntries = 1000
+	maxOutboxBytes   = 4 << 20
+)
+
+// transientTypes are message types that are never persisted: terminal I/O
+// is too voluminous and worthless once stale, heartbeats, sync_state and
+// auth_refresh are regenerated on every connection (and carry credentials
+// that must not sit on disk).
+var transientTypes = map[string]bool{
+	"session_output": true,
+	"session_input":  true,
+	"heartbeat":      true,
+	"sync_state":     true,
+	"register":       true,
+	"auth_refresh":   true,
+	"pong":           true,
+}
+
+// outboxEntry is one persisted event. data already carries its eventId.
+type outboxEntry struct {
+	seq  uint64
+	data []byte
+}
+
+// outbox is a bounded, file-backed FIFO of control events that must reach
+// the cloud even if the agent is offline or restarts before reconnecting.
+// Each event is stamped with an eventId so the server can drop duplicates:
+// delivery is at-least-once because a write to the socket is not an ack.
+type outbox struct {
+	mu      sync.Mutex
+	path    string
+	entries []outboxEntry
+	size    int
+	nextSeq uint64
+	logger  *slog.Logger
+}
+
+// openOutbox loads any events left over from a previous run. Malformed lines
+// (e.g. a write cut short by a crash) are skipped.
+func openOutbox(dir string, logger *slog.Logger) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create outbox directory: %w", err)
+	}
+	o := &outbox{path: filepath.Join(dir, outboxFile), logger: logger}
+
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open outbox: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxOutboxBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || !json.Valid(line) {
+			continue
+		}
+		o.push(append([]byte(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warn("outbox: stopped reading at corrupt entry", "path", o.path, "err", err)
+	}
+	o.trim()
+	if len(o.entries) > 0 {
+		logger.Info("outbox: loaded undelivered events", "count", len(o.entries))
+	}
+	return o, nil
+}
+
+// add stamps data with a fresh eventId unless the sender already gave it
+// one, persists it and returns its sequence number.
+func (o *outbox) add(data []byte) (uint64, error) {
+	if !hasEventID(data) {
+		data = stampEventID(data, uuid.NewString())
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.push(data)
+	seq := o.nextSeq
+	if o.trim() {
+		return seq, o.rewrite()
+	}
+
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return seq, fmt.Errorf("open outbox: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return seq, fmt.Errorf("append outbox: %w", err)
+	}
+	return seq, f.Sync()
+}
+
+// pending returns a copy of the undelivered events in order.
+func (o *outbox) pending() []outboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]outboxEntry(nil), o.entries...)
+}
+
+// ack removes every event up to and including seq.
+func (o *outbox) ack(seq uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	i := 0
+	for i < len(o.entries) && o.entries[i].seq <= seq {
+		o.size -= len(o.entries[i].data)
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+	o.entries = append([]outboxEntry(nil), o.entries[i:]...)
+	return o.rewrite()
+}
+
+// push appends an entry in memory. Caller holds o.mu (or owns o).
+func (o *outbox) push(data []byte) {
+	o.nextSeq++
+	o.entries = append(o.entries, outboxEntry{seq: o.nextSeq, data: data})
+	o.size += len(data)
+}
+
+// trim drops the oldest entries until the outbox is within bounds and
+// reports whether anything was dropped. Caller holds o.mu.
+func (o *outbox) trim() bool {
+	dropped := 0
+	for len(o.entries) > 0 && (len(o.entries) > maxOutboxEntries || o.size > maxOutboxBytes) {
+		o.size -= len(o.entries[0].data)
+		o.entries = o.entries[1:]
+		dropped++
+	}
+	if dropped > 0 {
+		o.logger.Warn("outbox: full, dropped oldest events", "dropped", dropped)
+	}
+	return dropped > 0
+}
+
+// rewrite replaces the outbox file with the in-memory entries via a temp
+// file and rename, so a crash never leaves a half-written outbox. Caller
+// holds o.mu.
+func (o *outbox) rewrite() error {
+	if len(o.entries) == 0 {
+		if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove outbox: %w", err)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range o.entries {
+		buf.Write(e.data)
+		buf.WriteByte('\n')
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("write outbox: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("replace outbox: %w", err)
+	}
+	return nil
+}
+
+// stampEventID inserts "eventId" as the first field of a JSON object.
+func stampEventID(data []byte, id string) []byte {
+	stamped := []byte(`{"eventId":"` + id + `"`)
+	rest := bytes.TrimSpace(data[1:])
+	if len(rest) > 0 && rest[0] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, rest...)
+}
+
+// hasEventID reports whether data already carries an eventId, e.g. a
+// session_stopped resent after a sync_state.
+func hasEventID(data []byte) bool {
+	var envelope struct {
+		EventID string `json:"eventId"`
+	}
+	_ = json.Unmarshal(data, &envelope)
+	return envelope.EventID != ""
+}
+
+// messageType extracts the "type" field of an outgoing message. Output
+// chunks dominate traffic, so they are recognised without a full decode.
+func messageType(data []byte) string {
+	if bytes.HasPrefix(data, []byte(`{"type":"session_output"`)) {
+		return "session_output"
+	}
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(data, &envelope)
+	return envelope.Type
+}
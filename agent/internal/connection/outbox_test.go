@@ -0,0 +1,157 @@
+package connection
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestOutbox_PersistsInOrderAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	o, err := openOutbox(dir, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"session_started", "session_stopped", "session_crashed"} {
+		if _, err := o.add([]byte(`{"type":"` + typ + `","sessionId":"s1"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := openOutbox(dir, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := reopened.pending()
+	if len(pending) != 3 {
+		t.Fatalf("reloaded %d events, want 3", len(pending))
+	}
+	ids := map[string]bool{}
+	for i, want := range []string{"session_started", "session_stopped", "session_crashed"} {
+		var m struct {
+			EventID string `json:"eventId"`
+			Type    string `json:"type"`
+		}
+		if err := json.Unmarshal(pending[i].data, &m); err != nil {
+			t.Fatalf("event %d is not valid JSON: %v", i, err)
+		}
+		if m.Type != want || m.EventID == "" || ids[m.EventID] {
+			t.Fatalf("event %d = %+v, want type %s with a unique eventId", i, m, want)
+		}
+		ids[m.EventID] = true
+	}
+
+	if err := reopened.ack(pending[1].seq); err != nil {
+		t.Fatal(err)
+	}
+	again, err := openOutbox(dir, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := again.pending(); len(left) != 1 || messageType(left[0].data) != "session_crashed" {
+		t.Fatalf("after ack: %d events left, want only session_crashed", len(left))
+	}
+
+	if err := again.ack(again.pending()[0].seq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, outboxFile)); !os.IsNotExist(err) {
+		t.Fatalf("empty outbox file should be removed, stat err = %v", err)
+	}
+}
+
+func TestOutbox_DropsOldestWhenFull(t *testing.T) {
+	o, err := openOutbox(t.TempDir(), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxOutboxEntries+5; i++ {
+		if _, err := o.add([]byte(`{"type":"session_error"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending := o.pending()
+	if len(pending) != maxOutboxEntries || pending[0].seq != 6 {
+		t.Fatalf("outbox holds %d events starting at seq %d, want %d from seq 6",
+			len(pending), pending[0].seq, maxOutboxEntries)
+	}
+}
+
+func TestOutbox_SkipsCorruptLines(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"eventId":"a","type":"session_started"}` + "\n" + `{"eventId":"b","ty` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, outboxFile), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	o, err := openOutbox(dir, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(o.pending()); n != 1 {
+		t.Fatalf("loaded %d events, want 1", n)
+	}
+}
+
+func TestStampEventID(t *testing.T) {
+	if got := string(stampEventID([]byte(`{"type":"x"}`), "id1")); got != `{"eventId":"id1","type":"x"}` {
+		t.Errorf("stamped = %s", got)
+	}
+	if got := string(stampEventID([]byte(`{}`), "id2")); got != `{"eventId":"id2"}` {
+		t.Errorf("stamped empty object = %s", got)
+	}
+
+	o, err := openOutbox(t.TempDir(), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.add([]byte(`{"eventId":"orig","type":"session_stopped"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(o.pending()[0].data); got != `{"eventId":"orig","type":"session_stopped"}` {
+		t.Errorf("resent event = %s, want its original eventId kept", got)
+	}
+}
+
+func TestSendJSON_KeepsEventsInOrderWithOutput(t *testing.T) {
+	c := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0", func(CloudMessage) {}, testLogger())
+	if err := c.EnableOutbox(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"session_started", "session_output", "session_output", "session_stopped", "session_output"} {
+		if err := c.SendJSON(map[string]string{"type": typ, "sessionId": "s1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Drain the queue the way writeLoop does.
+	var got []string
+	record := func(_ int, data []byte) error {
+		got = append(got, messageType(data))
+		return nil
+	}
+	for len(c.sendCh) > 0 {
+		msg := <-c.sendCh
+		if msg.flushTo > 0 {
+			if err := c.flushOutbox(record, msg.flushTo); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		_ = record(0, msg.data)
+	}
+	want := "session_started,session_output,session_output,session_stopped,session_output"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("delivered %v, want %s", got, want)
+	}
+	if len(c.outbox.pending()) != 0 {
+		t.Error("delivered events left in the outbox")
+	}
+}
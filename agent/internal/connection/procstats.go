@@ -0,0 +1,148 @@
+package connection
+
+import (
+	"time"
+
+	goproc "github.com/shirou/gopsutil/v3/process"
+)
+
+// sessionProcess is one process in a session's tree. The tree is sent flat;
+// PPID links each entry to its parent. Only the executable name is reported:
+// command lines often carry tokens or passwords passed as arguments.
+type sessionProcess struct {
+	PID        int32   `json:"pid"`
+	PPID       int32   `json:"ppid"`
+	Name       string  `json:"name"`
+	CPUPercent float64 `json:"cpuPercent"`
+	RSSBytes   uint64  `json:"rssBytes"`
+}
+
+// processTable is one snapshot of the process list, indexed by PID and by
+// parent so session trees can be walked without rescanning.
+type processTable struct {
+	procs    map[int32]*goproc.Process
+	parent   map[int32]int32
+	children map[int32][]int32
+}
+
+// snapshotProcesses lists every process once per heartbeat. A nil table is
+// returned if the list cannot be read; callers treat it as empty.
+func snapshotProcesses() *processTable {
+	procs, err := goproc.Processes()
+	if err != nil {
+		return nil
+	}
+	pt := &processTable{
+		procs:    make(map[int32]*goproc.Process, len(procs)),
+		parent:   make(map[int32]int32, len(procs)),
+		children: make(map[int32][]int32),
+	}
+	for _, p := range procs {
+		pt.procs[p.Pid] = p
+		ppid, err := p.Ppid()
+		if err != nil {
+			continue
+		}
+		pt.parent[p.Pid] = ppid
+		pt.children[ppid] = append(pt.children[ppid], p.Pid)
+	}
+	return pt
+}
+
+// descendants returns root followed by every process below it, breadth first.
+// root is omitted if it is no longer running.
+func (pt *processTable) descendants(root int32) []int32 {
+	if pt == nil {
+		return nil
+	}
+	if _, ok := pt.procs[root]; !ok {
+		return nil
+	}
+	seen := map[int32]bool{root: true}
+	tree := []int32{root}
+	for i := 0; i < len(tree); i++ {
+		for _, child := range pt.children[tree[i]] {
+			// PID 0 is its own parent on some platforms; guard against loops.
+			if !seen[child] {
+				seen[child] = true
+				tree = append(tree, child)
+			}
+		}
+	}
+	return tree
+}
+
+// cpuSample is a process's cumulative CPU time at a point in time.
+type cpuSample struct {
+	total float64 // seconds of user+system time
+	at    time.Time
+}
+
+// cpuTracker turns cumulative CPU times into a percentage over the interval
+// since the previous heartbeat. The first sample for a PID reports 0.
+type cpuTracker struct {
+	prev map[int32]cpuSample
+	next map[int32]cpuSample
+}
+
+func newCPUTracker() *cpuTracker {
+	return &cpuTracker{prev: map[int32]cpuSample{}, next: map[int32]cpuSample{}}
+}
+
+// percent records total for pid and returns its CPU usage since the last round.
+func (t *cpuTracker) percent(pid int32, total float64, now time.Time) float64 {
+	t.next[pid] = cpuSample{total: total, at: now}
+	last, ok := t.prev[pid]
+	if !ok {
+		return 0
+	}
+	elapsed := now.Sub(last.at).Seconds()
+	delta := total - last.total
+	if elapsed <= 0 || delta < 0 {
+		// Negative delta means the PID was reused by a new process.
+		return 0
+	}
+	return delta / elapsed * 100
+}
+
+// rotate ends a sampling round. PIDs not seen this round are forgotten.
+func (t *cpuTracker) rotate() {
+	t.prev, t.next = t.next, make(map[int32]cpuSample, len(t.next))
+}
+
+// sessionUsage is the aggregate resource usage of one session's tree.
+type sessionUsage struct {
+	CPUPercent float64
+	RSSBytes   uint64
+	OpenFiles  int32
+	Threads    int32
+	Processes  []sessionProcess
+}
+
+// collectUsage walks the tree rooted at pid and sums its resource usage.
+// Per-process read errors (exited mid-walk, permission denied, NumFDs on
+// Windows) are skipped — accounting is best effort.
+func collectUsage(pt *processTable, cpu *cpuTracker, pid int32, now time.Time) sessionUsage {
+	var u sessionUsage
+	for _, p := range pt.descendants(pid) {
+		proc := pt.procs[p]
+		entry := sessionProcess{PID: p, PPID: pt.parent[p]}
+		entry.Name, _ = proc.Name()
+		if times, err := proc.Times(); err == nil {
+			entry.CPUPercent = cpu.percent(p, times.User+times.System, now)
+		}
+		if mem, err := proc.MemoryInfo(); err == nil {
+			entry.RSSBytes = mem.RSS
+		}
+		if n, err := proc.NumFDs(); err == nil {
+			u.OpenFiles += n
+		}
+		if n, err := proc.NumThreads(); err == nil {
+			u.Threads += n
+		}
+		u.CPUPercent += entry.CPUPercent
+		u.RSSBytes += entry.RSSBytes
+		u.Processes = append(u.Processes, entry)
+	}
+	return u
+}
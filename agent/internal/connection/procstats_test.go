@@ -0,0 +1,68 @@
+package connection
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	goproc "github.com/shirou/gopsutil/v3/process"
+)
+
+func fakeTable(parents map[int32]int32) *processTable {
+	pt := &processTable{
+		procs:    map[int32]*goproc.Process{},
+		parent:   parents,
+		children: map[int32][]int32{},
+	}
+	for pid, ppid := range parents {
+		pt.procs[pid] = &goproc.Process{Pid: pid}
+		pt.children[ppid] = append(pt.children[ppid], pid)
+	}
+	return pt
+}
+
+func TestProcessTable_Descendants(t *testing.T) {
+	pt := fakeTable(map[int32]int32{0: 0, 1: 0, 10: 1, 11: 10, 12: 10, 20: 1})
+	got := pt.descendants(10)
+	if len(got) != 3 || got[0] != 10 {
+		t.Fatalf("descendants(10) = %v, want root 10 then 11 and 12", got)
+	}
+	// PID 0 is its own parent; the walk must terminate.
+	if n := len(pt.descendants(0)); n != 6 {
+		t.Fatalf("descendants(0) has %d entries, want 6", n)
+	}
+	if got := pt.descendants(99); got != nil {
+		t.Fatalf("descendants of a missing PID = %v, want nil", got)
+	}
+}
+
+func TestCPUTracker_Percent(t *testing.T) {
+	cpu := newCPUTracker()
+	now := time.Now()
+	if p := cpu.percent(1, 5, now); p != 0 {
+		t.Fatalf("first sample = %v, want 0", p)
+	}
+	cpu.rotate()
+	if p := cpu.percent(1, 6, now.Add(10*time.Second)); p != 10 {
+		t.Fatalf("1s of CPU over 10s = %v%%, want 10", p)
+	}
+	cpu.rotate()
+	cpu.rotate()
+	if p := cpu.percent(1, 7, now.Add(20*time.Second)); p != 0 {
+		t.Fatalf("PID missing from the last round should restart at 0, got %v", p)
+	}
+}
+
+func TestCollectUsage_Self(t *testing.T) {
+	pt := snapshotProcesses()
+	if pt == nil {
+		t.Skip("process list unavailable")
+	}
+	u := collectUsage(pt, newCPUTracker(), int32(os.Getpid()), time.Now())
+	if len(u.Processes) == 0 || u.Processes[0].PID != int32(os.Getpid()) {
+		t.Fatalf("own process missing from tree: %+v", u.Processes)
+	}
+	if u.RSSBytes == 0 || u.Threads == 0 {
+		t.Fatalf("expected non-zero RSS and threads, got %+v", u)
+	}
+}
@@ -0,0 +1,279 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sessionforge/agent/internal/semver"
+)
+
+const (
+	// ProtocolVersion is the agent↔cloud protocol spoken by this agent. Bump
+	// it for changes an older server could misinterpret; additive features
+	// are announced as capabilities instead.
+	// Servers that never send register_ack speak version 1, which this agent
+	// still supports; a register_ack naming any other version, or a minimum
+	// agent version above this one, makes the two incompatible.
+	ProtocolVersion = 2
+	// minProtocolVersion is the oldest protocol this agent still speaks.
+	minProtocolVersion = 1
+	// incompatibleRetry is how long to wait before trying again after a
+	// version mismatch, in case the server has been upgraded meanwhile.
+	incompatibleRetry = time.Hour
+	// negotiationTimeout is how long after connecting the write loop holds
+	// back messages whose encoding depends on register_ack. A server that
+	// sends none predates negotiation and is only sent what protocol 1
+	// defines.
+	negotiationTimeout = 5 * time.Second
+)
+
+// Capabilities announced in register. The server echoes the subset it
+// accepts in register_ack.
+const (
+	capPauseResume   = "pause_resume"
+	capSignal        = "signal_session"
+	capSyncState     = "sync_state"
+	capEventIDs      = "event_ids"
+	capExitDetails   = "exit_details"
+	capSessionLimits = "session_limits"
+	capProfiles      = "session_profiles"
+	capAuthRefresh   = "auth_refresh"
+	capProcessStats  = "process_stats"
+	capConfigUpdate  = "config_update"
+	capRotateCreds   = "rotate_credentials"
+)
+
+// agentCapabilities is everything this agent supports.
+var agentCapabilities = []string{
+	capPauseResume,
+	capSignal,
+	capSyncState,
+	capEventIDs,
+	capExitDetails,
+	capSessionLimits,
+	capProfiles,
+	capAuthRefresh,
+	capProcessStats,
+	capConfigUpdate,
+	capRotateCreds,
+}
+
+// messageCapabilities maps incoming message types to the capability that
+// must have been negotiated before the handler acts on them.
+var messageCapabilities = map[string]string{
+	"pause_session":      capPauseResume,
+	"resume_session":     capPauseResume,
+	"signal_session":     capSignal,
+	"sync_state_reply":   capSyncState,
+	"config_update":      capConfigUpdate,
+	"rotate_credentials": capRotateCreds,
+}
+
+// outgoingCapabilities maps outgoing message types to the capability the
+// server must have accepted for them to be sent at all.
+var outgoingCapabilities = map[string]string{
+	"sync_state": capSyncState,
+}
+
+// exitDetailFields are the session_stopped / session_crashed fields that
+// only a server with exit_details is sent.
+var exitDetailFields = []string{
+	"signal", "coreDumped", "durationMs", "userCpuMs", "systemCpuMs", "peakRssBytes", "outputTail",
+}
+
+// registerAckMsg is the server's answer to register.
+type registerAckMsg struct {
+	Type            string   `json:"type"`
+	ProtocolVersion int      `json:"protocolVersion"`
+	ServerVersion   string   `json:"serverVersion"`
+	Features        []string `json:"features"`
+	MinAgentVersion string   `json:"minAgentVersion"`
+}
+
+// incompatibleError means the server and this agent cannot talk to each other.
+type incompatibleError struct {
+	reason string
+}
+
+func (e *incompatibleError) Error() string { return "incompatible server: " + e.reason }
+
+// Supports reports whether capability was negotiated with the server. Until
+// a register_ack arrives (older servers never send one) the agent assumes
+// the server knows everything it sends, as before negotiation existed.
+func (c *Client) Supports(capability string) bool {
+	c.protoMu.Lock()
+	defer c.protoMu.Unlock()
+	if c.negotiated == nil {
+		for _, cp := range agentCapabilities {
+			if cp == capability {
+				return true
+			}
+		}
+		return false
+	}
+	return c.negotiated[capability]
+}
+
+// serverAccepts reports whether the server may be sent something that
+// needs capability. Unlike Supports, a server that never answered with
+// register_ack accepts none of them.
+func (c *Client) serverAccepts(capability string) bool {
+	c.protoMu.Lock()
+	defer c.protoMu.Unlock()
+	if c.negotiated == nil {
+		return !c.legacyServer
+	}
+	return c.negotiated[capability]
+}
+
+// resetNegotiation forgets the previous server's answer; each connection,
+// possibly to another endpoint, negotiates afresh.
+func (c *Client) resetNegotiation() {
+	c.protoMu.Lock()
+	c.negotiated = nil
+	c.incompatible = nil
+	c.legacyServer = false
+	c.negotiatedCh = make(chan struct{})
+	c.protoMu.Unlock()
+}
+
+// awaitNegotiation blocks until register_ack has been applied or, at
+// deadline, marks the server as one that predates negotiation.
+func (c *Client) awaitNegotiation(ctx context.Context, deadline time.Time) {
+	c.protoMu.Lock()
+	done := c.negotiatedCh
+	settled := c.negotiated != nil || c.legacyServer
+	c.protoMu.Unlock()
+	if settled {
+		return
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-timer.C:
+		c.protoMu.Lock()
+		if c.negotiated == nil {
+			c.legacyServer = true
+		}
+		c.protoMu.Unlock()
+		c.logger.Info("connection: no register_ack; sending protocol 1 messages only")
+	}
+}
+
+// dependsOnNegotiation reports whether what adaptOutgoing makes of data
+// depends on the server's register_ack. Anything else, such as terminal
+// output, is sent as is and need not wait for it.
+func dependsOnNegotiation(data []byte) bool {
+	msgType := messageType(data)
+	if _, gated := outgoingCapabilities[msgType]; gated {
+		return true
+	}
+	return msgType == "session_stopped" || msgType == "session_crashed" || hasEventID(data)
+}
+
+// adaptOutgoing prepares data for the server: messages it did not accept
+// are dropped (ok is false) and fields it does not know are removed.
+func (c *Client) adaptOutgoing(data []byte) (out []byte, ok bool) {
+	msgType := messageType(data)
+	if capability, gated := outgoingCapabilities[msgType]; gated && !c.serverAccepts(capability) {
+		return nil, false
+	}
+	var drop []string
+	if !c.serverAccepts(capEventIDs) {
+		drop = append(drop, "eventId")
+	}
+	if (msgType == "session_stopped" || msgType == "session_crashed") && !c.serverAccepts(capExitDetails) {
+		drop = append(drop, exitDetailFields...)
+	}
+	if len(drop) == 0 {
+		return data, true
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data, true
+	}
+	for _, f := range drop {
+		delete(fields, f)
+	}
+	if out, err := json.Marshal(fields); err == nil {
+		return out, true
+	}
+	return data, true
+}
+
+// negotiate applies a register_ack. If the server is incompatible it returns
+// an error and the connection is closed so Run can back off.
+func (c *Client) negotiate(raw []byte) error {
+	var ack registerAckMsg
+	if err := json.Unmarshal(raw, &ack); err != nil {
+		return fmt.Errorf("parse register_ack: %w", err)
+	}
+
+	// Prerelease builds sort before their release, so a 1.2.0-rc.1 agent
+	// does not satisfy a minimum of 1.2.0.
+	var reason string
+	if ack.ProtocolVersion < minProtocolVersion || ack.ProtocolVersion > ProtocolVersion {
+		reason = fmt.Sprintf("server speaks protocol %d, this agent speaks %d to %d; run: sessionforge update",
+			ack.ProtocolVersion, minProtocolVersion, ProtocolVersion)
+	} else if ack.MinAgentVersion != "" && semver.IsNewer(c.version, ack.MinAgentVersion) {
+		reason = fmt.Sprintf("server requires agent v%s or newer, this is v%s; run: sessionforge update",
+			strings.TrimPrefix(ack.MinAgentVersion, "v"), strings.TrimPrefix(c.version, "v"))
+	}
+	if reason != "" {
+		err := &incompatibleError{reason: reason}
+		c.protoMu.Lock()
+		c.incompatible = err
+		c.protoMu.Unlock()
+		c.mu.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	ours := map[string]bool{}
+	for _, cp := range agentCapabilities {
+		ours[cp] = true
+	}
+	negotiated := map[string]bool{}
+	for _, f := range ack.Features {
+		if ours[f] {
+			negotiated[f] = true
+		}
+	}
+	c.protoMu.Lock()
+	c.negotiated = negotiated
+	if c.negotiatedCh != nil {
+		select {
+		case <-c.negotiatedCh:
+		default:
+			close(c.negotiatedCh)
+		}
+	}
+	c.protoMu.Unlock()
+
+	c.logger.Info("connection: protocol negotiated",
+		"serverVersion", ack.ServerVersion,
+		"protocol", ack.ProtocolVersion,
+		"features", ack.Features,
+	)
+	return nil
+}
+
+// takeIncompatible returns and clears the error recorded by negotiate.
+func (c *Client) takeIncompatible() error {
+	c.protoMu.Lock()
+	defer c.protoMu.Unlock()
+	err := c.incompatible
+	c.incompatible = nil
+	if err == nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,248 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sessionforge/agent/internal/config"
+)
+
+// recordingManager counts the calls the handler makes.
+type recordingManager struct {
+	signals  int
+	startErr error
+}
+
+func (m *recordingManager) Start(_, _, _, _ string, _ map[string]string, _ config.SessionOptions) (string, error) {
+	return "", m.startErr
+}
+func (m *recordingManager) Stop(string, bool) error               { return nil }
+func (m *recordingManager) Pause(string) error                    { return nil }
+func (m *recordingManager) Resume(string) error                   { return nil }
+func (m *recordingManager) Signal(string, string, string) error   { m.signals++; return nil }
+func (m *recordingManager) Reconcile(map[string]string, []string) {}
+func (m *recordingManager) WriteInput(string, string) error       { return nil }
+func (m *recordingManager) Resize(string, uint16, uint16) error   { return nil }
+
+func TestHandler_GatesOnNegotiatedCapabilities(t *testing.T) {
+	c := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0", func(CloudMessage) {}, testLogger())
+	mgr := &recordingManager{}
+	h := NewHandler(mgr, c, testLogger())
+	signal := CloudMessage{Type: "signal_session", Raw: []byte(`{"type":"signal_session","sessionId":"s1","signal":"INT"}`)}
+
+	// No register_ack yet: behave as before negotiation existed.
+	h.Handle(signal)
+	if mgr.signals != 1 {
+		t.Fatalf("signal before negotiation: %d calls, want 1", mgr.signals)
+	}
+
+	h.Handle(CloudMessage{Type: "register_ack", Raw: []byte(`{"type":"register_ack","protocolVersion":2,"serverVersion":"2.0.0","features":["sync_state","not_ours"]}`)})
+	if !c.Supports(capSyncState) || c.Supports(capSignal) || c.Supports("not_ours") {
+		t.Fatal("negotiated set should be the intersection of both sides")
+	}
+	h.Handle(signal)
+	if mgr.signals != 1 {
+		t.Fatal("signal_session acted on without the capability being negotiated")
+	}
+}
+
+func TestRun_RefusesIncompatibleServer(t *testing.T) {
+	registered := make(chan registerMsg, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/ws/agent" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var reg registerMsg
+		if err := conn.ReadJSON(&reg); err != nil {
+			return
+		}
+		registered <- reg
+		_ = conn.WriteJSON(registerAckMsg{Type: "register_ack", ProtocolVersion: 2, ServerVersion: "3.0.0", MinAgentVersion: "2.0.0"})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	var c *Client
+	c = NewClient(testConfig(srv.URL), "1.4.0", func(msg CloudMessage) {
+		NewHandler(&recordingManager{}, c, testLogger()).Handle(msg)
+	}, testLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	select {
+	case reg := <-registered:
+		if reg.ProtocolVersion != ProtocolVersion || len(reg.Capabilities) == 0 {
+			t.Fatalf("register carried protocol %d and %d capabilities", reg.ProtocolVersion, len(reg.Capabilities))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent never registered")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if state, _ := c.State(); state == StateIncompatible {
+			break
+		}
+		if time.Now().After(deadline) {
+			state, reason := c.State()
+			t.Fatalf("state = %q (%s), want %q", state, reason, StateIncompatible)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if c.IsConnected() {
+		t.Fatal("connection to an incompatible server was kept open")
+	}
+	cancel()
+	c.Wait()
+}
+
+func TestNegotiate_MinAgentVersion(t *testing.T) {
+	c := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0", func(CloudMessage) {}, testLogger())
+	ack, _ := json.Marshal(registerAckMsg{Type: "register_ack", ProtocolVersion: 2, MinAgentVersion: "1.5.0"})
+	var incompatible *incompatibleError
+	if err := c.negotiate(ack); !errors.As(err, &incompatible) {
+		t.Fatalf("negotiate() = %v, want incompatibleError", err)
+	}
+	ack, _ = json.Marshal(registerAckMsg{Type: "register_ack", ProtocolVersion: 2, MinAgentVersion: "1.4.0"})
+	if err := c.negotiate(ack); err != nil {
+		t.Fatalf("negotiate() = %v for a supported version", err)
+	}
+
+	rc := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0-rc.1", func(CloudMessage) {}, testLogger())
+	if err := rc.negotiate(ack); !errors.As(err, &incompatible) {
+		t.Fatalf("negotiate() = %v for a prerelease of the minimum, want incompatibleError", err)
+	}
+	dev := NewClient(testConfig("http://127.0.0.1:1"), "dev", func(CloudMessage) {}, testLogger())
+	if err := dev.negotiate(ack); err != nil {
+		t.Fatalf("negotiate() = %v for a development build", err)
+	}
+}
+
+func TestNegotiate_ProtocolVersion(t *testing.T) {
+	for _, version := range []int{0, ProtocolVersion + 1} {
+		c := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0", func(CloudMessage) {}, testLogger())
+		ack, _ := json.Marshal(registerAckMsg{Type: "register_ack", ProtocolVersion: version})
+		var incompatible *incompatibleError
+		if err := c.negotiate(ack); !errors.As(err, &incompatible) || !strings.Contains(err.Error(), "protocol") {
+			t.Errorf("negotiate() = %v for protocol %d, want incompatibleError", err, version)
+		}
+	}
+	for _, version := range []int{minProtocolVersion, ProtocolVersion} {
+		c := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0", func(CloudMessage) {}, testLogger())
+		ack, _ := json.Marshal(registerAckMsg{Type: "register_ack", ProtocolVersion: version})
+		if err := c.negotiate(ack); err != nil {
+			t.Errorf("negotiate() = %v for protocol %d", err, version)
+		}
+	}
+}
+
+func TestWriteLoop_OutputDoesNotWaitForNegotiation(t *testing.T) {
+	received := make(chan string, 4)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/ws/agent" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Never answer register, like a server that is slow to.
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- messageType(raw)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(testConfig(srv.URL), "1.4.0", func(CloudMessage) {}, testLogger())
+	c.negotiationWait = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	if got := <-received; got != "register" {
+		t.Fatalf("first message = %q, want register", got)
+	}
+	_ = c.SendJSON(map[string]string{"type": "sync_state"})
+	_ = c.SendJSON(map[string]string{"type": "session_output", "sessionId": "s1"})
+	select {
+	case got := <-received:
+		t.Fatalf("%s sent before register_ack", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Without anything gated ahead of it, output goes out at once.
+	c2 := NewClient(testConfig(srv.URL), "1.4.0", func(CloudMessage) {}, testLogger())
+	c2.negotiationWait = time.Minute
+	go c2.Run(ctx)
+	if got := <-received; got != "register" {
+		t.Fatalf("first message = %q, want register", got)
+	}
+	_ = c2.SendJSON(map[string]string{"type": "session_output", "sessionId": "s1"})
+	select {
+	case got := <-received:
+		if got != "session_output" {
+			t.Fatalf("sent %q, want session_output", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("session_output held back waiting for register_ack")
+	}
+}
+
+func TestAdaptOutgoing_GatesOnNegotiatedCapabilities(t *testing.T) {
+	c := NewClient(testConfig("http://127.0.0.1:1"), "1.4.0", func(CloudMessage) {}, testLogger())
+	stopped := []byte(`{"eventId":"e1","type":"session_stopped","sessionId":"s1","exitCode":0,"durationMs":5,"signal":"SIGTERM"}`)
+	sync := []byte(`{"type":"sync_state","sessions":[]}`)
+
+	// A server that never answers register predates negotiation.
+	c.resetNegotiation()
+	c.awaitNegotiation(context.Background(), time.Now().Add(10*time.Millisecond))
+	if _, ok := c.adaptOutgoing(sync); ok {
+		t.Error("sync_state sent to a server without register_ack")
+	}
+	out, ok := c.adaptOutgoing(stopped)
+	if !ok || string(out) != `{"exitCode":0,"sessionId":"s1","type":"session_stopped"}` {
+		t.Errorf("session_stopped for a legacy server = %s, %v", out, ok)
+	}
+
+	c.resetNegotiation()
+	ack, _ := json.Marshal(registerAckMsg{Type: "register_ack", ProtocolVersion: 2, Features: []string{"sync_state", "exit_details"}})
+	if err := c.negotiate(ack); err != nil {
+		t.Fatal(err)
+	}
+	c.awaitNegotiation(context.Background(), time.Now())
+	if _, ok := c.adaptOutgoing(sync); !ok {
+		t.Error("sync_state dropped although negotiated")
+	}
+	var m map[string]any
+	out, _ = c.adaptOutgoing(stopped)
+	_ = json.Unmarshal(out, &m)
+	if _, has := m["eventId"]; has || m["signal"] != "SIGTERM" {
+		t.Errorf("with exit_details but not event_ids: %s", out)
+	}
+}
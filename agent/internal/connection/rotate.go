@@ -0,0 +1,95 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sessionforge/agent/internal/config"
+)
+
+const (
+	// rotatePath issues a new API key for the calling machine. The server
+	// keeps accepting the old key until the grace period ends.
+	rotatePath = "/api/agent/credentials/rotate"
+
+	// DefaultRotationGrace is how long both keys stay valid when the caller
+	// or the server does not say otherwise.
+	DefaultRotationGrace = 15 * time.Minute
+)
+
+// errRedial ends a healthy connection on purpose so Run reconnects at once
+// without counting it as a failure.
+var errRedial = errors.New("reconnecting on purpose")
+
+// RotatedKey is the server's answer to a rotation request.
+type RotatedKey struct {
+	APIKey string `json:"apiKey"`
+	// PreviousKeyExpiresAt is when the server stops accepting the old key.
+	PreviousKeyExpiresAt time.Time `json:"previousKeyExpiresAt"`
+}
+
+// RotateAPIKey asks cfg.ServerURL for a new API key, authenticating with the
+// current one, which stays valid for grace.
+func RotateAPIKey(ctx context.Context, cfg *config.Config, version string, grace time.Duration) (*RotatedKey, error) {
+	body := map[string]any{
+		"machineId":    cfg.MachineID,
+		"graceSeconds": int(grace / time.Second),
+	}
+	resp, err := authenticatedRequest(ctx, cfg, version, http.MethodPost, rotatePath, body)
+	if err != nil {
+		return nil, fmt.Errorf("rotate key: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errors.New("rotate key: this server does not support key rotation")
+	default:
+		return nil, fmt.Errorf("rotate key: %w", newDialError(resp, errors.New(http.StatusText(resp.StatusCode))))
+	}
+
+	var rk RotatedKey
+	if err := json.NewDecoder(resp.Body).Decode(&rk); err != nil {
+		return nil, fmt.Errorf("rotate key: decode response: %w", err)
+	}
+	if rk.APIKey == "" {
+		return nil, errors.New("rotate key: server issued no key")
+	}
+	return &rk, nil
+}
+
+// RotateCredentials switches the client to apiKey and reconnects so the
+// server sees the new credential. The previous key is kept as a fallback
+// for grace in case the new one is not accepted yet.
+func (c *Client) RotateCredentials(apiKey string, grace time.Duration) {
+	if grace <= 0 {
+		grace = DefaultRotationGrace
+	}
+	c.tokens.rotate(apiKey, grace)
+	c.logger.Info("connection: API key rotated, reconnecting", "grace", grace)
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.redial(conn)
+	}
+	// Resumes a client that had given up on the old key.
+	c.Reauthorize()
+}
+
+// APIKey returns the API key the client authenticates with, which changes
+// when the credentials are rotated.
+func (c *Client) APIKey() (string, error) {
+	return c.tokens.apiKey()
+}
+
+// redial closes conn so Run reconnects immediately.
+func (c *Client) redial(conn *websocket.Conn) {
+	c.redialing.Store(true)
+	conn.Close()
+}
@@ -0,0 +1,89 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRotateAPIKey(t *testing.T) {
+	expires := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == tokenPath:
+			http.NotFound(w, r)
+		case r.URL.Path != rotatePath || r.Method != http.MethodPost:
+			http.NotFound(w, r)
+		case r.Header.Get("Authorization") != "Bearer sf_live_testkey":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			var body struct {
+				MachineID    string `json:"machineId"`
+				GraceSeconds int    `json:"graceSeconds"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.MachineID != "machine-1" || body.GraceSeconds != 600 {
+				t.Errorf("rotate request = %+v", body)
+			}
+			_ = json.NewEncoder(w).Encode(RotatedKey{APIKey: "sf_live_rotated", PreviousKeyExpiresAt: expires})
+		}
+	}))
+	defer srv.Close()
+
+	rk, err := RotateAPIKey(context.Background(), testConfig(srv.URL), "test", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rk.APIKey != "sf_live_rotated" || !rk.PreviousKeyExpiresAt.Equal(expires) {
+		t.Errorf("RotateAPIKey() = %+v", rk)
+	}
+
+	cfg := testConfig(srv.URL)
+	cfg.APIKey = "sf_live_revoked"
+	if _, err := RotateAPIKey(context.Background(), cfg, "test", time.Minute); !IsUnauthorized(err) {
+		t.Errorf("rotation with a rejected key: err = %v, want unauthorized", err)
+	}
+}
+
+func TestTokenSource_RotationFallsBackDuringGrace(t *testing.T) {
+	// The server has not accepted the new key yet.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sf_live_testkey" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(tokenResponse{Token: "session-token", ExpiresIn: 600})
+	}))
+	defer srv.Close()
+
+	ts := newTokenSource(testConfig(srv.URL), "test", testLogger())
+	ts.rotate("sf_live_new", time.Minute)
+	if tok, err := ts.Token(context.Background()); err != nil || tok != "session-token" {
+		t.Fatalf("Token() during grace = %q, %v; want the previous key to be used", tok, err)
+	}
+
+	ts.mu.Lock()
+	ts.previousUntil = time.Now().Add(-time.Second)
+	ts.token = ""
+	ts.mu.Unlock()
+	if _, err := ts.Token(context.Background()); !isUnauthorized(err) {
+		t.Fatalf("Token() after grace: err = %v, want unauthorized", err)
+	}
+}
+
+func TestClient_RotateCredentialsSwitchesKey(t *testing.T) {
+	c := NewClient(testConfig("http://127.0.0.1:1"), "test", func(CloudMessage) {}, testLogger())
+	c.RotateCredentials("sf_live_new", 0)
+	if key, err := c.APIKey(); err != nil || key != "sf_live_new" {
+		t.Fatalf("APIKey() = %q, %v", key, err)
+	}
+	if until := time.Until(c.tokens.previousUntil); until <= 0 || until > DefaultRotationGrace {
+		t.Errorf("previous key kept for %s, want the default grace", until)
+	}
+	if c.tokens.previousKey != "sf_live_testkey" {
+		t.Errorf("previous key = %q", c.tokens.previousKey)
+	}
+}
@@ -0,0 +1,118 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	goproc "github.com/shirou/gopsutil/v3/process"
+)
+
+// stateFile records the daemon's connection state in the config directory
+// so `sessionforge status`, a separate process, can report it.
+const stateFile = "agent_state.json"
+
+// Connection states reported by Client.State.
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	// StateUnauthorized means the server rejected the credentials; the
+	// client stops retrying until Reauthorize is called.
+	StateUnauthorized = "unauthorized"
+	// StateIncompatible means the server refused this agent's version or
+	// protocol; the client retries rarely in case the server is upgraded.
+	StateIncompatible = "incompatible"
+	StateStopped      = "stopped"
+)
+
+// AgentState is the persisted form of the client's connection state.
+type AgentState struct {
+	State    string    `json:"state"`
+	Reason   string    `json:"reason,omitempty"`
+	Endpoint string    `json:"endpoint,omitempty"`
+	Since    time.Time `json:"since"`
+	PID      int       `json:"pid"`
+}
+
+// ReadState returns the state last written by a daemon using dir, or nil if
+// none has been written.
+func ReadState(dir string) (*AgentState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read agent state: %w", err)
+	}
+	var st AgentState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("parse agent state: %w", err)
+	}
+	return &st, nil
+}
+
+// Running reports whether the process that wrote st is still alive. A
+// daemon killed without a clean shutdown leaves a stale state behind.
+func (st *AgentState) Running() bool {
+	if st.State == StateStopped || st.PID <= 0 {
+		return false
+	}
+	ok, err := goproc.PidExists(int32(st.PID))
+	return err == nil && ok
+}
+
+// EnableStateFile makes the client persist its connection state in dir.
+// Like EnableOutbox, only the long-running agent should call it.
+func (c *Client) EnableStateFile(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	c.stateMu.Lock()
+	c.statePath = filepath.Join(dir, stateFile)
+	c.stateMu.Unlock()
+	c.writeState()
+	return nil
+}
+
+// State returns the current connection state and, for unauthorized or
+// reconnecting, the error that caused it.
+func (c *Client) State() (state, reason string) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state.State, c.state.Reason
+}
+
+// setState records a state transition, persisting it if enabled.
+func (c *Client) setState(state, reason string) {
+	endpoint := c.Endpoint()
+	c.stateMu.Lock()
+	if c.state.State == state && c.state.Reason == reason && c.state.Endpoint == endpoint {
+		c.stateMu.Unlock()
+		return
+	}
+	c.state = AgentState{State: state, Reason: reason, Endpoint: endpoint, Since: time.Now(), PID: os.Getpid()}
+	c.stateMu.Unlock()
+	c.writeState()
+}
+
+// writeState replaces the state file via a temp file and rename so status
+// never reads a partial write.
+func (c *Client) writeState() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.statePath == "" {
+		return
+	}
+	data, _ := json.Marshal(c.state)
+	tmp := c.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		c.logger.Warn("connection: failed to write state file", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, c.statePath); err != nil {
+		c.logger.Warn("connection: failed to write state file", "err", err)
+	}
+}
@@ -0,0 +1,280 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+	"github.com/sessionforge/agent/internal/transport"
+)
+
+const (
+	// tokenPath exchanges the long-lived API key for a short-lived token.
+	tokenPath = "/api/agent/token"
+	// tokenRefreshMargin is how long before expiry a token is replaced. For
+	// short tokens a fifth of the lifetime is used instead.
+	tokenRefreshMargin = 2 * time.Minute
+	// tokenExchangeRetry is how long to keep using the API key directly after
+	// the server turned out not to support the exchange.
+	tokenExchangeRetry = time.Hour
+	tokenTimeout       = 15 * time.Second
+)
+
+// tokenResponse is the body returned by tokenPath. Either expiresIn
+// (seconds) or expiresAt may be set.
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresIn int       `json:"expiresIn"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// tokenSource hands out the credential for the Authorization header: a
+// short-lived session token when the server supports the exchange, or the
+// API key itself for servers that predate it.
+type tokenSource struct {
+	cfg     *config.Config
+	version string
+	logger  *slog.Logger
+
+	// httpMu guards httpClient, built on first use and reused so every
+	// exchange shares one connection pool.
+	httpMu     sync.Mutex
+	httpClient *http.Client
+
+	// mu guards the fields below. It is never held during an exchange:
+	// exchanging is closed when the one in flight finishes, and generation
+	// is bumped by useServer and rotate so a result obtained for the old
+	// server or key is discarded.
+	mu               sync.Mutex
+	serverURL        string
+	token            string
+	refreshAt        time.Time
+	unsupportedUntil time.Time
+	exchanging       chan struct{}
+	generation       int
+
+	// rotatedKey replaces the configured key after a rotation. previousKey
+	// is tried if the server rejects it before previousUntil, while the
+	// server still accepts both.
+	rotatedKey    string
+	previousKey   string
+	previousUntil time.Time
+}
+
+// exchangeResult is the outcome of one token exchange.
+type exchangeResult struct {
+	token       string
+	refreshAt   time.Time
+	unsupported bool // the server has no token exchange
+}
+
+func newTokenSource(cfg *config.Config, version string, logger *slog.Logger) *tokenSource {
+	return &tokenSource{cfg: cfg, version: version, logger: logger, serverURL: cfg.ServerURL}
+}
+
+// client returns the HTTP client used for exchanges.
+func (ts *tokenSource) client() (*http.Client, error) {
+	ts.httpMu.Lock()
+	defer ts.httpMu.Unlock()
+	if ts.httpClient == nil {
+		c, err := transport.HTTPClient(ts.cfg, tokenTimeout)
+		if err != nil {
+			return nil, err
+		}
+		ts.httpClient = c
+	}
+	return ts.httpClient, nil
+}
+
+// useServer points the exchange at another endpoint. Tokens are not
+// portable between servers, so any cached one is dropped.
+func (ts *tokenSource) useServer(serverURL string) {
+	ts.mu.Lock()
+	ts.serverURL = serverURL
+	ts.token = ""
+	ts.unsupportedUntil = time.Time{}
+	ts.generation++
+	ts.mu.Unlock()
+}
+
+// Token returns a valid credential, exchanging the API key if the cached
+// token is missing or due for refresh. Concurrent callers share a single
+// exchange.
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	for {
+		ts.mu.Lock()
+		now := time.Now()
+		if ts.token != "" && now.Before(ts.refreshAt) {
+			token := ts.token
+			ts.mu.Unlock()
+			return token, nil
+		}
+		if now.Before(ts.unsupportedUntil) {
+			ts.mu.Unlock()
+			return ts.apiKey()
+		}
+		if wait := ts.exchanging; wait != nil {
+			ts.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		ts.exchanging = done
+		generation, serverURL := ts.generation, ts.serverURL
+		ts.mu.Unlock()
+
+		res, err := ts.exchange(ctx, now, serverURL)
+
+		ts.mu.Lock()
+		ts.exchanging = nil
+		close(done)
+		if generation != ts.generation {
+			// The server or key changed meanwhile; try again with the new one.
+			ts.mu.Unlock()
+			continue
+		}
+		if err == nil {
+			ts.token, ts.refreshAt = res.token, res.refreshAt
+			if res.unsupported {
+				ts.unsupportedUntil = now.Add(tokenExchangeRetry)
+			}
+		}
+		token := ts.token
+		ts.mu.Unlock()
+		if err != nil {
+			return "", err
+		}
+		if token == "" {
+			return ts.apiKey()
+		}
+		return token, nil
+	}
+}
+
+// nextRefresh returns when the current token should be replaced, or the
+// zero time if the API key is being used directly.
+func (ts *tokenSource) nextRefresh() time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == "" {
+		return time.Time{}
+	}
+	return ts.refreshAt
+}
+
+// invalidate drops the cached token so the next Token call exchanges again.
+func (ts *tokenSource) invalidate() {
+	ts.mu.Lock()
+	ts.token = ""
+	ts.mu.Unlock()
+}
+
+// rotate switches to apiKey, keeping the current key as a fallback for
+// grace, and drops the cached token so the next Token call uses the new key.
+func (ts *tokenSource) rotate(apiKey string, grace time.Duration) {
+	prev, err := ts.apiKey()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err == nil && prev != apiKey {
+		ts.previousKey = prev
+		ts.previousUntil = time.Now().Add(grace)
+	}
+	ts.rotatedKey = apiKey
+	ts.token = ""
+	ts.generation++
+}
+
+// apiKey returns the key to authenticate with. A key from a credential
+// helper is resolved without holding ts.mu.
+func (ts *tokenSource) apiKey() (string, error) {
+	ts.mu.Lock()
+	rotated := ts.rotatedKey
+	ts.mu.Unlock()
+	if rotated != "" {
+		return rotated, nil
+	}
+	return ts.cfg.ResolveAPIKey()
+}
+
+// exchange trades the API key for a session token at serverURL, falling
+// back to the previous key during a rotation's grace period.
+func (ts *tokenSource) exchange(ctx context.Context, now time.Time, serverURL string) (exchangeResult, error) {
+	apiKey, err := ts.apiKey()
+	if err != nil {
+		return exchangeResult{}, err
+	}
+	res, err := ts.exchangeWith(ctx, now, serverURL, apiKey)
+	ts.mu.Lock()
+	previous, until := ts.previousKey, ts.previousUntil
+	ts.mu.Unlock()
+	if isUnauthorized(err) && previous != "" && now.Before(until) {
+		ts.logger.Warn("connection: new API key rejected, using the previous one until the rotation grace period ends",
+			"until", until)
+		return ts.exchangeWith(ctx, now, serverURL, previous)
+	}
+	return res, err
+}
+
+// exchangeWith performs the exchange with apiKey.
+func (ts *tokenSource) exchangeWith(ctx context.Context, now time.Time, serverURL, apiKey string) (exchangeResult, error) {
+	client, err := ts.client()
+	if err != nil {
+		return exchangeResult{}, err
+	}
+	body, _ := json.Marshal(map[string]string{"machineId": ts.cfg.MachineID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(serverURL, "/")+tokenPath, bytes.NewReader(body))
+	if err != nil {
+		return exchangeResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("User-Agent", "SessionForge-Agent/"+ts.version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return exchangeResult{}, fmt.Errorf("token exchange: %w", transport.DiagnoseTLS(err))
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		ts.logger.Info("connection: server has no token exchange, authenticating with API key")
+		return exchangeResult{unsupported: true}, nil
+	case resp.StatusCode != http.StatusOK:
+		return exchangeResult{}, fmt.Errorf("token exchange: %w", newDialError(resp, errors.New(http.StatusText(resp.StatusCode))))
+	}
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return exchangeResult{}, fmt.Errorf("token exchange: decode response: %w", err)
+	}
+	if tr.Token == "" {
+		return exchangeResult{}, fmt.Errorf("token exchange: empty token in response")
+	}
+	expiry := tr.ExpiresAt
+	if tr.ExpiresIn > 0 {
+		expiry = now.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	if expiry.IsZero() {
+		expiry = now.Add(time.Hour)
+	}
+	margin := tokenRefreshMargin
+	if lifetime := expiry.Sub(now); lifetime/5 < margin {
+		margin = lifetime / 5
+	}
+	ts.logger.Debug("connection: obtained session token", "expires", expiry)
+	return exchangeResult{token: tr.Token, refreshAt: expiry.Add(-margin)}, nil
+}
@@ -0,0 +1,158 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sessionforge/agent/internal/config"
+)
+
+func testConfig(serverURL string) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.ServerURL = serverURL
+	cfg.APIKey = "sf_live_testkey"
+	cfg.MachineID = "machine-1"
+	cfg.ProxyURL = "direct"
+	return cfg
+}
+
+func TestTokenSource_ExchangesAndCaches(t *testing.T) {
+	exchanges := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tokenPath || r.Header.Get("Authorization") != "Bearer sf_live_testkey" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		exchanges++
+		_ = json.NewEncoder(w).Encode(tokenResponse{Token: "session-token", ExpiresIn: 600})
+	}))
+	defer srv.Close()
+
+	ts := newTokenSource(testConfig(srv.URL), "test", testLogger())
+	for i := 0; i < 3; i++ {
+		tok, err := ts.Token(context.Background())
+		if err != nil || tok != "session-token" {
+			t.Fatalf("Token() = %q, %v", tok, err)
+		}
+	}
+	if exchanges != 1 {
+		t.Fatalf("exchanged %d times, want 1 (cached)", exchanges)
+	}
+	if until := time.Until(ts.nextRefresh()); until <= 0 || until > 10*time.Minute-tokenRefreshMargin {
+		t.Fatalf("refresh scheduled in %s, want before expiry minus margin", until)
+	}
+
+	ts.invalidate()
+	if _, err := ts.Token(context.Background()); err != nil || exchanges != 2 {
+		t.Fatalf("invalidate did not force a new exchange (exchanges=%d, err=%v)", exchanges, err)
+	}
+}
+
+func TestTokenSource_SharesExchangeWithoutBlocking(t *testing.T) {
+	release := make(chan struct{})
+	var exchanges atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchanges.Add(1)
+		<-release
+		_ = json.NewEncoder(w).Encode(tokenResponse{Token: "session-token", ExpiresIn: 600})
+	}))
+	defer srv.Close()
+
+	ts := newTokenSource(testConfig(srv.URL), "test", testLogger())
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tok, err := ts.Token(context.Background()); err != nil || tok != "session-token" {
+				t.Errorf("Token() = %q, %v", tok, err)
+			}
+		}()
+	}
+	for exchanges.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The exchange is in flight; the source's state must stay readable.
+	done := make(chan struct{})
+	go func() {
+		ts.nextRefresh()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("nextRefresh blocked behind the exchange")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := exchanges.Load(); n != 1 {
+		t.Fatalf("exchanged %d times, want 1 shared exchange", n)
+	}
+	c1, _ := ts.client()
+	c2, _ := ts.client()
+	if c1 != c2 {
+		t.Fatal("HTTP client rebuilt between exchanges")
+	}
+}
+
+func TestTokenSource_FallsBackToAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ts := newTokenSource(testConfig(srv.URL), "test", testLogger())
+	tok, err := ts.Token(context.Background())
+	if err != nil || tok != "sf_live_testkey" {
+		t.Fatalf("Token() = %q, %v; want the API key", tok, err)
+	}
+	if !ts.nextRefresh().IsZero() {
+		t.Fatal("no refresh should be scheduled when using the API key")
+	}
+}
+
+func TestClient_SendsCredentialsInHeader(t *testing.T) {
+	gotAuth := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case tokenPath:
+			_ = json.NewEncoder(w).Encode(tokenResponse{Token: "session-token", ExpiresIn: 600})
+		case "/api/ws/agent":
+			if r.URL.RawQuery != "" {
+				t.Errorf("credentials leaked into the URL: %s", r.URL.RawQuery)
+			}
+			gotAuth <- r.Header.Get("Authorization")
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err == nil {
+				conn.Close()
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := NewClient(testConfig(srv.URL), "test", func(CloudMessage) {}, testLogger())
+	go c.Run(ctx)
+
+	select {
+	case auth := <-gotAuth:
+		if auth != "Bearer session-token" {
+			t.Fatalf("Authorization = %q, want the session token", auth)
+		}
+	case <-ctx.Done():
+		t.Fatal("agent never dialled the WebSocket")
+	}
+	if strings.Contains(testConfig(srv.URL).WebSocketURL(), "key=") {
+		t.Fatal("WebSocketURL still carries the API key")
+	}
+}
@@ -35,22 +35,26 @@ type Event struct {
 // If the queue is full or the server is unreachable, events are silently dropped.
 type Client struct {
 	machineID    string
-	apiKey       string
+	apiKeyFn     func() (string, error)
 	serverURL    string
 	agentVersion string
 	queue        chan Event
 	httpClient   *http.Client
+	tokenFn      func(context.Context) (string, error)
+	serverURLFn  func() string
 	once         sync.Once
 	ctx          context.Context
 	cancel       context.CancelFunc
 }
 
 // New creates a new Client. Call Start() to begin background processing.
-func New(machineID, apiKey, serverURL, agentVersion string) *Client {
+// apiKey is called per upload when no token function is set, so keys from
+// a credential helper are never copied into the client.
+func New(machineID string, apiKey func() (string, error), serverURL, agentVersion string) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
 		machineID:    machineID,
-		apiKey:       apiKey,
+		apiKeyFn:     apiKey,
 		serverURL:    strings.TrimRight(serverURL, "/"),
 		agentVersion: agentVersion,
 		queue:        make(chan Event, 128),
@@ -60,6 +64,24 @@ func New(machineID, apiKey, serverURL, agentVersion string) *Client {
 	}
 }
 
+// SetTransport routes debug log uploads through rt (e.g. a proxy-aware
+// transport). Call before Start.
+func (c *Client) SetTransport(rt http.RoundTripper) {
+	c.httpClient.Transport = rt
+}
+
+// SetTokenFunc makes uploads authenticate with the connection's short-lived
+// session token instead of the API key. Call before Start.
+func (c *Client) SetTokenFunc(fn func(context.Context) (string, error)) {
+	c.tokenFn = fn
+}
+
+// SetServerURLFunc makes uploads follow the endpoint the connection is
+// currently using when several are configured. Call before Start.
+func (c *Client) SetServerURLFunc(fn func() string) {
+	c.serverURLFn = fn
+}
+
 // Start begins the background send goroutine. Safe to call multiple times.
 func (c *Client) Start() {
 	c.once.Do(func() {
@@ -158,13 +180,31 @@ func (c *Client) postOne(evt Event) {
 	if err != nil {
 		return
 	}
+	serverURL := c.serverURL
+	if c.serverURLFn != nil {
+		serverURL = strings.TrimRight(c.serverURLFn(), "/")
+	}
 	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost,
-		c.serverURL+"/api/agent/debug-log", bytes.NewReader(body))
+		serverURL+"/api/agent/debug-log", bytes.NewReader(body))
 	if err != nil {
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	var token string
+	if c.tokenFn != nil {
+		t, err := c.tokenFn(c.ctx)
+		if err != nil {
+			return // Token exchange failed — drop silently
+		}
+		token = t
+	} else {
+		k, err := c.apiKeyFn()
+		if err != nil {
+			return // No API key available — drop silently
+		}
+		token = k
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
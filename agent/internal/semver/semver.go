@@ -0,0 +1,168 @@
+// Package semver parses and orders semantic versions, as used by release
+// tags and the server's minimum agent version.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version (https://semver.org, 2.0.0).
+type Version struct {
+	Major, Minor, Patch uint64
+	// Prerelease holds the dot-separated identifiers after "-", e.g.
+	// ["beta", "2"] for 1.4.0-beta.2. Empty for a release.
+	Prerelease []string
+	// Build is the metadata after "+". It does not affect precedence.
+	Build string
+}
+
+// Parse parses a semantic version, optionally prefixed with "v".
+func Parse(s string) (Version, error) {
+	var v Version
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Build, false) {
+			return Version{}, fmt.Errorf("invalid version %q: bad build metadata", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		pre := rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(pre, true) {
+			return Version{}, fmt.Errorf("invalid version %q: bad prerelease", s)
+		}
+		v.Prerelease = strings.Split(pre, ".")
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid version %q: want MAJOR.MINOR.PATCH", s)
+	}
+	nums := [3]*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, ok := parseNumeric(p)
+		if !ok {
+			return Version{}, fmt.Errorf("invalid version %q: %q is not a number", s, p)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// parseNumeric parses a numeric identifier, which may not have leading zeros.
+func parseNumeric(s string) (uint64, bool) {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
+// validIdentifiers checks dot-separated identifiers of [0-9A-Za-z-]. In a
+// prerelease, numeric identifiers may not have leading zeros.
+func validIdentifiers(s string, prerelease bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+		if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// String formats v with a "v" prefix, as release tags are written.
+func (v Version) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 as v has lower, equal or higher precedence
+// than o. A prerelease sorts before its release; build metadata is ignored.
+func (v Version) Compare(o Version) int {
+	for _, d := range [3][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if d[0] != d[1] {
+			return cmpUint(d[0], d[1])
+		}
+	}
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmpUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+// compareIdentifier orders prerelease identifiers: numeric ones compare as
+// numbers and sort before alphanumeric ones, which compare in ASCII order.
+func compareIdentifier(a, b string) int {
+	an, bn := isNumeric(a), isNumeric(b)
+	switch {
+	case an && bn:
+		x, _ := strconv.ParseUint(a, 10, 64)
+		y, _ := strconv.ParseUint(b, 10, 64)
+		return cmpUint(x, y)
+	case an:
+		return -1
+	case bn:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// IsNewer reports whether other has higher precedence than current.
+// Versions that do not parse, such as development builds, are never
+// considered newer or older.
+func IsNewer(current, other string) bool {
+	cur, err := Parse(current)
+	if err != nil {
+		return false
+	}
+	o, err := Parse(other)
+	if err != nil {
+		return false
+	}
+	return o.Compare(cur) > 0
+}
@@ -0,0 +1,71 @@
+package semver
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	v, err := Parse("v1.10.2-beta.3+build.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Major != 1 || v.Minor != 10 || v.Patch != 2 || len(v.Prerelease) != 2 || v.Build != "build.7" {
+		t.Fatalf("Parse = %+v", v)
+	}
+	if v.String() != "v1.10.2-beta.3+build.7" {
+		t.Errorf("String() = %s", v)
+	}
+	for _, bad := range []string{"", "dev", "1.2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-beta..1", "1.2.3-01", "1.2.3+", "1.2.3-be_ta"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) accepted", bad)
+		}
+	}
+}
+
+func TestVersionPrecedence(t *testing.T) {
+	// The ordering example from the semver specification, plus the
+	// multi-digit case a string comparison gets wrong.
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0",
+		"1.9.0", "1.10.0", "2.0.0",
+	}
+	shuffled := append([]string(nil), ordered...)
+	sort.Sort(sort.Reverse(sort.StringSlice(shuffled)))
+	sort.Slice(shuffled, func(i, j int) bool {
+		a, _ := Parse(shuffled[i])
+		b, _ := Parse(shuffled[j])
+		return a.Compare(b) < 0
+	})
+	for i := range ordered {
+		if shuffled[i] != ordered[i] {
+			t.Fatalf("sorted = %v\nwant     %v", shuffled, ordered)
+		}
+	}
+
+	a, _ := Parse("1.0.0+a")
+	b, _ := Parse("v1.0.0+b")
+	if a.Compare(b) != 0 {
+		t.Error("build metadata affected precedence")
+	}
+}
+
+func TestIsNewer(t *testing.T) {
+	cases := []struct {
+		cur, latest string
+		want        bool
+	}{
+		{"1.9.0", "v1.10.0", true},
+		{"v1.10.0", "1.9.0", false},
+		{"1.2.0", "1.2.0", false},
+		{"1.2.0-rc.1", "1.2.0", true},
+		{"1.2.0", "1.2.1-beta.1", true},
+		{"dev", "1.2.0", false},
+	}
+	for _, c := range cases {
+		if got := IsNewer(c.cur, c.latest); got != c.want {
+			t.Errorf("IsNewer(%q, %q) = %v, want %v", c.cur, c.latest, got, c.want)
+		}
+	}
+}
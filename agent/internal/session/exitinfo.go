@@ -0,0 +1,164 @@
+package session
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultExitTailLines is how many trailing output lines are attached to
+// session_stopped / session_crashed when exit_tail_lines is not configured.
+const defaultExitTailLines = 20
+
+// maxTailLineBytes bounds a single buffered line so a process that never
+// prints a newline cannot grow the tail buffer without limit.
+const maxTailLineBytes = 512
+
+// exitInfo describes how a session's process ended. Fields other than Code
+// and Err are best-effort and left zero where the platform does not report them.
+type exitInfo struct {
+	// Code is the exit status, or -1 if the process was killed by a signal
+	// or the status could not be determined.
+	Code int
+	// Err is non-nil when waiting for the process failed.
+	Err error
+	// Signal is the name of the signal that killed the process (e.g. "SIGSEGV").
+	Signal     string
+	CoreDumped bool
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// PeakRSS is the maximum resident set size in bytes.
+	PeakRSS int64
+}
+
+// exitDetails is the rich exit information embedded in session_stopped and
+// session_crashed.
+type exitDetails struct {
+	Signal       string   `json:"signal,omitempty"`
+	CoreDumped   bool     `json:"coreDumped,omitempty"`
+	DurationMs   int64    `json:"durationMs"`
+	UserCPUMs    int64    `json:"userCpuMs,omitempty"`
+	SystemCPUMs  int64    `json:"systemCpuMs,omitempty"`
+	PeakRSSBytes int64    `json:"peakRssBytes,omitempty"`
+	OutputTail   []string `json:"outputTail,omitempty"`
+}
+
+// details converts info into its wire form for a run that lasted duration.
+func (info exitInfo) details(duration time.Duration, tail []string) exitDetails {
+	return exitDetails{
+		Signal:       info.Signal,
+		CoreDumped:   info.CoreDumped,
+		DurationMs:   duration.Milliseconds(),
+		UserCPUMs:    info.UserTime.Milliseconds(),
+		SystemCPUMs:  info.SystemTime.Milliseconds(),
+		PeakRSSBytes: info.PeakRSS,
+		OutputTail:   tail,
+	}
+}
+
+// outputTail keeps the last N lines of a session's output with terminal
+// escape sequences removed, so crash reports carry readable context.
+type outputTail struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+	cur   []byte
+	state int // ANSI parser state, see write
+}
+
+// ANSI parser states for outputTail.write.
+const (
+	tailText = iota
+	tailEsc  // after ESC
+	tailCSI  // inside ESC [ ... final byte
+	tailOSC  // inside ESC ] ... BEL or ESC \
+	tailOSCEsc
+)
+
+func newOutputTail(max int) *outputTail {
+	return &outputTail{max: max}
+}
+
+// write feeds raw PTY output into the tail. It is safe to call with
+// arbitrary chunk boundaries, including in the middle of an escape sequence.
+func (t *outputTail) write(p []byte) {
+	if t == nil || t.max <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range p {
+		switch t.state {
+		case tailEsc:
+			switch b {
+			case '[':
+				t.state = tailCSI
+			case ']':
+				t.state = tailOSC
+			default:
+				t.state = tailText
+			}
+			continue
+		case tailCSI:
+			if b >= 0x40 && b <= 0x7e {
+				t.state = tailText
+			}
+			continue
+		case tailOSC:
+			if b == 0x07 {
+				t.state = tailText
+			} else if b == 0x1b {
+				t.state = tailOSCEsc
+			}
+			continue
+		case tailOSCEsc:
+			t.state = tailText
+			continue
+		}
+
+		switch {
+		case b == 0x1b:
+			t.state = tailEsc
+		case b == '\n':
+			t.pushLine()
+		case b == '\t' || b >= 0x20:
+			if len(t.cur) < maxTailLineBytes {
+				t.cur = append(t.cur, b)
+			}
+		}
+	}
+}
+
+// pushLine moves the current line into the ring. Caller holds t.mu.
+func (t *outputTail) pushLine() {
+	line := strings.TrimRight(string(t.cur), " \t")
+	t.cur = t.cur[:0]
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+// snapshot returns the buffered lines, including a trailing partial line,
+// with leading and trailing blank lines dropped.
+func (t *outputTail) snapshot() []string {
+	if t == nil || t.max <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := append([]string(nil), t.lines...)
+	if partial := strings.TrimRight(string(t.cur), " \t"); partial != "" {
+		lines = append(lines, partial)
+		if len(lines) > t.max {
+			lines = lines[len(lines)-t.max:]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
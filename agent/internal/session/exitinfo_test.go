@@ -0,0 +1,56 @@
+package session
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputTail_StripsEscapes(t *testing.T) {
+	tail := newOutputTail(5)
+	tail.write([]byte("\x1b[1;32mok\x1b[0m done\r\n\x1b]0;title\x07next\n"))
+	got := tail.snapshot()
+	want := []string{"ok done", "next"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot = %q, want %q", got, want)
+	}
+}
+
+func TestOutputTail_SplitEscapeAcrossWrites(t *testing.T) {
+	tail := newOutputTail(5)
+	for _, chunk := range []string{"a\x1b", "[3", "1mb", "\x1b]2;x\x1b", "\\c"} {
+		tail.write([]byte(chunk))
+	}
+	if got := tail.snapshot(); !reflect.DeepEqual(got, []string{"abc"}) {
+		t.Fatalf("snapshot = %q, want [abc]", got)
+	}
+}
+
+func TestOutputTail_KeepsLastLines(t *testing.T) {
+	tail := newOutputTail(3)
+	for i := 0; i < 10; i++ {
+		tail.write([]byte{byte('0' + i), '\n'})
+	}
+	tail.write([]byte("partial"))
+	want := []string{"8", "9", "partial"}
+	if got := tail.snapshot(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot = %q, want %q", got, want)
+	}
+}
+
+func TestOutputTail_BoundsLongLines(t *testing.T) {
+	tail := newOutputTail(2)
+	tail.write([]byte(strings.Repeat("x", 4*maxTailLineBytes)))
+	got := tail.snapshot()
+	if len(got) != 1 || len(got[0]) != maxTailLineBytes {
+		t.Fatalf("long line not bounded: %d lines", len(got))
+	}
+}
+
+func TestOutputTail_Disabled(t *testing.T) {
+	tail := newOutputTail(-1)
+	tail.write([]byte("hello\n"))
+	if got := tail.snapshot(); got != nil {
+		t.Fatalf("disabled tail returned %q", got)
+	}
+}
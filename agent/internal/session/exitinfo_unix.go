@@ -0,0 +1,39 @@
+//go:build !windows
+
+package session
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// exitInfoFromState extracts the exit status, terminating signal and
+// rusage of a finished process.
+func exitInfoFromState(state *os.ProcessState) exitInfo {
+	if state == nil {
+		return exitInfo{Code: -1}
+	}
+	info := exitInfo{
+		Code:       state.ExitCode(),
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		info.Signal = unix.SignalName(ws.Signal())
+		if info.Signal == "" {
+			info.Signal = ws.Signal().String()
+		}
+		info.CoreDumped = ws.CoreDump()
+	}
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok && ru != nil {
+		// ru_maxrss is reported in kilobytes on Linux and bytes on macOS.
+		info.PeakRSS = int64(ru.Maxrss)
+		if runtime.GOOS != "darwin" {
+			info.PeakRSS *= 1024
+		}
+	}
+	return info
+}
@@ -0,0 +1,307 @@
+package session
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+)
+
+// limitCheckInterval is how often the watchdog evaluates idle_timeout and
+// max_runtime. A variable so tests can shorten it.
+var limitCheckInterval = 10 * time.Second
+
+const (
+	defaultTimeoutWarning = 5 * time.Minute
+	// limitStopGrace is how long a session stopped by the agent (timeout or
+	// cloud-requested termination) gets to exit after SIGTERM before it is killed.
+	limitStopGrace = 15 * time.Second
+)
+
+// Limit reasons reported in session_timeout_warning / session_timeout.
+const (
+	limitIdle       = "idle"
+	limitMaxRuntime = "max_runtime"
+)
+
+// sessionTimeoutMsg is sent as 'session_timeout_warning' before a limit
+// fires and as 'session_timeout' when the action is taken.
+type sessionTimeoutMsg struct {
+	Type             string `json:"type"`
+	SessionID        string `json:"sessionId"`
+	Reason           string `json:"reason"`
+	Action           string `json:"action"`
+	RemainingSeconds int    `json:"remainingSeconds"`
+}
+
+// sessionLimits is the resolved set of limits for one session.
+type sessionLimits struct {
+	idleTimeout time.Duration
+	maxRuntime  time.Duration
+	warning     time.Duration
+	action      string
+}
+
+// resolveLimits merges limit layers from lowest to highest precedence
+// (global, profile, start_session). A non-zero field overrides the layers
+// below it; a negative value disables that limit.
+func resolveLimits(layers ...config.SessionLimits) sessionLimits {
+	var merged config.SessionLimits
+	for _, l := range layers {
+		if l.IdleTimeout != 0 {
+			merged.IdleTimeout = l.IdleTimeout
+		}
+		if l.MaxRuntime != 0 {
+			merged.MaxRuntime = l.MaxRuntime
+		}
+		if l.TimeoutAction != "" {
+			merged.TimeoutAction = l.TimeoutAction
+		}
+		if l.TimeoutWarning != 0 {
+			merged.TimeoutWarning = l.TimeoutWarning
+		}
+	}
+
+	sl := sessionLimits{
+		action:  config.TimeoutActionStop,
+		warning: defaultTimeoutWarning,
+	}
+	if merged.IdleTimeout > 0 {
+		sl.idleTimeout = time.Duration(merged.IdleTimeout) * time.Minute
+	}
+	if merged.MaxRuntime > 0 {
+		sl.maxRuntime = time.Duration(merged.MaxRuntime) * time.Minute
+	}
+	if strings.EqualFold(merged.TimeoutAction, config.TimeoutActionPause) {
+		sl.action = config.TimeoutActionPause
+	}
+	if merged.TimeoutWarning > 0 {
+		sl.warning = time.Duration(merged.TimeoutWarning) * time.Minute
+	} else if merged.TimeoutWarning < 0 {
+		sl.warning = 0
+	}
+	return sl
+}
+
+// warnAt returns how long before limit the warning fires: the configured
+// warning, but never more than half the limit.
+func (sl sessionLimits) warnAt(limit time.Duration) time.Duration {
+	if sl.warning > limit/2 {
+		return limit / 2
+	}
+	return sl.warning
+}
+
+// touch records PTY activity for the idle timeout.
+func (s *Session) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// recordOutput notes a base64 output chunk for the idle timeout and the
+// exit output tail.
+func (s *Session) recordOutput(data string) {
+	s.touch()
+	if s.tail == nil {
+		return
+	}
+	if raw, err := base64.StdEncoding.DecodeString(data); err == nil {
+		s.tail.write(raw)
+	}
+}
+
+// State returns "starting", "restarting", "paused" or "running".
+func (s *Session) State() string {
+	s.mu.Lock()
+	handle, pid := s.ptySession, s.pid
+	s.mu.Unlock()
+	switch {
+	case handle == nil:
+		return "starting"
+	case pid == 0:
+		return "restarting"
+	case s.paused.Load():
+		return "paused"
+	default:
+		return "running"
+	}
+}
+
+// IdleRemaining returns the time left before the idle timeout fires. The
+// second result is false when no idle timeout applies to the session.
+func (s *Session) IdleRemaining(now time.Time) (time.Duration, bool) {
+	if s.limits.idleTimeout == 0 || s.paused.Load() {
+		return 0, false
+	}
+	last := time.Unix(0, s.lastActivity.Load())
+	return s.limits.idleTimeout - now.Sub(last), true
+}
+
+// RuntimeRemaining returns the time left before max_runtime fires. The
+// second result is false when no runtime limit applies (or it already fired).
+func (s *Session) RuntimeRemaining(now time.Time) (time.Duration, bool) {
+	if s.limits.maxRuntime == 0 || s.runtimeDone.Load() {
+		return 0, false
+	}
+	return s.limits.maxRuntime - now.Sub(s.StartedAt), true
+}
+
+// watchLimits enforces idle_timeout and max_runtime until the manager's
+// context is cancelled.
+func (m *Manager) watchLimits() {
+	ticker := time.NewTicker(limitCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, s := range m.registry.GetAll() {
+				m.checkLimits(s, now)
+			}
+		}
+	}
+}
+
+// checkLimits warns about or enforces the limits of one session.
+func (m *Manager) checkLimits(s *Session, now time.Time) {
+	if s.State() != "running" || s.stopRequested.Load() {
+		return
+	}
+
+	if remaining, ok := s.RuntimeRemaining(now); ok {
+		if remaining <= 0 {
+			s.runtimeDone.Store(true)
+			m.enforceLimit(s, limitMaxRuntime)
+			return
+		}
+		if remaining <= s.limits.warnAt(s.limits.maxRuntime) && !s.runtimeWarned {
+			s.runtimeWarned = true
+			m.warnLimit(s, limitMaxRuntime, remaining)
+		}
+	}
+
+	if remaining, ok := s.IdleRemaining(now); ok {
+		if remaining <= 0 {
+			s.idleWarned = false
+			m.enforceLimit(s, limitIdle)
+			return
+		}
+		if remaining > s.limits.warnAt(s.limits.idleTimeout) {
+			// Activity since the last warning re-arms it.
+			s.idleWarned = false
+		} else if !s.idleWarned {
+			s.idleWarned = true
+			m.warnLimit(s, limitIdle, remaining)
+		}
+	}
+}
+
+// warnLimit tells the cloud and anyone watching the terminal that a limit
+// is about to fire.
+func (m *Manager) warnLimit(s *Session, reason string, remaining time.Duration) {
+	remaining = remaining.Round(time.Second)
+	m.logger.Info("session limit approaching", "sessionId", s.ID, "reason", reason,
+		"action", s.limits.action, "remaining", remaining)
+
+	msg := sessionTimeoutMsg{
+		Type:             "session_timeout_warning",
+		SessionID:        s.ID,
+		Reason:           reason,
+		Action:           s.limits.action,
+		RemainingSeconds: int(remaining.Seconds()),
+	}
+	if err := m.messenger.SendJSON(msg); err != nil {
+		m.logger.Warn("failed to send session_timeout_warning", "sessionId", s.ID, "err", err)
+	}
+
+	what := "has been idle"
+	if reason == limitMaxRuntime {
+		what = "is approaching its maximum runtime"
+	}
+	hint := ""
+	if reason == limitIdle {
+		hint = " unless there is activity"
+	}
+	m.writeNotice(s, fmt.Sprintf("This session %s; it will be %s in %s%s.",
+		what, actionPastTense(s.limits.action), remaining, hint))
+}
+
+// enforceLimit pauses or stops a session whose limit has expired. Pausing
+// falls back to stopping where it is unsupported (Windows).
+func (m *Manager) enforceLimit(s *Session, reason string) {
+	action := s.limits.action
+	m.logger.Warn("session limit reached", "sessionId", s.ID, "reason", reason, "action", action)
+	if m.debugLog != nil {
+		m.debugLog.Info("session_timeout", "Session limit reached", map[string]any{
+			"sessionId": s.ID,
+			"reason":    reason,
+			"action":    action,
+		})
+	}
+
+	if action == config.TimeoutActionPause {
+		m.writeNotice(s, fmt.Sprintf("Session paused (%s limit reached). Resume it from the dashboard.", reason))
+		if err := m.Pause(s.ID); err != nil {
+			m.logger.Warn("pause on timeout failed, stopping instead", "sessionId", s.ID, "err", err)
+			action = config.TimeoutActionStop
+		}
+	}
+
+	_ = m.messenger.SendJSON(sessionTimeoutMsg{
+		Type:      "session_timeout",
+		SessionID: s.ID,
+		Reason:    reason,
+		Action:    action,
+	})
+
+	if action != config.TimeoutActionStop {
+		return
+	}
+	m.writeNotice(s, fmt.Sprintf("Session stopped (%s limit reached).", reason))
+	m.stopWithGrace(s)
+}
+
+// stopWithGrace asks a session to stop and kills it if it is still running
+// after limitStopGrace.
+func (m *Manager) stopWithGrace(s *Session) {
+	handle := s.handle()
+	if err := m.Stop(s.ID, false); err != nil {
+		m.logger.Warn("stop failed", "sessionId", s.ID, "err", err)
+	}
+	if handle == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-time.After(limitStopGrace):
+		case <-m.ctx.Done():
+			return
+		}
+		if cur, err := m.registry.Get(s.ID); err == nil && cur.handle() == handle {
+			_ = handle.stop(true)
+		}
+	}()
+}
+
+// writeNotice injects an agent notice into the session's output stream so it
+// appears in every attached terminal without being typed into the process.
+func (m *Manager) writeNotice(s *Session, text string) {
+	line := "\r\n[sessionforge] " + text + "\r\n"
+	msg := sessionOutputMsg{
+		Type:      "session_output",
+		SessionID: s.ID,
+		Data:      base64.StdEncoding.EncodeToString([]byte(line)),
+	}
+	if err := m.messenger.SendJSON(msg); err != nil {
+		m.logger.Warn("failed to send session notice", "sessionId", s.ID, "err", err)
+	}
+}
+
+func actionPastTense(action string) string {
+	if action == config.TimeoutActionPause {
+		return "paused"
+	}
+	return "stopped"
+}
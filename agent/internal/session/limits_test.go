@@ -0,0 +1,49 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+)
+
+func TestResolveLimits_Layering(t *testing.T) {
+	global := config.SessionLimits{IdleTimeout: 60, MaxRuntime: 480}
+	profile := config.SessionLimits{IdleTimeout: 30, TimeoutAction: "pause"}
+	request := config.SessionLimits{MaxRuntime: -1, TimeoutWarning: 2}
+
+	sl := resolveLimits(global, profile, request)
+	if sl.idleTimeout != 30*time.Minute {
+		t.Errorf("idleTimeout = %v, want 30m from profile", sl.idleTimeout)
+	}
+	if sl.maxRuntime != 0 {
+		t.Errorf("maxRuntime = %v, want disabled by request", sl.maxRuntime)
+	}
+	if sl.action != config.TimeoutActionPause {
+		t.Errorf("action = %q, want pause", sl.action)
+	}
+	if sl.warning != 2*time.Minute {
+		t.Errorf("warning = %v, want 2m", sl.warning)
+	}
+}
+
+func TestResolveLimits_Defaults(t *testing.T) {
+	sl := resolveLimits(config.SessionLimits{})
+	if sl.idleTimeout != 0 || sl.maxRuntime != 0 {
+		t.Fatalf("no limits expected, got %+v", sl)
+	}
+	if sl.action != config.TimeoutActionStop || sl.warning != defaultTimeoutWarning {
+		t.Fatalf("defaults not applied: %+v", sl)
+	}
+}
+
+func TestSessionLimits_WarnAtCappedAtHalf(t *testing.T) {
+	sl := resolveLimits(config.SessionLimits{IdleTimeout: 4})
+	if got := sl.warnAt(sl.idleTimeout); got != 2*time.Minute {
+		t.Fatalf("warnAt(4m) = %v, want 2m", got)
+	}
+	sl = resolveLimits(config.SessionLimits{IdleTimeout: 60})
+	if got := sl.warnAt(sl.idleTimeout); got != defaultTimeoutWarning {
+		t.Fatalf("warnAt(60m) = %v, want %v", got, defaultTimeoutWarning)
+	}
+}
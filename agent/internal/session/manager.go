@@ -7,9 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sessionforge/agent/internal/config"
 	"github.com/sessionforge/agent/internal/debuglog"
 )
 
@@ -35,18 +37,36 @@ type sessionInfoJSON struct {
 	Name        string `json:"name,omitempty"`
 }
 
+// info returns the wire form of s used by session_started and sync_state.
+func (s *Session) info() sessionInfoJSON {
+	return sessionInfoJSON{
+		ID:          s.ID,
+		PID:         s.PID(),
+		ProcessName: s.ProcessName,
+		Workdir:     s.Workdir,
+		StartedAt:   s.StartedAt.UTC().Format(time.RFC3339),
+		Name:        s.Name,
+	}
+}
+
+// The exit messages carry their own eventId so that a copy resent by
+// Reconcile can be recognised by the server as the same event.
 type sessionStoppedMsg struct {
+	EventID              string `json:"eventId,omitempty"`
 	Type                 string `json:"type"`
 	SessionID            string `json:"sessionId"`
 	ExitCode             *int   `json:"exitCode"`
 	ClaudeConversationID string `json:"claudeConversationId,omitempty"`
+	exitDetails
 }
 
 type sessionCrashedMsg struct {
+	EventID              string `json:"eventId,omitempty"`
 	Type                 string `json:"type"`
 	SessionID            string `json:"sessionId"`
 	Error                string `json:"error"`
 	ClaudeConversationID string `json:"claudeConversationId,omitempty"`
+	exitDetails
 }
 
 type sessionOutputMsg struct {
@@ -71,23 +91,84 @@ type Manager struct {
 	logger          *slog.Logger
 	claudeConfigDir string // injected as CLAUDE_CONFIG_DIR into every PTY session
 	debugLog        *debuglog.Client
+
+	// settingsMu guards claudeConfigDir and the defaults below, which a
+	// config reload or config_update may change while sessions start.
+	settingsMu     sync.RWMutex
+	defaultRestart config.RestartPolicy
+	defaultLimits  config.SessionLimits
+	profiles       map[string]config.SessionProfile
+	exitTailLines  int
+	commands       commandPolicy
+
+	// Exits buffered for the next sync_state; see sync.go.
+	endedMu    sync.Mutex
+	ended      []endedSession
+	syncSentAt time.Time
 }
 
 // NewManager creates a new Manager.
 func NewManager(ctx context.Context, messenger AgentMessenger, logger *slog.Logger) *Manager {
 	SetConPTYLogger(logger)
-	return &Manager{
+	m := &Manager{
 		registry:  NewRegistry(),
 		messenger: messenger,
 		ctx:       ctx,
 		logger:    logger,
 	}
+	go m.watchLimits()
+	return m
 }
 
 // SetClaudeConfigDir stores the path to inject as CLAUDE_CONFIG_DIR in every
 // spawned session. Call this after loading config if cfg.ClaudeConfigDir is set.
 func (m *Manager) SetClaudeConfigDir(dir string) {
+	m.settingsMu.Lock()
 	m.claudeConfigDir = dir
+	m.settingsMu.Unlock()
+}
+
+// SetDefaultRestartPolicy sets the restart policy used when neither the
+// start_session message nor its profile specifies one.
+func (m *Manager) SetDefaultRestartPolicy(p config.RestartPolicy) {
+	m.settingsMu.Lock()
+	m.defaultRestart = p
+	m.settingsMu.Unlock()
+}
+
+// SetDefaultLimits sets the global idle_timeout / max_runtime settings for
+// sessions started from the cloud.
+func (m *Manager) SetDefaultLimits(l config.SessionLimits) {
+	m.settingsMu.Lock()
+	m.defaultLimits = l
+	m.settingsMu.Unlock()
+}
+
+// SetExitTailLines sets how many trailing output lines are attached to
+// session_stopped / session_crashed. 0 selects the default; negative disables.
+func (m *Manager) SetExitTailLines(n int) {
+	m.settingsMu.Lock()
+	m.exitTailLines = n
+	m.settingsMu.Unlock()
+}
+
+// newTail returns an output tail sized by SetExitTailLines.
+func (m *Manager) newTail() *outputTail {
+	m.settingsMu.RLock()
+	n := m.exitTailLines
+	m.settingsMu.RUnlock()
+	if n == 0 {
+		n = defaultExitTailLines
+	}
+	return newOutputTail(n)
+}
+
+// SetSessionProfiles stores the named session templates that start_session
+// messages may reference.
+func (m *Manager) SetSessionProfiles(profiles map[string]config.SessionProfile) {
+	m.settingsMu.Lock()
+	m.profiles = profiles
+	m.settingsMu.Unlock()
 }
 
 // SetDebugLogger wires a debug log client into the manager and the package-level
@@ -99,17 +180,25 @@ func (m *Manager) SetDebugLogger(dl *debuglog.Client) {
 
 // mergeEnv returns a copy of env with CLAUDE_CONFIG_DIR injected if configured.
 func (m *Manager) mergeEnv(env map[string]string) map[string]string {
-	if m.claudeConfigDir == "" {
+	claudeConfigDir := m.claudeDir()
+	if claudeConfigDir == "" {
 		return env
 	}
 	merged := make(map[string]string, len(env)+1)
 	for k, v := range env {
 		merged[k] = v
 	}
-	merged["CLAUDE_CONFIG_DIR"] = m.claudeConfigDir
+	merged["CLAUDE_CONFIG_DIR"] = claudeConfigDir
 	return merged
 }
 
+// claudeDir returns the configured Claude config directory.
+func (m *Manager) claudeDir() string {
+	m.settingsMu.RLock()
+	defer m.settingsMu.RUnlock()
+	return m.claudeConfigDir
+}
+
 // Start spawns a new PTY session and sends a 'session_started' message.
 // requestId is echoed back so the cloud can correlate the response.
 // sessionID is the cloud-assigned session ID; if empty, a new UUID is generated.
@@ -152,17 +241,49 @@ func userHomeFromConfig(claudeConfigDir string) string {
 	return home
 }
 
-func (m *Manager) Start(requestID, sessionID, command, workdir string, env map[string]string) (string, error) {
+func (m *Manager) Start(requestID, sessionID, command, workdir string, env map[string]string, opts config.SessionOptions) (string, error) {
 	if sessionID == "" {
 		sessionID = uuid.New().String()
 	}
-	workdir = sanitizeWorkdir(workdir, userHomeFromConfig(m.claudeConfigDir))
+
+	m.settingsMu.RLock()
+	policy := m.defaultRestart
+	defaultLimits := m.defaultLimits
+	profile, ok := m.profiles[opts.Profile]
+	m.settingsMu.RUnlock()
+
+	var profileLimits config.SessionLimits
+	if opts.Profile != "" {
+		if !ok {
+			return "", fmt.Errorf("unknown session profile %q", opts.Profile)
+		}
+		if command == "" {
+			command = profile.Command
+		}
+		if workdir == "" || workdir == "." {
+			workdir = profile.Workdir
+		}
+		env = mergeMaps(profile.Env, env)
+		if profile.Restart != nil {
+			policy = *profile.Restart
+		}
+		profileLimits = profile.SessionLimits
+	}
+	if opts.Restart != nil {
+		policy = *opts.Restart
+	}
+	if command == "" {
+		command = "claude"
+	}
+	workdir = sanitizeWorkdir(workdir, userHomeFromConfig(m.claudeDir()))
 
 	m.logger.Info("starting session",
 		"sessionId", sessionID,
 		"requestId", requestID,
 		"command", command,
 		"workdir", workdir,
+		"profile", opts.Profile,
+		"restart", policy.Mode,
 	)
 	if m.debugLog != nil {
 		m.debugLog.Info("session_start", "Session starting", map[string]any{
@@ -172,58 +293,6 @@ func (m *Manager) Start(requestID, sessionID, command, workdir string, env map[s
 		})
 	}
 
-	outputFn := func(sid, data string) {
-		m.logger.Debug("session_output chunk", "sessionId", sid, "bytes", len(data))
-		msg := sessionOutputMsg{
-			Type:      "session_output",
-			SessionID: sid,
-			Data:      data,
-		}
-		if err := m.messenger.SendJSON(msg); err != nil {
-			m.logger.Warn("failed to send session_output", "sessionId", sid, "err", err)
-		}
-	}
-
-	exitFn := func(sid string, exitCode int, exitErr error) {
-		m.logger.Info("session exited", "sessionId", sid, "exitCode", exitCode, "err", exitErr)
-		if m.debugLog != nil {
-			m.debugLog.Info("session_exit", "Session exited", map[string]any{
-				"sessionId": sid,
-				"exitCode":  exitCode,
-			})
-		}
-		m.registry.Remove(sid)
-
-		convID := findClaudeConversationID(m.claudeConfigDir, workdir)
-		if convID != "" {
-			m.logger.Info("resolved claude conversation ID", "sessionId", sid, "conversationId", convID)
-		}
-
-		if exitErr != nil {
-			msg := sessionCrashedMsg{
-				Type:                 "session_crashed",
-				SessionID:            sid,
-				Error:                exitErr.Error(),
-				ClaudeConversationID: convID,
-			}
-			if err := m.messenger.SendJSON(msg); err != nil {
-				m.logger.Warn("failed to send session_crashed", "err", err)
-			}
-			return
-		}
-
-		code := exitCode
-		msg := sessionStoppedMsg{
-			Type:                 "session_stopped",
-			SessionID:            sid,
-			ExitCode:             &code,
-			ClaudeConversationID: convID,
-		}
-		if err := m.messenger.SendJSON(msg); err != nil {
-			m.logger.Warn("failed to send session_stopped", "err", err)
-		}
-	}
-
 	startedAt := time.Now().UTC()
 
 	// Register a placeholder session entry immediately so that heartbeats
@@ -231,12 +300,16 @@ func (m *Manager) Start(requestID, sessionID, command, workdir string, env map[s
 	// the ConPTY probe (which can block for several seconds) completes.
 	placeholder := &Session{
 		ID:          sessionID,
-		PID:         0, // unknown until after spawn
 		ProcessName: command,
 		Workdir:     workdir,
 		StartedAt:   startedAt,
 		Command:     command,
+		env:         m.mergeEnv(env),
+		restart:     newRestartPolicy(policy),
+		limits:      resolveLimits(defaultLimits, profileLimits, opts.Limits),
+		tail:        m.newTail(),
 	}
+	placeholder.touch()
 	m.registry.Add(placeholder)
 
 	// Send session_started immediately so the dashboard card appears.
@@ -258,27 +331,226 @@ func (m *Manager) Start(requestID, sessionID, command, workdir string, env map[s
 	// 40+ seconds as LocalSystem). Run it in a goroutine so Start() returns
 	// immediately and the WebSocket read loop is not blocked.
 	go func() {
-		m.logger.Info("manager: calling spawnPTY", "sessionId", sessionID, "command", command, "workdir", workdir)
-		handle, pid, err := spawnPTY(m.ctx, sessionID, command, workdir, m.mergeEnv(env), outputFn, nil, exitFn)
-		m.logger.Info("manager: spawnPTY returned", "sessionId", sessionID, "pid", pid, "err", err)
-		if err != nil {
-			m.logger.Error("spawnPTY failed", "sessionId", sessionID, "command", command, "workdir", workdir, "err", err)
-			m.registry.Remove(sessionID)
-			_ = m.messenger.SendJSON(sessionCrashedMsg{
-				Type:      "session_crashed",
-				SessionID: sessionID,
-				Error:     err.Error(),
-			})
-			return
+		if err := m.spawn(placeholder, command); err != nil {
+			m.handleExit(placeholder, exitInfo{Code: -1, Err: err})
 		}
-		// Update placeholder with real PID and PTY handle.
-		placeholder.PID = pid
-		placeholder.ptySession = handle
 	}()
 
 	return sessionID, nil
 }
 
+// spawn starts (or restarts) the process behind s through spawnPTY and
+// records the resulting PID and PTY handle on s.
+func (m *Manager) spawn(s *Session, command string) error {
+	outputFn := func(sid, data string) {
+		m.logger.Debug("session_output chunk", "sessionId", sid, "bytes", len(data))
+		s.recordOutput(data)
+		msg := sessionOutputMsg{
+			Type:      "session_output",
+			SessionID: sid,
+			Data:      data,
+		}
+		if err := m.messenger.SendJSON(msg); err != nil {
+			m.logger.Warn("failed to send session_output", "sessionId", sid, "err", err)
+		}
+	}
+
+	// A process that exits at once can call exitFn before spawnPTY returns;
+	// hold the exit back until the new PID and handle are recorded, or
+	// they would overwrite what handleExit cleared.
+	published := make(chan struct{})
+	defer close(published)
+	exitFn := func(_ string, info exitInfo) {
+		<-published
+		m.handleExit(s, info)
+	}
+
+	m.logger.Info("manager: calling spawnPTY", "sessionId", s.ID, "command", command, "workdir", s.Workdir)
+	s.mu.Lock()
+	s.lastSpawn = time.Now()
+	s.mu.Unlock()
+	handle, pid, err := spawnPTY(m.ctx, s.ID, command, s.Workdir, s.env, m.commandPolicy(), outputFn, nil, exitFn)
+	m.logger.Info("manager: spawnPTY returned", "sessionId", s.ID, "pid", pid, "err", err)
+	if err != nil {
+		m.logger.Error("spawnPTY failed", "sessionId", s.ID, "command", command, "workdir", s.Workdir, "err", err)
+		return err
+	}
+	// Update placeholder with real PID and PTY handle.
+	s.setProcess(handle, pid)
+	s.paused.Store(false)
+	// Stop may have been requested while the spawn was in flight.
+	if s.stopRequested.Load() {
+		_ = handle.stop(false)
+	}
+	return nil
+}
+
+// handleExit runs when a session's process exits or fails to spawn. It
+// either respawns the session according to its restart policy or removes it
+// and reports session_stopped / session_crashed to the cloud.
+func (m *Manager) handleExit(s *Session, info exitInfo) {
+	// lastSpawn is reset by a respawn; measure this run's duration first.
+	s.mu.Lock()
+	runTime := time.Since(s.lastSpawn)
+	s.mu.Unlock()
+restartLoop:
+	for {
+		m.logExit(s, info, runTime)
+
+		if convID := findClaudeConversationID(m.claudeDir(), s.Workdir); convID != "" {
+			m.logger.Info("resolved claude conversation ID", "sessionId", s.ID, "conversationId", convID)
+			s.mu.Lock()
+			s.conversationID = convID
+			s.mu.Unlock()
+		}
+
+		delay, attempt, ok := m.nextRestart(s, info.Code, info.Err)
+		if !ok {
+			break
+		}
+
+		m.logger.Info("restarting session", "sessionId", s.ID, "attempt", attempt, "delay", delay)
+		s.mu.Lock()
+		s.pid = 0
+		s.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-m.ctx.Done():
+			break restartLoop
+		}
+		if s.stopRequested.Load() {
+			break
+		}
+
+		convID := s.conversation()
+		if err := m.spawn(s, withResume(s.Command, convID)); err != nil {
+			info, runTime = exitInfo{Code: -1, Err: err}, 0
+			continue
+		}
+
+		msg := sessionRestartedMsg{
+			Type:                 "session_restarted",
+			SessionID:            s.ID,
+			Attempt:              attempt,
+			PID:                  s.PID(),
+			ClaudeConversationID: convID,
+		}
+		if info.Err != nil {
+			msg.PrevError = info.Err.Error()
+		} else {
+			code := info.Code
+			msg.PrevExitCode = &code
+		}
+		if err := m.messenger.SendJSON(msg); err != nil {
+			m.logger.Warn("failed to send session_restarted", "err", err)
+		}
+		return
+	}
+
+	m.registry.Remove(s.ID)
+	m.reportExit(s, info, runTime)
+}
+
+// logExit records a process exit in the local and debug logs.
+func (m *Manager) logExit(s *Session, info exitInfo, runTime time.Duration) {
+	m.logger.Info("session exited", "sessionId", s.ID, "exitCode", info.Code, "err", info.Err,
+		"signal", info.Signal, "coreDumped", info.CoreDumped, "duration", runTime.Round(time.Millisecond),
+		"userCpu", info.UserTime, "systemCpu", info.SystemTime, "peakRssBytes", info.PeakRSS)
+	if m.debugLog != nil {
+		m.debugLog.Info("session_exit", "Session exited", map[string]any{
+			"sessionId":  s.ID,
+			"exitCode":   info.Code,
+			"signal":     info.Signal,
+			"coreDumped": info.CoreDumped,
+			"durationMs": runTime.Milliseconds(),
+		})
+	}
+}
+
+// reportExit sends session_crashed (wait failed) or session_stopped with
+// the rich exit details for a session that will not be restarted.
+func (m *Manager) reportExit(s *Session, info exitInfo, runTime time.Duration) {
+	details := info.details(runTime, s.tail.snapshot())
+	ended := endedSession{
+		SessionID: s.ID,
+		Signal:    info.Signal,
+		EndedAt:   time.Now().UTC().Format(time.RFC3339),
+		at:        time.Now(),
+	}
+
+	if info.Err != nil {
+		msg := sessionCrashedMsg{
+			EventID:              uuid.NewString(),
+			Type:                 "session_crashed",
+			SessionID:            s.ID,
+			Error:                info.Err.Error(),
+			ClaudeConversationID: s.conversation(),
+			exitDetails:          details,
+		}
+		ended.Error, ended.msg = msg.Error, msg
+		m.recordEnded(ended)
+		if err := m.messenger.SendJSON(msg); err != nil {
+			m.logger.Warn("failed to send session_crashed", "err", err)
+		}
+		return
+	}
+
+	code := info.Code
+	msg := sessionStoppedMsg{
+		EventID:              uuid.NewString(),
+		Type:                 "session_stopped",
+		SessionID:            s.ID,
+		ExitCode:             &code,
+		ClaudeConversationID: s.conversation(),
+		exitDetails:          details,
+	}
+	ended.ExitCode, ended.msg = &code, msg
+	m.recordEnded(ended)
+	if err := m.messenger.SendJSON(msg); err != nil {
+		m.logger.Warn("failed to send session_stopped", "err", err)
+	}
+}
+
+// nextRestart decides whether s should be respawned after an exit and, if
+// so, increments its attempt counter and returns the backoff delay and the
+// attempt number.
+func (m *Manager) nextRestart(s *Session, exitCode int, exitErr error) (time.Duration, int, bool) {
+	if s.stopRequested.Load() || m.ctx.Err() != nil {
+		return 0, 0, false
+	}
+	rp := s.restart
+	if !rp.wantsRestart(exitCode, exitErr) {
+		return 0, 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A run that stayed up for the reset window counts as healthy.
+	if !s.lastSpawn.IsZero() && time.Since(s.lastSpawn) >= rp.resetAfter {
+		s.restartCount = 0
+	}
+	if rp.maxRetries > 0 && s.restartCount >= rp.maxRetries {
+		m.logger.Warn("restart limit reached, giving up", "sessionId", s.ID, "maxRetries", rp.maxRetries)
+		return 0, 0, false
+	}
+	s.restartCount++
+	return rp.delay(s.restartCount), s.restartCount, true
+}
+
+// mergeMaps returns base overlaid with overlay. Either may be nil.
+func mergeMaps(base, overlay map[string]string) map[string]string {
+	if len(base) == 0 {
+		return overlay
+	}
+	merged := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
+
 // StartWithLocalOutput is like Start but also streams raw PTY bytes to localFn.
 // Used by `sessionforge run` to display output in the local terminal simultaneously.
 // Returns the session ID, a channel that receives the child exit code when it exits, and any error.
@@ -290,7 +562,7 @@ func (m *Manager) StartWithLocalOutput(
 	if sessionID == "" {
 		sessionID = uuid.New().String()
 	}
-	workdir = sanitizeWorkdir(workdir, userHomeFromConfig(m.claudeConfigDir))
+	workdir = sanitizeWorkdir(workdir, userHomeFromConfig(m.claudeDir()))
 
 	exitCh := make(chan int, 1)
 
@@ -301,8 +573,24 @@ func (m *Manager) StartWithLocalOutput(
 		"workdir", workdir,
 	)
 
+	startedAt := time.Now().UTC()
+
+	// Register placeholder + send session_started before the ConPTY probe blocks.
+	placeholder := &Session{
+		ID:          sessionID,
+		ProcessName: command,
+		Workdir:     workdir,
+		StartedAt:   startedAt,
+		Command:     command,
+		Name:        name,
+		tail:        m.newTail(),
+	}
+	placeholder.touch()
+	m.registry.Add(placeholder)
+
 	outputFn := func(sid, data string) {
 		m.logger.Info("session_output chunk", "sessionId", sid, "bytes", len(data))
+		placeholder.recordOutput(data)
 		msg := sessionOutputMsg{
 			Type:      "session_output",
 			SessionID: sid,
@@ -313,13 +601,19 @@ func (m *Manager) StartWithLocalOutput(
 		}
 	}
 
-	exitFn := func(sid string, exitCode int, exitErr error) {
-		m.logger.Info("session exited", "sessionId", sid, "exitCode", exitCode, "err", exitErr)
+	// As in spawn, the exit waits until the PID and handle are recorded.
+	published := make(chan struct{})
+	exitFn := func(sid string, info exitInfo) {
+		<-published
+		placeholder.mu.Lock()
+		runTime := time.Since(placeholder.lastSpawn)
+		placeholder.mu.Unlock()
+		m.logExit(placeholder, info, runTime)
 		m.registry.Remove(sid)
 
 		// Signal the local run loop that the process has exited.
-		code := exitCode
-		if exitErr != nil {
+		code := info.Code
+		if info.Err != nil {
 			code = -1
 		}
 		select {
@@ -327,40 +621,8 @@ func (m *Manager) StartWithLocalOutput(
 		default:
 		}
 
-		if exitErr != nil {
-			msg := sessionCrashedMsg{
-				Type:      "session_crashed",
-				SessionID: sid,
-				Error:     exitErr.Error(),
-			}
-			if err := m.messenger.SendJSON(msg); err != nil {
-				m.logger.Warn("failed to send session_crashed", "err", err)
-			}
-			return
-		}
-
-		msg := sessionStoppedMsg{
-			Type:      "session_stopped",
-			SessionID: sid,
-			ExitCode:  &code,
-		}
-		if err := m.messenger.SendJSON(msg); err != nil {
-			m.logger.Warn("failed to send session_stopped", "err", err)
-		}
-	}
-
-	startedAt := time.Now().UTC()
-
-	// Register placeholder + send session_started before the ConPTY probe blocks.
-	placeholder := &Session{
-		ID:          sessionID,
-		PID:         0,
-		ProcessName: command,
-		Workdir:     workdir,
-		StartedAt:   startedAt,
-		Command:     command,
+		m.reportExit(placeholder, info, runTime)
 	}
-	m.registry.Add(placeholder)
 
 	earlyStarted := sessionStartedMsg{
 		Type: "session_started",
@@ -377,8 +639,12 @@ func (m *Manager) StartWithLocalOutput(
 		m.logger.Warn("failed to send early session_started", "err", err)
 	}
 
-	handle, pid, err := spawnPTY(m.ctx, sessionID, command, workdir, m.mergeEnv(env), outputFn, localFn, exitFn)
+	placeholder.mu.Lock()
+	placeholder.lastSpawn = time.Now()
+	placeholder.mu.Unlock()
+	handle, pid, err := spawnPTY(m.ctx, sessionID, command, workdir, m.mergeEnv(env), m.commandPolicy(), outputFn, localFn, exitFn)
 	if err != nil {
+		close(published)
 		m.registry.Remove(sessionID)
 		// earlyStarted was already sent — notify the cloud so the DB record is cleaned up.
 		_ = m.messenger.SendJSON(sessionCrashedMsg{
@@ -389,20 +655,34 @@ func (m *Manager) StartWithLocalOutput(
 		return "", nil, fmt.Errorf("spawn PTY: %w", err)
 	}
 
-	placeholder.PID = pid
-	placeholder.ptySession = handle
+	placeholder.setProcess(handle, pid)
+	close(published)
 
 	return sessionID, exitCh, nil
 }
 
+// running returns the session with the given ID if it has a live process.
+// Sessions that are still spawning or waiting to restart have no PTY yet.
+func (m *Manager) running(sessionID string) (*Session, error) {
+	s, err := m.registry.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if s.handle() == nil {
+		return nil, fmt.Errorf("session %s is not running yet", sessionID)
+	}
+	return s, nil
+}
+
 // WriteInputRaw forwards raw bytes to a session's PTY stdin without base64 encoding.
 // Used by `sessionforge run` for local stdin passthrough.
 func (m *Manager) WriteInputRaw(sessionID string, data []byte) error {
-	s, err := m.registry.Get(sessionID)
+	s, err := m.running(sessionID)
 	if err != nil {
 		return err
 	}
-	return s.ptySession.writeInputRaw(data)
+	s.touch()
+	return s.handle().writeInputRaw(data)
 }
 
 // Stop terminates a session. If force is true, the process is killed immediately.
@@ -412,45 +692,69 @@ func (m *Manager) Stop(sessionID string, force bool) error {
 		return err
 	}
 	m.logger.Info("stopping session", "sessionId", sessionID, "force", force)
-	return s.ptySession.stop(force)
+	s.stopRequested.Store(true)
+	h := s.handle()
+	if h == nil {
+		// Still spawning or waiting to restart; spawn/handleExit observe
+		// stopRequested and finish the stop.
+		return nil
+	}
+	return h.stop(force)
 }
 
 // Pause suspends a session (SIGSTOP on Unix).
 func (m *Manager) Pause(sessionID string) error {
-	s, err := m.registry.Get(sessionID)
+	s, err := m.running(sessionID)
 	if err != nil {
 		return err
 	}
 	m.logger.Info("pausing session", "sessionId", sessionID)
-	return s.ptySession.pause()
+	if err := s.handle().pause(); err != nil {
+		return err
+	}
+	s.paused.Store(true)
+	return nil
 }
 
 // Resume continues a paused session (SIGCONT on Unix).
 func (m *Manager) Resume(sessionID string) error {
-	s, err := m.registry.Get(sessionID)
+	s, err := m.running(sessionID)
 	if err != nil {
 		return err
 	}
 	m.logger.Info("resuming session", "sessionId", sessionID)
-	return s.ptySession.resume()
+	if err := s.handle().resume(); err != nil {
+		return err
+	}
+	s.paused.Store(false)
+	// Resuming counts as activity so an idle-paused session is not paused
+	// again on the next watchdog tick.
+	s.touch()
+	return nil
 }
 
 // WriteInput forwards base64-encoded input bytes to a session's PTY stdin.
 func (m *Manager) WriteInput(sessionID, data string) error {
-	s, err := m.registry.Get(sessionID)
+	s, err := m.running(sessionID)
 	if err != nil {
 		return err
 	}
-	return s.ptySession.writeInput(data)
+	s.touch()
+	return s.handle().writeInput(data)
 }
 
 // Resize adjusts the PTY dimensions for a session.
 func (m *Manager) Resize(sessionID string, cols, rows uint16) error {
-	s, err := m.registry.Get(sessionID)
+	s, err := m.running(sessionID)
 	if err != nil {
 		return err
 	}
-	return s.ptySession.resize(cols, rows)
+	return s.handle().resize(cols, rows)
+}
+
+// Get returns the active session with the given ID.
+func (m *Manager) Get(sessionID string) (*Session, error) {
+	return m.registry.Get(sessionID)
 }
 
 // GetAll returns a snapshot of all active sessions.
@@ -469,14 +773,8 @@ func (m *Manager) ReplayToCloud() {
 	all := m.registry.GetAll()
 	for _, s := range all {
 		msg := sessionStartedMsg{
-			Type: "session_started",
-			Session: sessionInfoJSON{
-				ID:          s.ID,
-				PID:         s.PID,
-				ProcessName: s.ProcessName,
-				Workdir:     s.Workdir,
-				StartedAt:   s.StartedAt.UTC().Format(time.RFC3339),
-			},
+			Type:    "session_started",
+			Session: s.info(),
 		}
 		if err := m.messenger.SendJSON(msg); err != nil {
 			m.logger.Warn("replay: failed to send session_started", "sessionId", s.ID, "err", err)
@@ -492,8 +790,8 @@ func (m *Manager) ManagedPIDs() map[int32]bool {
 	all := m.registry.GetAll()
 	pids := make(map[int32]bool, len(all))
 	for _, s := range all {
-		if s.PID != 0 {
-			pids[int32(s.PID)] = true
+		if pid := s.PID(); pid != 0 {
+			pids[int32(pid)] = true
 		}
 	}
 	return pids
@@ -510,10 +808,15 @@ func (m *Manager) SetClaudePath(path string) {
 func (m *Manager) StopAll() {
 	for _, s := range m.registry.GetAll() {
 		m.logger.Info("stopping session on shutdown", "sessionId", s.ID)
-		if err := s.ptySession.stop(false); err != nil {
+		s.stopRequested.Store(true)
+		h := s.handle()
+		if h == nil {
+			continue
+		}
+		if err := h.stop(false); err != nil {
 			// Force kill if graceful stop fails.
-			_ = s.ptySession.stop(true)
+			_ = h.stop(true)
 		}
-		s.ptySession.close()
+		h.close()
 	}
 }
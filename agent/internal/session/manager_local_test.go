@@ -100,8 +100,8 @@ func TestSpawnPTY_TierRouting(t *testing.T) {
 
 	done := make(chan struct{})
 	var capturedCode int
-	exitFn := func(sid string, code int, err error) {
-		capturedCode = code
+	exitFn := func(sid string, info exitInfo) {
+		capturedCode = info.Code
 		close(done)
 	}
 
@@ -113,7 +113,7 @@ func TestSpawnPTY_TierRouting(t *testing.T) {
 		outputMu.Unlock()
 	}
 
-	h, pid, err := spawnPTY(ctx, "test-tier-routing", "echo tier-test", ".", nil, outputFn, nil, exitFn)
+	h, pid, err := spawnPTY(ctx, "test-tier-routing", "echo tier-test", ".", nil, nil, outputFn, nil, exitFn)
 	if err != nil {
 		t.Fatalf("spawnPTY: %v", err)
 	}
@@ -189,10 +189,10 @@ func TestStartWithLocalOutput_SendsSessionStarted(t *testing.T) {
 	// Manually register the session so the Manager tracks it (mirrors StartWithLocalOutput).
 	s := &Session{
 		ID:          sid,
-		PID:         pid,
 		ProcessName: "cmd",
 		Workdir:     ".",
 		Command:     "cmd /C echo hello",
+		pid:         pid,
 		ptySession:  handle,
 	}
 	mgr.registry.Add(s)
@@ -0,0 +1,394 @@
+//go:build !windows
+
+package session
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+)
+
+// recordingMessenger records the type of every message sent to the cloud.
+type recordingMessenger struct {
+	mu   sync.Mutex
+	msgs []any
+}
+
+func (r *recordingMessenger) SendJSON(v any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, v)
+	return nil
+}
+
+func (r *recordingMessenger) snapshot() []any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]any(nil), r.msgs...)
+}
+
+// TestStart_RestartsOnFailureUntilLimit verifies that a failing session is
+// respawned under the same ID and reported as stopped once retries run out.
+func TestStart_RestartsOnFailureUntilLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	mgr := NewManager(ctx, messenger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	restart := &config.RestartPolicy{Mode: config.RestartOnFailure, MaxRetries: 2, BackoffSeconds: 1}
+	sid, err := mgr.Start("req-1", "restart-test", "sh -c false", t.TempDir(), nil,
+		config.SessionOptions{Restart: restart})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for mgr.Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session was never removed after exhausting retries")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var attempts []int
+	var stopped *sessionStoppedMsg
+	for _, m := range messenger.snapshot() {
+		switch msg := m.(type) {
+		case sessionRestartedMsg:
+			if msg.SessionID != sid {
+				t.Errorf("session_restarted for %q, want %q", msg.SessionID, sid)
+			}
+			attempts = append(attempts, msg.Attempt)
+		case sessionStoppedMsg:
+			stopped = &msg
+		}
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Fatalf("restart attempts = %v, want [1 2]", attempts)
+	}
+	if stopped == nil || stopped.ExitCode == nil || *stopped.ExitCode != 1 {
+		t.Fatalf("expected final session_stopped with exit code 1, got %+v", stopped)
+	}
+}
+
+// TestStop_PreventsRestart verifies that an explicit stop is never undone by
+// an "always" restart policy.
+func TestStop_PreventsRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	mgr := NewManager(ctx, messenger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	sid, err := mgr.Start("req-2", "stop-test", "sh", t.TempDir(), nil,
+		config.SessionOptions{Restart: &config.RestartPolicy{Mode: config.RestartAlways}})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	waitRunning(t, mgr, sid)
+
+	if err := mgr.Stop(sid, true); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session still registered after Stop")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, m := range messenger.snapshot() {
+		if _, ok := m.(sessionRestartedMsg); ok {
+			t.Fatal("stopped session was restarted")
+		}
+	}
+}
+
+// TestCheckLimits_IdleWarnsThenStops verifies the idle timeout warns the
+// cloud first and then stops the session.
+func TestCheckLimits_IdleWarnsThenStops(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	mgr := NewManager(ctx, messenger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	sid, err := mgr.Start("req-3", "idle-test", "sh", t.TempDir(), nil,
+		config.SessionOptions{Limits: config.SessionLimits{IdleTimeout: 10, TimeoutWarning: 2}})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	s := waitRunning(t, mgr, sid)
+
+	now := time.Now()
+	s.lastActivity.Store(now.Add(-9 * time.Minute).UnixNano())
+	mgr.checkLimits(s, now)
+	s.lastActivity.Store(now.Add(-11 * time.Minute).UnixNano())
+	mgr.checkLimits(s, now)
+
+	var warned, timedOut bool
+	for _, m := range messenger.snapshot() {
+		if msg, ok := m.(sessionTimeoutMsg); ok && msg.Reason == limitIdle {
+			switch msg.Type {
+			case "session_timeout_warning":
+				warned = true
+			case "session_timeout":
+				timedOut = msg.Action == config.TimeoutActionStop
+			}
+		}
+	}
+	if !warned || !timedOut {
+		t.Fatalf("warned=%v timedOut=%v, want both", warned, timedOut)
+	}
+
+	// sh ignores SIGTERM when interactive; the grace-period kill is not
+	// awaited here, so finish the job and make sure no restart happens.
+	_ = mgr.Stop(sid, true)
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session still registered after idle timeout")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestStop_ReportsSignalAndTail verifies session_stopped carries the
+// terminating signal and the last lines of output.
+func TestStop_ReportsSignalAndTail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	mgr := NewManager(ctx, messenger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	sid, err := mgr.Start("req-4", "exit-info-test", "sh", t.TempDir(), nil, config.SessionOptions{})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	s := waitRunning(t, mgr, sid)
+	if err := mgr.WriteInputRaw(sid, []byte("echo tail-marker\n")); err != nil {
+		t.Fatalf("WriteInputRaw: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !containsLine(s.tail.snapshot(), "tail-marker") {
+		if time.Now().After(deadline) {
+			t.Fatalf("output tail never saw marker: %q", s.tail.snapshot())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := mgr.Stop(sid, true); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	for mgr.Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session still registered after Stop")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var stopped *sessionStoppedMsg
+	for _, m := range messenger.snapshot() {
+		if msg, ok := m.(sessionStoppedMsg); ok {
+			stopped = &msg
+		}
+	}
+	if stopped == nil {
+		t.Fatal("no session_stopped sent")
+	}
+	if stopped.Signal != "SIGKILL" {
+		t.Errorf("signal = %q, want SIGKILL", stopped.Signal)
+	}
+	if stopped.DurationMs <= 0 {
+		t.Errorf("durationMs = %d, want > 0", stopped.DurationMs)
+	}
+	if !containsLine(stopped.OutputTail, "tail-marker") {
+		t.Errorf("outputTail = %q, want a tail-marker line", stopped.OutputTail)
+	}
+}
+
+// TestSignal_ForegroundAndGroup verifies signals reach the PTY's foreground
+// job and the session's process group.
+func TestSignal_ForegroundAndGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	mgr := NewManager(ctx, messenger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cases := []struct {
+		input, signal, target string
+	}{
+		{"exec sleep 30\n", "INT", SignalForeground},
+		{"", "HUP", SignalGroup},
+	}
+	for _, tc := range cases {
+		sid, err := mgr.Start("req-5", "", "sh", t.TempDir(), nil, config.SessionOptions{})
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		waitRunning(t, mgr, sid)
+		if tc.input != "" {
+			_ = mgr.WriteInputRaw(sid, []byte(tc.input))
+			time.Sleep(300 * time.Millisecond)
+		}
+		if err := mgr.Signal(sid, tc.signal, tc.target); err != nil {
+			t.Fatalf("Signal(%s, %s): %v", tc.signal, tc.target, err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for mgr.Count() > 0 {
+			if time.Now().After(deadline) {
+				_ = mgr.Stop(sid, true)
+				t.Fatalf("session survived %s to %s", tc.signal, tc.target)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		var got string
+		for _, m := range messenger.snapshot() {
+			if msg, ok := m.(sessionStoppedMsg); ok && msg.SessionID == sid {
+				got = msg.Signal
+			}
+		}
+		if got != "SIG"+tc.signal {
+			t.Errorf("exit signal = %q, want SIG%s", got, tc.signal)
+		}
+	}
+
+	if err := mgr.Signal("missing", "INT", ""); err == nil {
+		t.Error("Signal on unknown session should fail")
+	}
+}
+
+// TestReconcile_IgnoredWithoutSyncState verifies a manager that never sent
+// sync_state, such as a CLI command's, does not report the cloud's sessions
+// as stopped.
+func TestReconcile_IgnoredWithoutSyncState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	mgr := NewManager(ctx, messenger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	mgr.Reconcile(map[string]string{"daemon-session": "running"}, nil)
+	if msgs := messenger.snapshot(); len(msgs) != 0 {
+		t.Fatalf("reply without sync_state sent %+v", msgs)
+	}
+}
+
+// TestSyncState_ReconcilesMissedExitsAndTerminations verifies sync_state
+// carries buffered exits and that the reply resends missed stops and stops
+// sessions the cloud marked for termination.
+func TestSyncState_ReconcilesMissedExitsAndTerminations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	mgr := NewManager(ctx, messenger, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ended, err := mgr.Start("req-6", "", "sh -c true", t.TempDir(), nil, config.SessionOptions{})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("short-lived session never exited")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	live, err := mgr.Start("req-7", "", "sh", t.TempDir(), nil, config.SessionOptions{})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitRunning(t, mgr, live)
+
+	mgr.SendSyncState()
+	var sync *syncStateMsg
+	for _, m := range messenger.snapshot() {
+		if msg, ok := m.(syncStateMsg); ok {
+			sync = &msg
+		}
+	}
+	if sync == nil || len(sync.Sessions) != 1 || sync.Sessions[0].ID != live {
+		t.Fatalf("sync_state sessions = %+v, want only %s", sync, live)
+	}
+	if len(sync.Ended) != 1 || sync.Ended[0].SessionID != ended || *sync.Ended[0].ExitCode != 0 {
+		t.Fatalf("sync_state ended = %+v, want %s with exit 0", sync.Ended, ended)
+	}
+
+	var original sessionStoppedMsg
+	for _, m := range messenger.snapshot() {
+		if msg, ok := m.(sessionStoppedMsg); ok && msg.SessionID == ended {
+			original = msg
+		}
+	}
+
+	before := len(messenger.snapshot())
+	mgr.Reconcile(map[string]string{ended: "running", "ghost": "running", "old": "stopped", live: "running"},
+		[]string{live})
+
+	stops := map[string]sessionStoppedMsg{}
+	for _, m := range messenger.snapshot()[before:] {
+		if msg, ok := m.(sessionStoppedMsg); ok {
+			stops[msg.SessionID] = msg
+		}
+	}
+	if msg, ok := stops[ended]; !ok || msg.ExitCode == nil {
+		t.Errorf("missed stop for %s not resent with its exit code: %+v", ended, msg)
+	} else if msg.EventID == "" || msg.EventID != original.EventID {
+		t.Errorf("resent stop has eventId %q, want the original %q", msg.EventID, original.EventID)
+	}
+	if msg, ok := stops["ghost"]; !ok || msg.ExitCode != nil {
+		t.Errorf("unknown session should get a stop without exit code: %+v", msg)
+	}
+	if _, ok := stops["old"]; ok {
+		t.Error("session the cloud already considers stopped was reported again")
+	}
+
+	// sh ignores SIGTERM; make sure the termination still completes.
+	_ = mgr.Stop(live, true)
+	for mgr.Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("terminated session still registered")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	mgr.endedMu.Lock()
+	remaining := len(mgr.ended)
+	mgr.endedMu.Unlock()
+	if remaining != 1 {
+		t.Errorf("ended buffer has %d entries, want only the exit after the sync", remaining)
+	}
+}
+
+func containsLine(lines []string, want string) bool {
+	for _, l := range lines {
+		if l == want {
+			return true
+		}
+	}
+	return false
+}
+
+// waitRunning polls until the session has a live process.
+func waitRunning(t *testing.T, mgr *Manager, sid string) *Session {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if s, err := mgr.Get(sid); err == nil && s.State() == "running" {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session never reached running state")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// commandPolicy optionally narrows allowedCommands. nil permits every
+// built-in command. It can only restrict: a config cannot add binaries the
+// agent was not built to spawn.
+type commandPolicy map[string]bool
+
+// newCommandPolicy builds the policy for an allowed_commands list. An empty
+// list yields nil, the full built-in set.
+func newCommandPolicy(names []string) (commandPolicy, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	policy := make(commandPolicy, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !allowedCommands[name] {
+			return nil, fmt.Errorf("allowed_commands: %q is not a command the agent can run; choose from %s",
+				name, strings.Join(builtinCommands(), ", "))
+		}
+		policy[name] = true
+	}
+	return policy, nil
+}
+
+// SetAllowedCommands restricts which built-in commands this manager's
+// sessions may run. An empty list restores the full built-in set. Each
+// Manager keeps its own list, so agents serving different profiles do not
+// share one.
+func (m *Manager) SetAllowedCommands(names []string) error {
+	policy, err := newCommandPolicy(names)
+	if err != nil {
+		return err
+	}
+	m.settingsMu.Lock()
+	m.commands = policy
+	m.settingsMu.Unlock()
+	return nil
+}
+
+// commandPolicy returns the policy new sessions are checked against.
+func (m *Manager) commandPolicy() commandPolicy {
+	m.settingsMu.RLock()
+	defer m.settingsMu.RUnlock()
+	return m.commands
+}
+
+// permits reports whether base (lower-case, no extension) may run.
+func (p commandPolicy) permits(base string) bool {
+	return allowedCommands[base] && (p == nil || p[base])
+}
+
+// String lists the commands p allows, for error messages.
+func (p commandPolicy) String() string {
+	if p == nil {
+		return strings.Join(builtinCommands(), ", ")
+	}
+	var names []string
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func builtinCommands() []string {
+	var names []string
+	for name := range allowedCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
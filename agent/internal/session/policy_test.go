@@ -0,0 +1,41 @@
+package session
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetAllowedCommands_OnlyNarrows(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := NewManager(ctx, nil, logger)
+	other := NewManager(ctx, nil, logger)
+
+	if err := m.SetAllowedCommands([]string{"python"}); err == nil {
+		t.Fatal("a command outside the built-in allow-list was accepted")
+	}
+	if err := m.SetAllowedCommands([]string{"claude", "Bash"}); err != nil {
+		t.Fatal(err)
+	}
+	policy := m.commandPolicy()
+	if !policy.permits("claude") || !policy.permits("bash") || policy.permits("zsh") {
+		t.Fatal("policy not applied")
+	}
+	if _, _, err := resolveCommand("zsh", policy); err == nil || !strings.Contains(err.Error(), "bash, claude") {
+		t.Fatalf("resolveCommand(zsh) = %v, want a refusal listing the allowed commands", err)
+	}
+	if !other.commandPolicy().permits("zsh") {
+		t.Fatal("one manager's policy restricted another")
+	}
+
+	if err := m.SetAllowedCommands(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !m.commandPolicy().permits("zsh") {
+		t.Fatal("clearing the policy did not restore the built-in set")
+	}
+}
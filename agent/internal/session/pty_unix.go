@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
 )
 
 // SetConPTYLogger is a no-op on non-Windows platforms (ConPTY is Windows-only).
@@ -36,10 +37,10 @@ var allowedCommands = map[string]bool{
 	"cmd":        true,
 }
 
-// resolveCommand validates the binary name against the allow-list and returns
+// resolveCommand validates the binary name against policy and returns
 // the absolute path plus any extra arguments split from the command string.
 // e.g. "bash -i" → ("/usr/bin/bash", ["-i"], nil)
-func resolveCommand(command string) (string, []string, error) {
+func resolveCommand(command string, policy commandPolicy) (string, []string, error) {
 	parts := strings.Fields(command)
 	if len(parts) == 0 {
 		return "", nil, fmt.Errorf("empty command")
@@ -51,15 +52,16 @@ func resolveCommand(command string) (string, []string, error) {
 	if idx := strings.LastIndex(bin, "/"); idx >= 0 {
 		base = bin[idx+1:]
 	}
-	if !allowedCommands[base] {
-		return "", nil, fmt.Errorf("command %q is not allowed; permitted: claude, bash, zsh, sh, powershell, cmd", base)
+	if !policy.permits(base) {
+		return "", nil, fmt.Errorf("command %q is not allowed; permitted: %s", base, policy)
 	}
 	resolved, err := exec.LookPath(bin)
 	return resolved, args, err
 }
 
 // spawnPTY starts a new PTY process and wires up output streaming.
-// outputFn is called with base64-encoded output chunks; exitFn is called on process exit.
+// outputFn is called with base64-encoded output chunks; exitFn is called on process exit
+// with the exit status, terminating signal and resource usage.
 // localOutputFn, if non-nil, is called with raw bytes before base64 encoding — used by
 // `sessionforge run` to fan output to the local terminal simultaneously.
 func spawnPTY(
@@ -68,11 +70,12 @@ func spawnPTY(
 	command string,
 	workdir string,
 	env map[string]string,
+	policy commandPolicy,
 	outputFn func(sessionID, data string),
 	localOutputFn func(raw []byte),
-	exitFn func(sessionID string, exitCode int, err error),
+	exitFn func(sessionID string, info exitInfo),
 ) (*ptyHandle, int, error) {
-	binary, args, err := resolveCommand(command)
+	binary, args, err := resolveCommand(command, policy)
 	if err != nil {
 		return nil, 0, fmt.Errorf("resolve command: %w", err)
 	}
@@ -112,24 +115,18 @@ func spawnPTY(
 	// Start output reader goroutine with 16ms debounce (~60fps).
 	go readPTYOutput(sessionID, ptmx, outputFn, localOutputFn)
 
-	// Wait goroutine: detect exit and call exitFn.
+	// Wait goroutine: detect exit and call exitFn with status, signal and rusage.
 	go func() {
 		waitErr := cmd.Wait()
 		ptmx.Close()
-		code := 0
+		info := exitInfoFromState(cmd.ProcessState)
 		if waitErr != nil {
-			if exitErr, ok := waitErr.(*exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-					code = status.ExitStatus()
-				} else {
-					code = 1
-				}
-			} else {
-				exitFn(sessionID, -1, waitErr)
-				return
+			if _, ok := waitErr.(*exec.ExitError); !ok {
+				info.Code = -1
+				info.Err = waitErr
 			}
 		}
-		exitFn(sessionID, code, nil)
+		exitFn(sessionID, info)
 	}()
 
 	return h, cmd.Process.Pid, nil
@@ -240,6 +237,48 @@ func (h *ptyHandle) resume() error {
 	return h.cmd.Process.Signal(syscall.SIGCONT)
 }
 
+// signal delivers the named signal to the terminal's foreground process
+// group or, when group is set, to the session's own process group. pty.Start
+// runs the command with Setsid, so its PID is also its process group ID.
+func (h *ptyHandle) signal(name string, group bool) error {
+	num := unix.SignalNum(name)
+	if num == 0 {
+		return fmt.Errorf("signal %s not supported on this platform", name)
+	}
+	pgid := h.cmd.Process.Pid
+	if !group {
+		fg, err := h.foregroundPgrp()
+		if err != nil {
+			return fmt.Errorf("foreground process group: %w", err)
+		}
+		pgid = fg
+	}
+	return unix.Kill(-pgid, num)
+}
+
+// foregroundPgrp returns the foreground process group of the PTY (tcgetpgrp).
+// SyscallConn is used instead of Fd so the master stays non-blocking.
+func (h *ptyHandle) foregroundPgrp() (int, error) {
+	rc, err := h.ptmx.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var pgrp int
+	var ioctlErr error
+	if err := rc.Control(func(fd uintptr) {
+		pgrp, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	}); err != nil {
+		return 0, err
+	}
+	if ioctlErr != nil {
+		return 0, ioctlErr
+	}
+	if pgrp <= 0 {
+		return 0, fmt.Errorf("no foreground process group")
+	}
+	return pgrp, nil
+}
+
 // close releases the PTY and cancels the command context.
 func (h *ptyHandle) close() {
 	h.cancel()
@@ -126,7 +126,7 @@ var allowedCommands = map[string]bool{
 	"cmd":        true,
 }
 
-// resolveCommand validates the binary name against the allow-list and returns
+// resolveCommand validates the binary name against policy and returns
 // the absolute path plus any extra arguments split from the command string.
 // e.g. "bash -i" -> ("/usr/bin/bash", ["-i"], nil)
 //
@@ -139,7 +139,7 @@ var allowedCommands = map[string]bool{
 // resolveCommand also searches user-profile npm directories so that tools
 // installed with "npm install -g" are found even when the service runs as
 // LocalSystem (which only inherits the system PATH, not the user PATH).
-func resolveCommand(command string) (string, []string, error) {
+func resolveCommand(command string, policy commandPolicy) (string, []string, error) {
 	parts := strings.Fields(command)
 	if len(parts) == 0 {
 		return "", nil, fmt.Errorf("empty command")
@@ -159,8 +159,8 @@ func resolveCommand(command string) (string, []string, error) {
 		base = base[idx+1:]
 	}
 	baseLower := strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(base, ".cmd"), ".exe"))
-	if !allowedCommands[baseLower] {
-		return "", nil, fmt.Errorf("command %q is not allowed; permitted: claude, bash, zsh, sh, powershell, cmd", bin)
+	if !policy.permits(baseLower) {
+		return "", nil, fmt.Errorf("command %q is not allowed; permitted: %s", bin, policy)
 	}
 
 	// 1. Check config-stored path first (set at install time while the installer
@@ -508,12 +508,19 @@ func spawnPTY(
 	command string,
 	workdir string,
 	env map[string]string,
+	policy commandPolicy,
 	outputFn func(sessionID, data string),
 	localOutputFn func(raw []byte),
-	exitFn func(sessionID string, exitCode int, err error),
+	onExit func(sessionID string, info exitInfo),
 ) (*ptyHandle, int, error) {
 	ensureTierDetected()
 
+	// The tier spawners report only an exit code; signal and rusage details
+	// do not exist on Windows.
+	exitFn := func(sid string, exitCode int, err error) {
+		onExit(sid, exitInfo{Code: exitCode, Err: err})
+	}
+
 	// Unconditional — always visible regardless of conPTYWorkingLogger state.
 	slog.Default().Info("spawnPTY: routing session",
 		"tier", spawnTier, "command", command, "sessionId", sessionID,
@@ -538,7 +545,7 @@ func spawnPTY(
 		}
 		return spawnWithGitBash(ctx, sessionID, command, workdir, env, outputFn, localOutputFn, exitFn)
 	default:
-		binary, args, err := resolveCommand(command)
+		binary, args, err := resolveCommand(command, policy)
 		if err != nil {
 			slog.Default().Error("spawnPTY: resolveCommand failed",
 				"command", command, "sessionId", sessionID, "err", err,
@@ -1427,6 +1434,28 @@ func (h *ptyHandle) resume() error {
 	return fmt.Errorf("resume not supported on Windows")
 }
 
+// signal approximates Unix signal delivery. WSL sessions forward the signal
+// to the Linux process; native sessions only support SIGINT (Ctrl+C on stdin)
+// and SIGTERM/SIGKILL (stop). group is ignored: Windows has no process groups
+// reachable from here.
+func (h *ptyHandle) signal(name string, group bool) error {
+	if h.tier == "wsl" && h.linuxPID > 0 && h.wslDistro != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		return exec.CommandContext(ctx, "wsl", "-d", h.wslDistro, "--",
+			"kill", "-s", strings.TrimPrefix(name, "SIG"), fmt.Sprintf("%d", h.linuxPID)).Run()
+	}
+	switch name {
+	case "SIGINT":
+		return h.gracefulStop()
+	case "SIGTERM":
+		return h.stop(false)
+	case "SIGKILL":
+		return h.stop(true)
+	}
+	return fmt.Errorf("%s not supported on Windows", name)
+}
+
 // close cancels the context, releases stdin, and performs tier-specific cleanup.
 func (h *ptyHandle) close() {
 	h.cancel()
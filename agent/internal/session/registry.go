@@ -4,20 +4,85 @@ package session
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Session represents a single running terminal session.
 type Session struct {
 	ID          string
-	PID         int
 	ProcessName string
 	Workdir     string
 	StartedAt   time.Time
 	Command     string
+	// Name is the optional human-readable label given at start.
+	Name string
 
-	// ptySession is the underlying OS-specific PTY handle.
-	ptySession *ptyHandle
+	// mu guards the process fields below. They are written by the goroutine
+	// that spawns the session and by its exit goroutine, and read by
+	// heartbeats, sync_state and the limits watchdog.
+	mu             sync.Mutex
+	pid            int
+	ptySession     *ptyHandle // underlying OS-specific PTY handle
+	lastSpawn      time.Time
+	restartCount   int // consecutive restarts by the restart policy
+	conversationID string
+
+	// Fixed when the session starts.
+	env     map[string]string // merged environment, reused on respawn
+	restart restartPolicy
+	tail    *outputTail // last lines of output for exit reports
+
+	// stopRequested is set by Stop/StopAll so the restart policy does not
+	// bring back a session the user deliberately ended.
+	stopRequested atomic.Bool
+
+	// idle_timeout / max_runtime state. lastActivity is unix nanoseconds of
+	// the last PTY input or output; the warned flags belong to watchLimits.
+	limits        sessionLimits
+	lastActivity  atomic.Int64
+	paused        atomic.Bool
+	runtimeDone   atomic.Bool
+	idleWarned    bool
+	runtimeWarned bool
+}
+
+// PID returns the process ID, or 0 while the session is spawning or
+// waiting to restart.
+func (s *Session) PID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pid
+}
+
+// RestartCount returns the number of consecutive restarts by the restart
+// policy.
+func (s *Session) RestartCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restartCount
+}
+
+// handle returns the PTY handle, or nil until the first spawn completes.
+func (s *Session) handle() *ptyHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ptySession
+}
+
+// setProcess records the result of a successful spawn.
+func (s *Session) setProcess(h *ptyHandle, pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ptySession = h
+	s.pid = pid
+}
+
+// conversation returns the Claude conversation ID resolved at the last exit.
+func (s *Session) conversation() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conversationID
 }
 
 // Registry is a thread-safe in-memory store of active sessions.
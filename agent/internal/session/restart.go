@@ -0,0 +1,125 @@
+package session
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+)
+
+const (
+	defaultRestartBackoff    = 1 * time.Second
+	defaultRestartMaxBackoff = 60 * time.Second
+	defaultRestartResetAfter = 5 * time.Minute
+)
+
+// sessionRestartedMsg is sent after a session has been respawned under the
+// same session ID by its restart policy.
+type sessionRestartedMsg struct {
+	Type                 string `json:"type"`
+	SessionID            string `json:"sessionId"`
+	Attempt              int    `json:"attempt"`
+	PID                  int    `json:"pid"`
+	PrevExitCode         *int   `json:"prevExitCode,omitempty"`
+	PrevError            string `json:"prevError,omitempty"`
+	ClaudeConversationID string `json:"claudeConversationId,omitempty"`
+}
+
+// restartPolicy is a RestartPolicy with defaults applied and durations resolved.
+type restartPolicy struct {
+	mode       string
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	resetAfter time.Duration
+}
+
+// newRestartPolicy normalises a config.RestartPolicy. Unknown modes are
+// treated as "never" so a typo can never cause a respawn loop.
+func newRestartPolicy(p config.RestartPolicy) restartPolicy {
+	rp := restartPolicy{
+		mode:       strings.ToLower(strings.TrimSpace(p.Mode)),
+		maxRetries: p.MaxRetries,
+		backoff:    time.Duration(p.BackoffSeconds) * time.Second,
+		maxBackoff: time.Duration(p.MaxBackoffSeconds) * time.Second,
+		resetAfter: time.Duration(p.ResetAfterSeconds) * time.Second,
+	}
+	switch rp.mode {
+	case config.RestartOnFailure, config.RestartAlways:
+	default:
+		rp.mode = config.RestartNever
+	}
+	if rp.maxRetries < 0 {
+		rp.maxRetries = 0
+	}
+	if rp.backoff <= 0 {
+		rp.backoff = defaultRestartBackoff
+	}
+	if rp.maxBackoff <= 0 {
+		rp.maxBackoff = defaultRestartMaxBackoff
+	}
+	if rp.maxBackoff < rp.backoff {
+		rp.maxBackoff = rp.backoff
+	}
+	if rp.resetAfter <= 0 {
+		rp.resetAfter = defaultRestartResetAfter
+	}
+	return rp
+}
+
+// wantsRestart reports whether the policy restarts a process that exited
+// with exitCode / exitErr. exitErr is non-nil when the exit status could not
+// be determined (treated as a failure).
+func (rp restartPolicy) wantsRestart(exitCode int, exitErr error) bool {
+	switch rp.mode {
+	case config.RestartAlways:
+		return true
+	case config.RestartOnFailure:
+		return exitErr != nil || exitCode != 0
+	default:
+		return false
+	}
+}
+
+// delay returns the backoff before restart attempt n (1-based):
+// backoff, 2×backoff, 4×backoff … capped at maxBackoff.
+func (rp restartPolicy) delay(attempt int) time.Duration {
+	d := rp.backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= rp.maxBackoff {
+			return rp.maxBackoff
+		}
+	}
+	if d > rp.maxBackoff {
+		d = rp.maxBackoff
+	}
+	return d
+}
+
+// withResume appends --resume <conversationID> to a claude command so a
+// restarted session picks up the conversation it crashed in. Non-claude
+// commands and commands that already resume are returned unchanged.
+func withResume(command, conversationID string) string {
+	if conversationID == "" {
+		return command
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return command
+	}
+	base := fields[0]
+	if idx := strings.LastIndexAny(base, `/\`); idx >= 0 {
+		base = base[idx+1:]
+	}
+	base = strings.TrimSuffix(strings.ToLower(base), ".exe")
+	if base != "claude" {
+		return command
+	}
+	for _, f := range fields[1:] {
+		if f == "--resume" || f == "-r" || f == "--continue" || f == "-c" {
+			return command
+		}
+	}
+	return command + " --resume " + conversationID
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+)
+
+func TestNewRestartPolicy_Defaults(t *testing.T) {
+	rp := newRestartPolicy(config.RestartPolicy{Mode: "bogus"})
+	if rp.mode != config.RestartNever {
+		t.Fatalf("unknown mode should normalise to never, got %q", rp.mode)
+	}
+	if rp.backoff != defaultRestartBackoff || rp.maxBackoff != defaultRestartMaxBackoff || rp.resetAfter != defaultRestartResetAfter {
+		t.Fatalf("defaults not applied: %+v", rp)
+	}
+}
+
+func TestRestartPolicy_WantsRestart(t *testing.T) {
+	never := newRestartPolicy(config.RestartPolicy{})
+	onFailure := newRestartPolicy(config.RestartPolicy{Mode: "on-failure"})
+	always := newRestartPolicy(config.RestartPolicy{Mode: "Always"})
+	crash := errors.New("wait: no child processes")
+
+	cases := []struct {
+		name string
+		rp   restartPolicy
+		code int
+		err  error
+		want bool
+	}{
+		{"never/fail", never, 1, nil, false},
+		{"on-failure/clean", onFailure, 0, nil, false},
+		{"on-failure/code", onFailure, 2, nil, true},
+		{"on-failure/crash", onFailure, -1, crash, true},
+		{"always/clean", always, 0, nil, true},
+	}
+	for _, c := range cases {
+		if got := c.rp.wantsRestart(c.code, c.err); got != c.want {
+			t.Errorf("%s: wantsRestart = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRestartPolicy_DelayCapped(t *testing.T) {
+	rp := newRestartPolicy(config.RestartPolicy{Mode: "always", BackoffSeconds: 2, MaxBackoffSeconds: 10})
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := rp.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestWithResume(t *testing.T) {
+	cases := map[string]string{
+		"claude":                    "claude --resume abc",
+		"/usr/bin/claude --verbose": "/usr/bin/claude --verbose --resume abc",
+		"claude --resume old":       "claude --resume old",
+		"bash":                      "bash",
+	}
+	for in, want := range cases {
+		if got := withResume(in, "abc"); got != want {
+			t.Errorf("withResume(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := withResume("claude", ""); got != "claude" {
+		t.Errorf("withResume without ID = %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Signal targets accepted by Manager.Signal and the signal_session message.
+const (
+	// SignalForeground signals the PTY's foreground process group, i.e. the
+	// job currently reading the terminal (what Ctrl+C would hit).
+	SignalForeground = "foreground"
+	// SignalGroup signals the session's own process group: the spawned
+	// command and every child that has not moved to another job.
+	SignalGroup = "group"
+)
+
+// allowedSignals is the set of signals that may be delivered to a session.
+// SIGSTOP/SIGCONT are reserved for pause/resume so the session state stays
+// accurate; anything not listed here is refused.
+var allowedSignals = map[string]bool{
+	"SIGINT":   true,
+	"SIGHUP":   true,
+	"SIGTERM":  true,
+	"SIGQUIT":  true,
+	"SIGKILL":  true,
+	"SIGUSR1":  true,
+	"SIGUSR2":  true,
+	"SIGWINCH": true,
+	"SIGTSTP":  true,
+}
+
+// NormalizeSignal validates a signal name against the allow-list and
+// returns its canonical form. "int", "INT" and "SIGINT" are all accepted.
+func NormalizeSignal(name string) (string, error) {
+	sig := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(sig, "SIG") {
+		sig = "SIG" + sig
+	}
+	if !allowedSignals[sig] {
+		return "", fmt.Errorf("signal %q is not allowed; permitted: %s", name, strings.Join(AllowedSignals(), ", "))
+	}
+	return sig, nil
+}
+
+// AllowedSignals returns the allow-listed signal names in sorted order.
+func AllowedSignals() []string {
+	names := make([]string, 0, len(allowedSignals))
+	for name := range allowedSignals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Signal delivers sig to a running session. target is SignalForeground
+// (the default when empty) or SignalGroup.
+func (m *Manager) Signal(sessionID, sig, target string) error {
+	name, err := NormalizeSignal(sig)
+	if err != nil {
+		return err
+	}
+	switch target {
+	case "":
+		target = SignalForeground
+	case SignalForeground, SignalGroup:
+	default:
+		return fmt.Errorf("unknown signal target %q; use %q or %q", target, SignalForeground, SignalGroup)
+	}
+
+	s, err := m.running(sessionID)
+	if err != nil {
+		return err
+	}
+	m.logger.Info("signalling session", "sessionId", sessionID, "signal", name, "target", target)
+	if m.debugLog != nil {
+		m.debugLog.Info("session_signal", "Signal sent to session", map[string]any{
+			"sessionId": sessionID,
+			"signal":    name,
+			"target":    target,
+		})
+	}
+	if err := s.handle().signal(name, target == SignalGroup); err != nil {
+		return fmt.Errorf("send %s: %w", name, err)
+	}
+	s.touch()
+	return nil
+}
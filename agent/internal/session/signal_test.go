@@ -0,0 +1,17 @@
+package session
+
+import "testing"
+
+func TestNormalizeSignal(t *testing.T) {
+	for in, want := range map[string]string{"INT": "SIGINT", "sighup": "SIGHUP", " usr1 ": "SIGUSR1", "SIGKILL": "SIGKILL"} {
+		got, err := NormalizeSignal(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeSignal(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"STOP", "SIGCONT", "9", "", "SIGSEGV"} {
+		if _, err := NormalizeSignal(bad); err == nil {
+			t.Errorf("NormalizeSignal(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package session
+
+import (
+	"time"
+)
+
+// maxEndedSessions bounds the buffer of exits kept for the next sync_state.
+const maxEndedSessions = 256
+
+// endedSession records a session that exited, so a sync_state can tell the
+// cloud about exits it may have missed while the agent was offline.
+type endedSession struct {
+	SessionID string `json:"sessionId"`
+	ExitCode  *int   `json:"exitCode"`
+	Signal    string `json:"signal,omitempty"`
+	Error     string `json:"error,omitempty"`
+	EndedAt   string `json:"endedAt"`
+
+	at time.Time
+	// msg is the original session_stopped / session_crashed, resent verbatim
+	// if the cloud still believes the session is running.
+	msg any
+}
+
+// syncSession is one live session in a sync_state message.
+type syncSession struct {
+	sessionInfoJSON
+	State        string `json:"state"`
+	RestartCount int    `json:"restartCount"`
+}
+
+// syncStateMsg is the agent's authoritative view of its sessions, sent after
+// every register. The cloud answers with sync_state_reply.
+type syncStateMsg struct {
+	Type     string         `json:"type"`
+	Sessions []syncSession  `json:"sessions"`
+	Ended    []endedSession `json:"ended"`
+}
+
+// recordEnded buffers an exit for the next sync_state.
+func (m *Manager) recordEnded(e endedSession) {
+	m.endedMu.Lock()
+	defer m.endedMu.Unlock()
+	m.ended = append(m.ended, e)
+	if len(m.ended) > maxEndedSessions {
+		m.ended = m.ended[len(m.ended)-maxEndedSessions:]
+	}
+}
+
+// SendSyncState sends the full list of live sessions plus every exit buffered
+// since the last reconciliation. Call it after each successful register.
+func (m *Manager) SendSyncState() {
+	msg := syncStateMsg{Type: "sync_state", Sessions: []syncSession{}}
+	for _, s := range m.registry.GetAll() {
+		msg.Sessions = append(msg.Sessions, syncSession{
+			sessionInfoJSON: s.info(),
+			State:           s.State(),
+			RestartCount:    s.RestartCount(),
+		})
+	}
+
+	m.endedMu.Lock()
+	msg.Ended = append([]endedSession{}, m.ended...)
+	m.syncSentAt = time.Now()
+	m.endedMu.Unlock()
+
+	if err := m.messenger.SendJSON(msg); err != nil {
+		m.logger.Warn("sync: failed to send sync_state", "err", err)
+		return
+	}
+	m.logger.Info("sync: sent sync_state", "sessions", len(msg.Sessions), "ended", len(msg.Ended))
+}
+
+// Reconcile applies the cloud's sync_state_reply. remote maps session IDs to
+// the status the cloud has recorded; terminate lists sessions the cloud wants
+// stopped. Sessions the cloud still thinks are running but that no longer
+// exist here get their stop event (re)sent. A reply is ignored unless this
+// manager sent a sync_state, since only that makes its session list
+// authoritative.
+func (m *Manager) Reconcile(remote map[string]string, terminate []string) {
+	m.endedMu.Lock()
+	synced := !m.syncSentAt.IsZero()
+	m.endedMu.Unlock()
+	if !synced {
+		m.logger.Warn("sync: ignoring sync_state_reply without a sync_state")
+		return
+	}
+
+	for _, id := range terminate {
+		s, err := m.registry.Get(id)
+		if err != nil {
+			continue
+		}
+		m.logger.Info("sync: stopping session marked for termination", "sessionId", id)
+		m.stopWithGrace(s)
+	}
+
+	m.endedMu.Lock()
+	ended := make(map[string]endedSession, len(m.ended))
+	for _, e := range m.ended {
+		ended[e.SessionID] = e
+	}
+	// Everything included in the last sync_state has now been seen.
+	kept := m.ended[:0]
+	for _, e := range m.ended {
+		if e.at.After(m.syncSentAt) {
+			kept = append(kept, e)
+		}
+	}
+	m.ended = kept
+	m.endedMu.Unlock()
+
+	for id, status := range remote {
+		if !remoteActive(status) {
+			continue
+		}
+		if _, err := m.registry.Get(id); err == nil {
+			continue
+		}
+		var msg any = sessionStoppedMsg{Type: "session_stopped", SessionID: id}
+		if e, ok := ended[id]; ok {
+			msg = e.msg
+		}
+		m.logger.Info("sync: sending missed stop", "sessionId", id, "remoteStatus", status)
+		if err := m.messenger.SendJSON(msg); err != nil {
+			m.logger.Warn("sync: failed to send missed stop", "sessionId", id, "err", err)
+		}
+	}
+}
+
+// remoteActive reports whether a cloud-side session status means the cloud
+// believes the session is still alive.
+func remoteActive(status string) bool {
+	switch status {
+	case "stopped", "crashed", "terminated", "failed":
+		return false
+	}
+	return true
+}
@@ -7,7 +7,6 @@ import (
 	"runtime"
 	"strings"
 
-	"github.com/google/uuid"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
 )
@@ -58,11 +57,6 @@ func GetRAMGB() float64 {
 	return float64(int(gb*10+0.5)) / 10
 }
 
-// GenerateMachineID creates a new random UUID to use as a persistent machine identifier.
-func GenerateMachineID() string {
-	return uuid.New().String()
-}
-
 // SummaryString returns a human-readable one-liner summary of the machine.
 func SummaryString() string {
 	return fmt.Sprintf("%s | %s | CPU: %s | RAM: %.1f GB",
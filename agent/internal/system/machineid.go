@@ -0,0 +1,76 @@
+package system
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// machineIDSalt makes derived IDs specific to SessionForge, so they cannot
+// be correlated with the host identifier or with other applications' IDs.
+const machineIDSalt = "sessionforge-agent/machine-id/v1"
+
+// readHostID returns the platform's stable host identifier; tests replace it.
+var readHostID = hostID
+
+// errNoHostID means the platform offers no stable host identifier.
+var errNoHostID = errors.New("no host identifier available")
+
+// HostMachineID derives this machine's ID from the platform host identifier
+// (/etc/machine-id on Linux, IOPlatformUUID on macOS, MachineGuid on
+// Windows). The same host always yields the same ID, so reinstalling the
+// agent does not register a new machine.
+func HostMachineID() (string, error) {
+	id, err := readHostID()
+	if err != nil {
+		return "", err
+	}
+	return deriveMachineID(id), nil
+}
+
+// deriveMachineID hashes hostID with machineIDSalt into a UUID. Version 8
+// (custom) marks it as derived, unlike the random v4 IDs of older agents.
+func deriveMachineID(hostID string) string {
+	mac := hmac.New(sha256.New, []byte(strings.ToLower(strings.TrimSpace(hostID))))
+	mac.Write([]byte(machineIDSalt))
+	var u uuid.UUID
+	copy(u[:], mac.Sum(nil))
+	u[6] = (u[6] & 0x0f) | 0x80 // version 8
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return u.String()
+}
+
+// GenerateMachineID returns the host-derived machine ID, or a random UUID
+// on hosts without a stable identifier.
+func GenerateMachineID() string {
+	if id, err := HostMachineID(); err == nil {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// IsForeignMachineID reports whether id was derived on a different host,
+// meaning config.toml was copied from, or the disk cloned from, another
+// machine. Random and server-assigned IDs cannot be checked and are never
+// reported.
+func IsForeignMachineID(id string) bool {
+	u, err := uuid.Parse(id)
+	if err != nil || u.Version() != 8 {
+		return false
+	}
+	own, err := HostMachineID()
+	return err == nil && own != u.String()
+}
+
+// validHostID rejects empty identifiers and systemd's placeholder for a
+// machine-id that has not been set up yet.
+func validHostID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if id == "" || id == "uninitialized" || strings.Trim(id, "0-") == "" {
+		return "", errNoHostID
+	}
+	return id, nil
+}
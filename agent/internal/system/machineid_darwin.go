@@ -0,0 +1,23 @@
+//go:build darwin
+
+package system
+
+import (
+	"os/exec"
+	"regexp"
+)
+
+var platformUUIDRE = regexp.MustCompile(`"IOPlatformUUID" = "([0-9A-Fa-f-]+)"`)
+
+// hostID returns the hardware UUID that System Information shows.
+func hostID() (string, error) {
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", errNoHostID
+	}
+	m := platformUUIDRE.FindSubmatch(out)
+	if m == nil {
+		return "", errNoHostID
+	}
+	return validHostID(string(m[1]))
+}
@@ -0,0 +1,75 @@
+package system
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func stubHostID(t *testing.T, id string, err error) {
+	t.Helper()
+	orig := readHostID
+	readHostID = func() (string, error) { return id, err }
+	t.Cleanup(func() { readHostID = orig })
+}
+
+func TestHostMachineID_StableAndSalted(t *testing.T) {
+	stubHostID(t, "fed6b2924c424cf1b9a322f606b4de6d\n", nil)
+	a, err := HostMachineID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := HostMachineID()
+	if a != b {
+		t.Fatalf("derived IDs differ: %s, %s", a, b)
+	}
+	u, err := uuid.Parse(a)
+	if err != nil || u.Version() != 8 {
+		t.Fatalf("derived ID %s is not a version 8 UUID (%v)", a, err)
+	}
+	if a == "fed6b292-4c42-4cf1-b9a3-22f606b4de6d" {
+		t.Fatal("derived ID exposes the host identifier")
+	}
+	if deriveMachineID("another-host") == a {
+		t.Fatal("different hosts derived the same ID")
+	}
+}
+
+func TestIsForeignMachineID(t *testing.T) {
+	stubHostID(t, "host-a", nil)
+	own := GenerateMachineID()
+	if IsForeignMachineID(own) {
+		t.Error("this host's own ID reported as foreign")
+	}
+	if !IsForeignMachineID(deriveMachineID("host-b")) {
+		t.Error("ID derived on another host not reported")
+	}
+	if IsForeignMachineID(uuid.New().String()) || IsForeignMachineID("server-assigned") {
+		t.Error("random or server-assigned ID reported as foreign")
+	}
+}
+
+func TestGenerateMachineID_RandomWithoutHostID(t *testing.T) {
+	stubHostID(t, "", errNoHostID)
+	a, b := GenerateMachineID(), GenerateMachineID()
+	if a == b {
+		t.Fatal("fallback IDs are not random")
+	}
+	if u, err := uuid.Parse(a); err != nil || u.Version() != 4 {
+		t.Fatalf("fallback ID %s is not a random UUID", a)
+	}
+	if IsForeignMachineID(deriveMachineID("host-b")) {
+		t.Error("clone reported although this host has no identifier to compare")
+	}
+}
+
+func TestValidHostID(t *testing.T) {
+	for _, id := range []string{"", "  \n", "uninitialized\n", "00000000000000000000000000000000"} {
+		if _, err := validHostID(id); err == nil {
+			t.Errorf("validHostID(%q) accepted", id)
+		}
+	}
+	if id, err := validHostID("abc123\n"); err != nil || id != "abc123" {
+		t.Errorf("validHostID = %q, %v", id, err)
+	}
+}
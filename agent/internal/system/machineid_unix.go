@@ -0,0 +1,23 @@
+//go:build !windows && !darwin
+
+package system
+
+import "os"
+
+// hostIDFiles are tried in order: systemd's machine-id, the D-Bus copy on
+// older distributions, and the BSD host ID.
+var hostIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id", "/etc/hostid"}
+
+// hostID reads the first usable host identifier file.
+func hostID() (string, error) {
+	for _, path := range hostIDFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if id, err := validHostID(string(data)); err == nil {
+			return id, nil
+		}
+	}
+	return "", errNoHostID
+}
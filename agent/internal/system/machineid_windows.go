@@ -0,0 +1,21 @@
+//go:build windows
+
+package system
+
+import "golang.org/x/sys/windows/registry"
+
+// hostID returns the MachineGuid Windows generates at installation. Sysprep
+// regenerates it for images that are meant to be cloned.
+func hostID() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE,
+		`SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return "", errNoHostID
+	}
+	defer key.Close()
+	guid, _, err := key.GetStringValue("MachineGuid")
+	if err != nil {
+		return "", errNoHostID
+	}
+	return validHostID(guid)
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+)
+
+// TLSConfig builds the TLS settings for connections to the SessionForge
+// server from tls_ca_file, tls_client_cert, tls_client_key and
+// tls_server_name. It returns nil when none are set, so Go's defaults apply.
+func TLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCAFile == "" && cfg.TLSClientCert == "" && cfg.TLSClientKey == "" && cfg.TLSServerName == "" {
+		return nil, nil
+	}
+	tc, err := publicTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tc == nil {
+		tc = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tc.ServerName = cfg.TLSServerName
+
+	switch {
+	case cfg.TLSClientCert == "" && cfg.TLSClientKey == "":
+	case cfg.TLSClientCert == "" || cfg.TLSClientKey == "":
+		return nil, fmt.Errorf("tls_client_cert and tls_client_key must be set together")
+	default:
+		cert, err := tls.LoadX509KeyPair(cfg.TLSClientCert, cfg.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate %s / %s: %w", cfg.TLSClientCert, cfg.TLSClientKey, err)
+		}
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Now().After(leaf.NotAfter) {
+			return nil, fmt.Errorf("client certificate %s expired on %s", cfg.TLSClientCert, leaf.NotAfter.Format(time.DateOnly))
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// publicTLSConfig carries only the extra CA bundle. It is used for hosts
+// other than the SessionForge server (GitHub releases), where a client
+// certificate or server-name override would be wrong but a corporate CA
+// may still be needed to get through a TLS-inspecting proxy.
+func publicTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(cfg.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read tls_ca_file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls_ca_file %s contains no PEM certificates", cfg.TLSCAFile)
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}, nil
+}
+
+// DiagnoseTLS rewrites certificate and handshake failures into messages that
+// say which config option to look at. Other errors are returned unchanged.
+func DiagnoseTLS(err error) error {
+	if err == nil {
+		return nil
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		issuer := "unknown issuer"
+		if unknownAuthority.Cert != nil {
+			issuer = unknownAuthority.Cert.Issuer.String()
+		}
+		return fmt.Errorf("server certificate is signed by an untrusted CA (%s); set tls_ca_file to that CA's PEM bundle: %w", issuer, err)
+	case errors.As(err, &hostname):
+		names := "no DNS names"
+		if hostname.Certificate != nil && len(hostname.Certificate.DNSNames) > 0 {
+			names = strings.Join(hostname.Certificate.DNSNames, ", ")
+		}
+		return fmt.Errorf("server certificate is for %s, not %q; fix server_url or set tls_server_name: %w", names, hostname.Host, err)
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return fmt.Errorf("server certificate is expired or not yet valid (check this machine's clock): %w", err)
+	case errors.As(err, &recordHeader):
+		return fmt.Errorf("server did not answer with TLS; check that server_url uses the right scheme and port: %w", err)
+	case strings.Contains(err.Error(), "tls: certificate required"),
+		strings.Contains(err.Error(), "tls: bad certificate"),
+		strings.Contains(err.Error(), "tls: unknown certificate authority"):
+		// Alerts from the server arrive as opaque strings.
+		return fmt.Errorf("server rejected the client certificate; check tls_client_cert and tls_client_key: %w", err)
+	}
+	return err
+}
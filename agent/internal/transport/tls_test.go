@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sessionforge/agent/internal/config"
+)
+
+// testPKI is a throwaway CA with a server and a client certificate.
+type testPKI struct {
+	caFile, clientCert, clientKey string
+	caPool                        *x509.CertPool
+	server                        tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SessionForge Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage, dnsNames ...string) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "test"},
+			DNSNames:     dnsNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der, key
+	}
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	p := &testPKI{caPool: x509.NewCertPool()}
+	p.caPool.AddCert(ca)
+	p.caFile = writePEM("ca.pem", "CERTIFICATE", caDER)
+
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth, "sessionforge.internal")
+	p.server = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	keyDER, _ := x509.MarshalECPrivateKey(clientKey)
+	p.clientCert = writePEM("client.pem", "CERTIFICATE", clientDER)
+	p.clientKey = writePEM("client-key.pem", "EC PRIVATE KEY", keyDER)
+	return p
+}
+
+func TestTLSConfig_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // rejected handshakes are expected
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cfg *config.Config) error {
+		client, err := HTTPClient(cfg, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return DiagnoseTLS(err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+	expectHint := func(err error, hint string) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), hint) {
+			t.Fatalf("error = %v, want a hint mentioning %s", err, hint)
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.ProxyURL = ProxyDirect
+	cfg.TLSServerName = "sessionforge.internal"
+	expectHint(get(cfg), "tls_ca_file")
+
+	cfg.TLSCAFile, cfg.TLSServerName = pki.caFile, ""
+	expectHint(get(cfg), "tls_server_name")
+
+	cfg.TLSServerName = "sessionforge.internal"
+	expectHint(get(cfg), "tls_client_cert")
+
+	cfg.TLSClientCert, cfg.TLSClientKey = pki.clientCert, pki.clientKey
+	if err := get(cfg); err != nil {
+		t.Fatalf("mutual TLS request failed: %v", err)
+	}
+}
+
+func TestTLSConfig_Validation(t *testing.T) {
+	pki := newTestPKI(t)
+	cfg := config.DefaultConfig()
+	if tc, err := TLSConfig(cfg); tc != nil || err != nil {
+		t.Fatalf("no TLS options should give nil config, got %v, %v", tc, err)
+	}
+
+	cfg.TLSClientCert = pki.clientCert
+	if _, err := TLSConfig(cfg); err == nil || !strings.Contains(err.Error(), "together") {
+		t.Fatalf("cert without key: err = %v", err)
+	}
+
+	cfg.TLSClientCert = ""
+	cfg.TLSCAFile = pki.clientKey // a key, not a certificate
+	if _, err := TLSConfig(cfg); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Fatalf("CA file without certificates: err = %v", err)
+	}
+
+	cfg.TLSCAFile, cfg.TLSServerName = pki.caFile, "sessionforge.internal"
+	tc, err := PublicHTTPTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.TLSClientConfig.ServerName != "" || tc.TLSClientConfig.RootCAs == nil {
+		t.Fatal("public transport must keep the CA bundle but not the server name override")
+	}
+}
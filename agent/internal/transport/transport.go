@@ -0,0 +1,170 @@
+// Package transport builds the network transports used to reach the cloud,
+// so the WebSocket, health ping, updater and debug log all honour the same
+// proxy and TLS settings.
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sessionforge/agent/internal/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyDirect as proxy_url disables proxying even if HTTPS_PROXY is set.
+const ProxyDirect = "direct"
+
+// wsHandshakeTimeout bounds the WebSocket upgrade, including any proxy CONNECT.
+const wsHandshakeTimeout = 15 * time.Second
+
+// Proxy returns the proxy selection function for cfg. proxy_url and
+// no_proxy from config.toml take precedence over HTTPS_PROXY, HTTP_PROXY
+// and NO_PROXY from the environment.
+func Proxy(cfg *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	pc := httpproxy.FromEnvironment()
+	switch proxyURL := strings.TrimSpace(cfg.ProxyURL); {
+	case strings.EqualFold(proxyURL, ProxyDirect):
+		return nil, nil
+	case proxyURL != "":
+		if err := validateProxyURL(proxyURL); err != nil {
+			return nil, err
+		}
+		pc.HTTPProxy, pc.HTTPSProxy = proxyURL, proxyURL
+	default:
+		for _, env := range []string{pc.HTTPSProxy, pc.HTTPProxy} {
+			if env == "" {
+				continue
+			}
+			if err := validateProxyURL(env); err != nil {
+				return nil, fmt.Errorf("HTTPS_PROXY/HTTP_PROXY: %w", err)
+			}
+		}
+	}
+	if cfg.NoProxy != "" {
+		pc.NoProxy = cfg.NoProxy
+	}
+	if pc.HTTPProxy == "" && pc.HTTPSProxy == "" {
+		return nil, nil
+	}
+
+	fn := pc.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}, nil
+}
+
+// validateProxyURL rejects proxy URLs the WebSocket dialer cannot use, so a
+// typo fails loudly instead of silently connecting directly.
+func validateProxyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q in %s; use http:// or socks5://", u.Scheme, Redact(raw))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy URL %s has no host", Redact(raw))
+	}
+	return nil
+}
+
+// HTTPTransport returns an http.Transport for requests to the SessionForge
+// server. Callers set their own timeouts on the http.Client that wraps it.
+func HTTPTransport(cfg *config.Config) (*http.Transport, error) {
+	tc, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newHTTPTransport(cfg, tc)
+}
+
+// PublicHTTPTransport returns an http.Transport for third-party hosts such
+// as GitHub releases: same proxy and extra CAs, but no client certificate
+// or server-name override.
+func PublicHTTPTransport(cfg *config.Config) (*http.Transport, error) {
+	tc, err := publicTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newHTTPTransport(cfg, tc)
+}
+
+func newHTTPTransport(cfg *config.Config, tc *tls.Config) (*http.Transport, error) {
+	proxy, err := Proxy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	t.TLSClientConfig = tc
+	return t, nil
+}
+
+// HTTPClient is a convenience wrapper around HTTPTransport.
+func HTTPClient(cfg *config.Config, timeout time.Duration) (*http.Client, error) {
+	t, err := HTTPTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t, Timeout: timeout}, nil
+}
+
+// WebSocketDialer returns a dialer for the agent's cloud connection.
+func WebSocketDialer(cfg *config.Config) (*websocket.Dialer, error) {
+	proxy, err := Proxy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &websocket.Dialer{
+		HandshakeTimeout: wsHandshakeTimeout,
+		Proxy:            proxy,
+		TLSClientConfig:  tc,
+	}, nil
+}
+
+// sensitiveParams are query parameters whose values are credentials.
+var sensitiveParams = []string{"key", "api_key", "apikey", "token", "access_token"}
+
+// Redact masks credentials in a URL — the userinfo password and any
+// credential-bearing query parameters — so it is safe to log.
+func Redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	redacted := false
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			redacted = true
+		}
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for _, name := range sensitiveParams {
+			if q.Has(name) {
+				q.Set(name, "REDACTED")
+				redacted = true
+			}
+		}
+		if redacted {
+			u.RawQuery = q.Encode()
+		}
+	}
+	if !redacted {
+		return raw
+	}
+	return u.String()
+}
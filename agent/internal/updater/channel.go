@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sessionforge/agent/internal/semver"
+)
+
+// Channel selects which releases an update may install. Each channel
+// includes the ones before it: stable, beta, nightly.
+type Channel string
+
+const (
+	// ChannelStable offers releases without a prerelease suffix.
+	ChannelStable Channel = "stable"
+	// ChannelBeta adds alpha, beta and release-candidate prereleases.
+	ChannelBeta Channel = "beta"
+	// ChannelNightly adds every other prerelease, e.g. v1.5.0-nightly.20240601.
+	ChannelNightly Channel = "nightly"
+)
+
+// ParseChannel accepts a channel name; empty selects stable.
+func ParseChannel(s string) (Channel, error) {
+	switch c := Channel(strings.ToLower(strings.TrimSpace(s))); c {
+	case "":
+		return ChannelStable, nil
+	case ChannelStable, ChannelBeta, ChannelNightly:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown release channel %q (want stable, beta or nightly)", s)
+}
+
+func (c Channel) rank() int {
+	switch c {
+	case ChannelBeta:
+		return 1
+	case ChannelNightly:
+		return 2
+	}
+	return 0
+}
+
+// channelOf returns the narrowest channel that offers a release with
+// version v. A release GitHub marks as a prerelease is at least beta.
+func channelOf(r *Release, v semver.Version) Channel {
+	if len(v.Prerelease) == 0 {
+		if r.Prerelease {
+			return ChannelBeta
+		}
+		return ChannelStable
+	}
+	switch strings.ToLower(v.Prerelease[0]) {
+	case "alpha", "beta", "rc":
+		return ChannelBeta
+	}
+	return ChannelNightly
+}
+
+// offers reports whether c includes release r with version v.
+func (c Channel) offers(r *Release, v semver.Version) bool {
+	return channelOf(r, v).rank() <= c.rank()
+}
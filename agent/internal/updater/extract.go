@@ -7,10 +7,45 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
 )
 
+// checkEntry rejects archive entries a release never contains: anything but
+// regular files and directories, and names that are absolute or climb out
+// of the archive.
+func checkEntry(name string, regular, dir bool) error {
+	if !regular && !dir {
+		return fmt.Errorf("archive entry %q is not a regular file", name)
+	}
+	clean := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(clean, ":") {
+		return fmt.Errorf("archive entry %q has an unsafe path", name)
+	}
+	return nil
+}
+
+// copyBinary writes at most maxBinarySize bytes of r to a new temp file and
+// returns its path.
+func copyBinary(r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "sessionforge-bin-*")
+	if err != nil {
+		return "", fmt.Errorf("create temp: %w", err)
+	}
+	n, err := io.Copy(tmp, io.LimitReader(r, maxBinarySize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > maxBinarySize {
+		err = fmt.Errorf("binary exceeds the %d byte limit", maxBinarySize)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("extract binary: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
 // extractFromTarGz extracts a named file from a .tar.gz archive into a temp file.
 // Returns the path to the extracted file.
 func extractFromTarGz(archivePath, targetName string) (string, error) {
@@ -27,7 +62,7 @@ func extractFromTarGz(archivePath, targetName string) (string, error) {
 	defer gz.Close()
 
 	tr := tar.NewReader(gz)
-	for {
+	for i := 0; ; i++ {
 		hdr, err := tr.Next()
 		if err == io.EOF {
 			break
@@ -35,23 +70,23 @@ func extractFromTarGz(archivePath, targetName string) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("tar next: %w", err)
 		}
-
-		base := filepath.Base(hdr.Name)
-		if base != targetName {
-			continue
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue // PAX metadata for the whole archive
+		}
+		if i >= maxArchiveFiles {
+			return "", fmt.Errorf("archive has more than %d entries", maxArchiveFiles)
+		}
+		if err := checkEntry(hdr.Name, hdr.Typeflag == tar.TypeReg, hdr.Typeflag == tar.TypeDir); err != nil {
+			return "", err
 		}
 
-		tmp, err := os.CreateTemp("", "sessionforge-bin-*")
-		if err != nil {
-			return "", fmt.Errorf("create temp: %w", err)
+		if path.Base(hdr.Name) != targetName || hdr.Typeflag != tar.TypeReg {
+			continue
 		}
-		if _, err := io.Copy(tmp, tr); err != nil {
-			tmp.Close()
-			os.Remove(tmp.Name())
-			return "", fmt.Errorf("extract binary: %w", err)
+		if hdr.Size > maxBinarySize {
+			return "", fmt.Errorf("binary exceeds the %d byte limit", maxBinarySize)
 		}
-		tmp.Close()
-		return tmp.Name(), nil
+		return copyBinary(tr)
 	}
 
 	return "", fmt.Errorf("binary %q not found in archive", targetName)
@@ -66,29 +101,30 @@ func extractFromZip(archivePath, targetName string) (string, error) {
 	}
 	defer r.Close()
 
+	if len(r.File) > maxArchiveFiles {
+		return "", fmt.Errorf("archive has more than %d entries", maxArchiveFiles)
+	}
 	for _, f := range r.File {
-		base := filepath.Base(f.Name)
-		if !strings.EqualFold(base, targetName) {
+		mode := f.Mode()
+		if err := checkEntry(f.Name, mode.IsRegular(), mode.IsDir()); err != nil {
+			return "", err
+		}
+	}
+	for _, f := range r.File {
+		base := path.Base(strings.ReplaceAll(f.Name, `\`, "/"))
+		if !strings.EqualFold(base, targetName) || !f.Mode().IsRegular() {
 			continue
 		}
+		if f.UncompressedSize64 > maxBinarySize {
+			return "", fmt.Errorf("binary exceeds the %d byte limit", maxBinarySize)
+		}
 
 		rc, err := f.Open()
 		if err != nil {
 			return "", fmt.Errorf("open zip entry: %w", err)
 		}
 		defer rc.Close()
-
-		tmp, err := os.CreateTemp("", "sessionforge-bin-*")
-		if err != nil {
-			return "", fmt.Errorf("create temp: %w", err)
-		}
-		if _, err := io.Copy(tmp, rc); err != nil {
-			tmp.Close()
-			os.Remove(tmp.Name())
-			return "", fmt.Errorf("extract binary: %w", err)
-		}
-		tmp.Close()
-		return tmp.Name(), nil
+		return copyBinary(rc)
 	}
 
 	return "", fmt.Errorf("binary %q not found in zip archive", targetName)
@@ -1,28 +1,46 @@
-// Package updater checks GitHub Releases for a newer version of the agent
-// and replaces the running binary in-place if an update is available.
+// Package updater checks GitHub Releases for a newer version of the agent on
+// the selected release channel and replaces the running binary in-place.
 package updater
 
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/sessionforge/agent/internal/semver"
 )
 
 const (
-	releasesURL = "https://api.github.com/repos/PerryB-GIT/sessionforge/releases/latest"
 	httpTimeout = 30 * time.Second
+	// releasesPerPage and maxReleasePages bound how far back a pinned
+	// version is looked up.
+	releasesPerPage = 100
+	maxReleasePages = 5
 )
 
+// releasesURL lists the published releases, newest first; tests replace it.
+var releasesURL = "https://api.github.com/repos/PerryB-GIT/sessionforge/releases"
+
+// transport is used for every updater request; nil means the default.
+var transport http.RoundTripper
+
+// SetTransport routes release checks and downloads through rt (e.g. a
+// proxy-aware transport).
+func SetTransport(rt http.RoundTripper) {
+	transport = rt
+}
+
 // Release holds the fields we care about from the GitHub releases API.
 type Release struct {
-	TagName string  `json:"tag_name"`
-	Assets  []Asset `json:"assets"`
+	TagName    string  `json:"tag_name"`
+	Draft      bool    `json:"draft"`
+	Prerelease bool    `json:"prerelease"`
+	Assets     []Asset `json:"assets"`
 }
 
 // Asset is a single downloadable file attached to a GitHub release.
@@ -31,40 +49,90 @@ type Asset struct {
 	BrowserDownloadURL string `json:"browser_download_url"`
 }
 
-// CheckLatest fetches the latest release tag from GitHub.
-// Returns the tag string (e.g. "v1.2.0") or an error.
-func CheckLatest() (*Release, error) {
-	client := &http.Client{Timeout: httpTimeout}
-	req, err := http.NewRequest(http.MethodGet, releasesURL, nil)
+// Version parses the release tag.
+func (r *Release) Version() (semver.Version, error) {
+	return semver.Parse(r.TagName)
+}
+
+// CheckLatest returns the highest release available on channel. Drafts and
+// tags that are not semantic versions are skipped.
+func CheckLatest(channel Channel) (*Release, error) {
+	releases, err := fetchReleases(1)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Accept", "application/vnd.github+json")
-	req.Header.Set("User-Agent", "SessionForge-Agent-Updater")
+	var (
+		best    *Release
+		bestVer semver.Version
+	)
+	for i := range releases {
+		r := &releases[i]
+		v, err := r.Version()
+		if err != nil || r.Draft || !channel.offers(r, v) {
+			continue
+		}
+		if best == nil || v.Compare(bestVer) > 0 {
+			best, bestVer = r, v
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no %s release found", channel)
+	}
+	return best, nil
+}
 
-	resp, err := client.Do(req)
+// FindRelease returns the release tagged with version, on any channel.
+func FindRelease(version string) (*Release, error) {
+	want, err := semver.Parse(version)
 	if err != nil {
-		return nil, fmt.Errorf("fetch releases: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned HTTP %d", resp.StatusCode)
+	releases, err := fetchReleases(maxReleasePages)
+	if err != nil {
+		return nil, err
 	}
-
-	var release Release
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return nil, fmt.Errorf("decode release: %w", err)
+	for i := range releases {
+		r := &releases[i]
+		if v, err := r.Version(); err == nil && !r.Draft && v.Compare(want) == 0 {
+			return r, nil
+		}
 	}
-	return &release, nil
+	return nil, fmt.Errorf("release %s not found", want)
 }
 
-// IsNewer returns true if latestTag is a higher version than currentVersion.
-// Both should be semver strings, optionally prefixed with 'v'.
-func IsNewer(currentVersion, latestTag string) bool {
-	cur := strings.TrimPrefix(currentVersion, "v")
-	lat := strings.TrimPrefix(latestTag, "v")
-	return lat > cur // Simple lexicographic compare; works for semver x.y.z
+// fetchReleases reads up to pages pages of the release list.
+func fetchReleases(pages int) ([]Release, error) {
+	client := &http.Client{Transport: transport, Timeout: httpTimeout}
+	var all []Release
+	for page := 1; page <= pages; page++ {
+		url := fmt.Sprintf("%s?per_page=%d&page=%d", releasesURL, releasesPerPage, page)
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("User-Agent", "SessionForge-Agent-Updater")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetch releases: %w", err)
+		}
+		var batch []Release
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GitHub API returned HTTP %d", resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&batch)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode releases: %w", err)
+		}
+		all = append(all, batch...)
+		if len(batch) < releasesPerPage {
+			break
+		}
+	}
+	return all, nil
 }
 
 // assetName returns the expected archive filename for the current platform.
@@ -82,46 +150,22 @@ func assetName() string {
 }
 
 // DownloadAndInstall downloads the new binary from the release and replaces
-// the current executable. The old binary is renamed to <binary>.old so it can
+// the current executable. The archive must match the release's checksums
+// file, which must carry a valid signature from PublicKey; on any failure
+// nothing is installed. The old binary is renamed to <binary>.old so it can
 // be rolled back manually if needed.
 func DownloadAndInstall(release *Release) error {
 	target := assetName()
-	var downloadURL string
-	for _, a := range release.Assets {
-		if a.Name == target {
-			downloadURL = a.BrowserDownloadURL
-			break
-		}
-	}
-	if downloadURL == "" {
-		return fmt.Errorf("no asset named %q found in release %s", target, release.TagName)
-	}
-
-	// Download to a temp file.
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Get(downloadURL)
+	archivePath, err := fetchVerified(release, target)
 	if err != nil {
-		return fmt.Errorf("download: %w", err)
-	}
-	defer resp.Body.Close()
-
-	tmpFile, err := os.CreateTemp("", "sessionforge-update-*")
-	if err != nil {
-		return fmt.Errorf("create temp file: %w", err)
-	}
-	tmpPath := tmpFile.Name()
-	defer os.Remove(tmpPath)
-
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("write download: %w", err)
+		return fmt.Errorf("refusing to install %s: %w", release.TagName, err)
 	}
-	tmpFile.Close()
+	defer os.Remove(archivePath)
 
 	// Extract binary from archive.
-	binaryPath, err := extractBinary(tmpPath, target)
+	binaryPath, err := extractBinary(archivePath, target)
 	if err != nil {
-		return fmt.Errorf("extract: %w", err)
+		return fmt.Errorf("refusing to install %s: extract: %w", release.TagName, err)
 	}
 	defer os.Remove(binaryPath)
 
@@ -0,0 +1,83 @@
+package updater
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func fakeReleases(t *testing.T, releases []Release) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") != "1" {
+			_ = json.NewEncoder(w).Encode([]Release{})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(releases)
+	}))
+	t.Cleanup(srv.Close)
+	orig := releasesURL
+	releasesURL = srv.URL + "/releases"
+	t.Cleanup(func() { releasesURL = orig })
+}
+
+func TestCheckLatest_Channels(t *testing.T) {
+	fakeReleases(t, []Release{
+		{TagName: "v1.11.0-nightly.20240601"},
+		{TagName: "v1.11.0-rc.1"},
+		{TagName: "v1.10.0"},
+		{TagName: "v1.12.0", Draft: true},
+		{TagName: "v1.9.0"},
+		{TagName: "latest"},
+	})
+	for channel, want := range map[Channel]string{
+		ChannelStable:  "v1.10.0",
+		ChannelBeta:    "v1.11.0-rc.1",
+		ChannelNightly: "v1.11.0-rc.1",
+	} {
+		r, err := CheckLatest(channel)
+		if err != nil {
+			t.Fatalf("%s: %v", channel, err)
+		}
+		if r.TagName != want {
+			t.Errorf("%s: latest = %s, want %s", channel, r.TagName, want)
+		}
+	}
+}
+
+func TestCheckLatest_GitHubPrereleaseFlag(t *testing.T) {
+	fakeReleases(t, []Release{{TagName: "v2.0.0", Prerelease: true}, {TagName: "v1.0.0"}})
+	if r, err := CheckLatest(ChannelStable); err != nil || r.TagName != "v1.0.0" {
+		t.Fatalf("stable = %v, %v; want v1.0.0", r, err)
+	}
+	if r, err := CheckLatest(ChannelBeta); err != nil || r.TagName != "v2.0.0" {
+		t.Fatalf("beta = %v, %v; want v2.0.0", r, err)
+	}
+}
+
+func TestFindRelease(t *testing.T) {
+	fakeReleases(t, []Release{{TagName: "v1.5.0"}, {TagName: "1.4.2"}, {TagName: "v1.4.1-beta.1"}})
+	r, err := FindRelease("v1.4.2")
+	if err != nil || r.TagName != "1.4.2" {
+		t.Fatalf("FindRelease(v1.4.2) = %v, %v", r, err)
+	}
+	if _, err := FindRelease("1.3.0"); err == nil {
+		t.Error("missing release found")
+	}
+	if _, err := FindRelease("latest"); err == nil {
+		t.Error("non-semver version accepted")
+	}
+}
+
+func TestParseChannel(t *testing.T) {
+	if c, err := ParseChannel(""); err != nil || c != ChannelStable {
+		t.Errorf(`ParseChannel("") = %q, %v`, c, err)
+	}
+	if c, err := ParseChannel("Beta"); err != nil || c != ChannelBeta {
+		t.Errorf(`ParseChannel("Beta") = %q, %v`, c, err)
+	}
+	if _, err := ParseChannel("edge"); err == nil {
+		t.Error("unknown channel accepted")
+	}
+}
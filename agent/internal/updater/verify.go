@@ -0,0 +1,237 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// PublicKey is the minisign public key that signs release checksums: the
+// base64 line of minisign.pub. Release builds embed it with
+//
+//	-ldflags "-X github.com/sessionforge/agent/internal/updater.PublicKey=RW..."
+//
+// A build without one cannot verify, and so refuses to install, updates.
+var PublicKey = ""
+
+const (
+	// checksumsAsset lists the SHA-256 of every archive in a release;
+	// signatureAsset is its minisign signature.
+	checksumsAsset = "checksums.txt"
+	signatureAsset = checksumsAsset + ".minisig"
+
+	// Download and extraction limits. Release archives are around 10 MB.
+	maxChecksumsSize = 1 << 20
+	maxSignatureSize = 4 << 10
+	maxArchiveSize   = 200 << 20
+	maxBinarySize    = 200 << 20
+	maxArchiveFiles  = 1000
+	downloadTimeout  = 5 * time.Minute
+)
+
+// minisign algorithm tags: "Ed" signs the data itself, "ED" signs its
+// BLAKE2b-512 hash (the default since minisign 0.10).
+var (
+	algEd25519   = [2]byte{'E', 'd'}
+	algPrehashed = [2]byte{'E', 'D'}
+)
+
+// ErrNoPublicKey means the agent was built without an update signing key.
+var ErrNoPublicKey = errors.New("this build has no update signing key, so updates cannot be verified; " +
+	"download the release manually")
+
+// minisignKey is a decoded minisign public key.
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// parsePublicKey accepts the base64 key line, or all of minisign.pub.
+func parsePublicKey(s string) (*minisignKey, error) {
+	line := ""
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "untrusted comment:") {
+			line = l
+		}
+	}
+	if line == "" {
+		return nil, ErrNoPublicKey
+	}
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || [2]byte(raw[:2]) != algEd25519 {
+		return nil, errors.New("embedded update signing key is not a minisign ed25519 public key")
+	}
+	k := &minisignKey{key: ed25519.PublicKey(raw[10:])}
+	copy(k.id[:], raw[2:10])
+	return k, nil
+}
+
+// verifyMinisign checks sig, the contents of a .minisig file, over data, and
+// returns its trusted comment. The global signature binding the trusted
+// comment is verified as well.
+func (k *minisignKey) verifyMinisign(data, sig []byte) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(string(sig), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") ||
+		!strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", errors.New("malformed signature file")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return "", errors.New("malformed signature")
+	}
+	alg, keyID, signature := [2]byte(raw[:2]), raw[2:10], raw[10:]
+	if !bytes.Equal(keyID, k.id[:]) {
+		return "", fmt.Errorf("signed with key %X, not the embedded key %X", keyID, k.id)
+	}
+	msg := data
+	switch alg {
+	case algEd25519:
+	case algPrehashed:
+		sum := blake2b.Sum512(data)
+		msg = sum[:]
+	default:
+		return "", fmt.Errorf("unsupported signature algorithm %q", alg[:])
+	}
+	if !ed25519.Verify(k.key, msg, signature) {
+		return "", errors.New("signature does not match")
+	}
+
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("malformed global signature")
+	}
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(k.key, append(append([]byte(nil), signature...), trusted...), global) {
+		return "", errors.New("trusted comment signature does not match")
+	}
+	return trusted, nil
+}
+
+// signedFor reports whether a trusted comment names tag. Release signatures
+// carry "sessionforge <tag>", as set in .goreleaser.yml.
+func signedFor(trusted, tag string) bool {
+	return tag != "" && slices.Contains(strings.Fields(trusted), tag)
+}
+
+// checksumFor finds name in a sha256sum-style checksums file.
+func checksumFor(checksums []byte, name string) ([]byte, error) {
+	sc := bufio.NewScanner(bytes.NewReader(checksums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("malformed checksum for %s", name)
+		}
+		return sum, nil
+	}
+	return nil, fmt.Errorf("%s is not listed in %s", name, checksumsAsset)
+}
+
+// fetchVerified downloads the archive for this platform after verifying the
+// release's signed checksums, and returns the path of the downloaded file.
+// Nothing is returned unless every check passes.
+func fetchVerified(release *Release, archiveName string) (string, error) {
+	key, err := parsePublicKey(PublicKey)
+	if err != nil {
+		return "", err
+	}
+	urls := map[string]string{}
+	for _, a := range release.Assets {
+		urls[a.Name] = a.BrowserDownloadURL
+	}
+	for _, name := range []string{archiveName, checksumsAsset, signatureAsset} {
+		if urls[name] == "" {
+			return "", fmt.Errorf("release %s has no %s", release.TagName, name)
+		}
+	}
+
+	client := &http.Client{Transport: transport, Timeout: downloadTimeout}
+	checksums, err := downloadBytes(client, urls[checksumsAsset], maxChecksumsSize)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", checksumsAsset, err)
+	}
+	sig, err := downloadBytes(client, urls[signatureAsset], maxSignatureSize)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", signatureAsset, err)
+	}
+	trusted, err := key.verifyMinisign(checksums, sig)
+	if err != nil {
+		return "", fmt.Errorf("verify %s: %w", checksumsAsset, err)
+	}
+	// The tag comes unsigned from the releases API and the archive names
+	// carry no version, so only the signed comment ties these checksums to
+	// it. Without this an older signed release could be served under a newer
+	// tag and slip past the downgrade check.
+	if !signedFor(trusted, release.TagName) {
+		return "", fmt.Errorf("%s is signed for %q, not %s", checksumsAsset, trusted, release.TagName)
+	}
+	want, err := checksumFor(checksums, archiveName)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp("", "sessionforge-update-*")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	h := sha256.New()
+	err = download(client, urls[archiveName], maxArchiveSize, io.MultiWriter(tmp, h))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !bytes.Equal(h.Sum(nil), want) {
+		err = fmt.Errorf("checksum mismatch: got %x, want %x", h.Sum(nil), want)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("download %s: %w", archiveName, err)
+	}
+	return tmp.Name(), nil
+}
+
+func downloadBytes(client *http.Client, url string, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := download(client, url, limit, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// download copies url into w, failing if the body exceeds limit bytes.
+func download(client *http.Client, url string, limit int64, w io.Writer) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%d bytes exceeds the %d byte limit", resp.ContentLength, limit)
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return fmt.Errorf("exceeds the %d byte limit", limit)
+	}
+	return nil
+}
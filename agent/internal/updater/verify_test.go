@@ -0,0 +1,249 @@
+package updater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// testSigner produces minisign keys and signatures like the release tooling.
+type testSigner struct {
+	id   [8]byte
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSigner{priv: priv, pub: pub}
+	copy(s.id[:], "testkey1")
+	return s
+}
+
+func (s *testSigner) publicKey() string {
+	raw := append(append([]byte("Ed"), s.id[:]...), s.pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+}
+
+func (s *testSigner) sign(data []byte, prehash bool, trusted string) []byte {
+	alg, msg := "Ed", data
+	if prehash {
+		sum := blake2b.Sum512(data)
+		alg, msg = "ED", sum[:]
+	}
+	sig := ed25519.Sign(s.priv, msg)
+	global := ed25519.Sign(s.priv, append(append([]byte(nil), sig...), trusted...))
+	return []byte(fmt.Sprintf("untrusted comment: signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), s.id[:]...), sig...)),
+		trusted, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	s := newTestSigner(t)
+	key, err := parsePublicKey(s.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("abc123  sessionforge_linux_amd64.tar.gz\n")
+	for _, prehash := range []bool{false, true} {
+		if trusted, err := key.verifyMinisign(data, s.sign(data, prehash, "timestamp:1")); err != nil || trusted != "timestamp:1" {
+			t.Errorf("prehash=%v: valid signature rejected: %q, %v", prehash, trusted, err)
+		}
+	}
+
+	if _, err := key.verifyMinisign([]byte("tampered"), s.sign(data, true, "t")); err == nil {
+		t.Error("signature accepted for different data")
+	}
+	forged := bytes.Replace(s.sign(data, true, "timestamp:1"), []byte("timestamp:1"), []byte("timestamp:2"), 1)
+	if _, err := key.verifyMinisign(data, forged); err == nil {
+		t.Error("altered trusted comment accepted")
+	}
+	other := newTestSigner(t)
+	copy(other.id[:], "otherkey")
+	if _, err := key.verifyMinisign(data, other.sign(data, true, "t")); err == nil {
+		t.Error("signature from another key accepted")
+	}
+	if _, err := parsePublicKey(""); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("empty key: err = %v, want ErrNoPublicKey", err)
+	}
+}
+
+func tarGz(t *testing.T, entries ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, h := range entries {
+		body := []byte("binary")
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(body))
+		}
+		h.Mode = 0755
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			tw.Write(body)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// fakeRelease serves archive, its checksums and their signature, and points
+// PublicKey at signer.
+func fakeRelease(t *testing.T, signer *testSigner, archive []byte, mutate func(assets map[string][]byte)) *Release {
+	t.Helper()
+	name := assetName()
+	sum := sha256.Sum256(archive)
+	checksums := []byte(fmt.Sprintf("%x  other.zip\n%x  %s\n", sha256.Sum256(nil), sum, name))
+	assets := map[string][]byte{
+		name:           archive,
+		checksumsAsset: checksums,
+		signatureAsset: signer.sign(checksums, true, "sessionforge v1.2.3"),
+	}
+	if mutate != nil {
+		mutate(assets)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := assets[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	orig := PublicKey
+	PublicKey = signer.publicKey()
+	t.Cleanup(func() { PublicKey = orig })
+
+	rel := &Release{TagName: "v1.2.3"}
+	for n := range assets {
+		rel.Assets = append(rel.Assets, Asset{Name: n, BrowserDownloadURL: srv.URL + "/" + n})
+	}
+	return rel
+}
+
+// signedAs re-signs the checksums with the trusted comment comment.
+func signedAs(signer *testSigner, comment string) func(map[string][]byte) {
+	return func(a map[string][]byte) { a[signatureAsset] = signer.sign(a[checksumsAsset], true, comment) }
+}
+
+func TestFetchVerified(t *testing.T) {
+	signer := newTestSigner(t)
+	archive := tarGz(t, &tar.Header{Name: "sessionforge", Typeflag: tar.TypeReg})
+
+	path, err := fetchVerified(fakeRelease(t, signer, archive, nil), assetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, archive) {
+		t.Fatal("downloaded archive differs")
+	}
+
+	for name, mutate := range map[string]func(map[string][]byte){
+		"tampered archive": func(a map[string][]byte) { a[assetName()] = append(a[assetName()], 0) },
+		"forged checksums": func(a map[string][]byte) { a[checksumsAsset] = append(a[checksumsAsset], '\n') },
+		"unsigned":         func(a map[string][]byte) { delete(a, signatureAsset) },
+		"wrong key":        func(a map[string][]byte) { a[signatureAsset] = newTestSigner(t).sign(a[checksumsAsset], true, "t") },
+		// Validly signed, but not for the tag the API reported.
+		"older release":  signedAs(signer, "sessionforge v1.2.2"),
+		"no version":     signedAs(signer, "timestamp:1"),
+		"version prefix": signedAs(signer, "sessionforge v1.2.3-rc1"),
+	} {
+		if path, err := fetchVerified(fakeRelease(t, signer, archive, mutate), assetName()); err == nil {
+			os.Remove(path)
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	rel := fakeRelease(t, signer, archive, nil)
+	PublicKey = ""
+	if _, err := fetchVerified(rel, assetName()); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("no embedded key: err = %v, want ErrNoPublicKey", err)
+	}
+}
+
+func TestDownload_Limit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush() // chunked: no Content-Length to check up front
+		w.Write(make([]byte, 100))
+	}))
+	defer srv.Close()
+	if _, err := downloadBytes(srv.Client(), srv.URL, 99); err == nil {
+		t.Error("oversized body accepted")
+	}
+	if b, err := downloadBytes(srv.Client(), srv.URL, 100); err != nil || len(b) != 100 {
+		t.Errorf("body at the limit: %d bytes, %v", len(b), err)
+	}
+}
+
+func TestExtract_RejectsHostileEntries(t *testing.T) {
+	dir := t.TempDir()
+	for name, hdr := range map[string]*tar.Header{
+		"symlink":   {Name: "sessionforge", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		"hardlink":  {Name: "x", Typeflag: tar.TypeLink, Linkname: "sessionforge"},
+		"device":    {Name: "null", Typeflag: tar.TypeChar},
+		"traversal": {Name: "../../sessionforge", Typeflag: tar.TypeReg},
+		"absolute":  {Name: "/usr/bin/sessionforge", Typeflag: tar.TypeReg},
+	} {
+		p := filepath.Join(dir, name+".tar.gz")
+		os.WriteFile(p, tarGz(t, hdr, &tar.Header{Name: "sessionforge", Typeflag: tar.TypeReg}), 0600)
+		if out, err := extractFromTarGz(p, "sessionforge"); err == nil {
+			os.Remove(out)
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	p := filepath.Join(dir, "ok.tar.gz")
+	os.WriteFile(p, tarGz(t, &tar.Header{Name: "dist/", Typeflag: tar.TypeDir},
+		&tar.Header{Name: "dist/sessionforge", Typeflag: tar.TypeReg}), 0600)
+	out, err := extractFromTarGz(p, "sessionforge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out)
+	if got, _ := os.ReadFile(out); string(got) != "binary" {
+		t.Errorf("extracted %q", got)
+	}
+}
+
+func TestExtractFromZip_RejectsSymlink(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	h := &zip.FileHeader{Name: "sessionforge.exe"}
+	h.SetMode(os.ModeSymlink | 0777)
+	w, _ := zw.CreateHeader(h)
+	w.Write([]byte("C:/Windows/System32/cmd.exe"))
+	zw.Close()
+
+	p := filepath.Join(t.TempDir(), "a.zip")
+	os.WriteFile(p, buf.Bytes(), 0600)
+	if out, err := extractFromZip(p, "sessionforge.exe"); err == nil {
+		os.Remove(out)
+		t.Error("symlink entry accepted")
+	}
+}